@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -73,20 +75,45 @@ func parseMetadataLevel(acceptHeader string) string {
 
 // API handles HTTP requests
 type API struct {
-	store    *DocumentStore
-	embedder Embedder
-	config   *Config
+	store     *DocumentStore
+	embedders Embedders
+	reranker  Reranker // nil when reranking is not configured
+	tokens    TokenCounter
+	metrics   *Metrics
+	config    *Config
 }
 
-// NewAPI creates a new API instance
+// NewAPI creates a new API instance with embedder as the default embedder
 func NewAPI(store *DocumentStore, embedder Embedder, config *Config) *API {
 	return &API{
-		store:    store,
-		embedder: embedder,
-		config:   config,
+		store:     store,
+		embedders: Embedders{DefaultEmbedderName: embedder},
+		tokens:    wordTokenCounter{},
+		metrics:   NewMetrics(),
+		config:    config,
 	}
 }
 
+// SetEmbedders sets the embedders tables can select in their settings
+func (a *API) SetEmbedders(embedders Embedders) {
+	a.embedders = embedders
+}
+
+// SetTokenCounter sets how assembled contexts are measured against their budget
+func (a *API) SetTokenCounter(counter TokenCounter) {
+	a.tokens = counter
+}
+
+// SetMetrics sets where request, search and embedder metrics are recorded
+func (a *API) SetMetrics(metrics *Metrics) {
+	a.metrics = metrics
+}
+
+// SetReranker sets the reranker used by searches that ask for reranking
+func (a *API) SetReranker(reranker Reranker) {
+	a.reranker = reranker
+}
+
 // StoreDocument creates or updates a document in a database table
 // POST /db/{dbName}/{tableName}
 func (a *API) StoreDocument(w http.ResponseWriter, r *http.Request) {
@@ -110,48 +137,18 @@ func (a *API) StoreDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Content == "" {
-		a.errorResponse(w, http.StatusBadRequest, "content is required")
+	if err := validateStoreDocumentRequest(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	doc := &Document{
-		ID:       req.ID,
-		Content:  req.Content,
-		Metadata: req.Metadata,
-		Tags:     req.Tags,
-	}
-
-	// Check if immediate (sync) embedding is requested
-	// Config features take precedence - header can't override disabled features
-	embeddingEnabled := a.config.Features["embedding"]
-	if embeddingEnabled {
-		// Sync embedding is enabled in config, check client preference
-		clientFeatures := parseClientFeatures(r.Header.Get(ClientFeatures))
-		clientEmbedValue, clientRequestsEmbed := clientFeatures["embed"]
-
-		// Sync embed if client requests it (or doesn't specify async)
-		shouldEmbed := !clientRequestsEmbed || clientEmbedValue == "" || clientEmbedValue == "sync"
-
-		if shouldEmbed {
-			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-			defer cancel()
-
-			vector, err := a.embedder.Embed(ctx, req.Content)
-			if err != nil {
-				a.errorResponse(w, http.StatusInternalServerError,
-					fmt.Sprintf("embedding failed: %v", err))
-				return
-			}
-			doc.Vector = vector
-			doc.IsEmbedded = true
+	doc, err := a.storeDocument(r.Context(), dbName, tableName, &req, a.shouldEmbedSync(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrDimensionMismatch) || errors.Is(err, ErrChunkID) {
+			status = http.StatusBadRequest
 		}
-	}
-	// Non-embedded documents will be picked up by background worker if embedding_job is enabled
-
-	if err := a.store.StoreDocument(dbName, tableName, doc); err != nil {
-		a.errorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to store document: %v", err))
+		a.errorResponse(w, status, err.Error())
 		return
 	}
 
@@ -189,7 +186,80 @@ func (a *API) StoreDocument(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(doc)
 }
 
-// GetDocument retrieves a document by ID
+// validateStoreDocumentRequest checks a request to store a document
+func validateStoreDocumentRequest(req *StoreDocumentRequest) error {
+	if req.Content == "" {
+		return fmt.Errorf("content is required")
+	}
+
+	if req.Chunking != nil {
+		if err := req.Chunking.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeDocument chunks a validated request with the table's settings and
+// stores it, embedding it first when embedSync is set
+func (a *API) storeDocument(ctx context.Context, dbName, tableName string, req *StoreDocumentRequest, embedSync bool) (*Document, error) {
+	settings, err := a.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table settings: %w", err)
+	}
+
+	// The request's chunking options take precedence over the table's
+	chunking := settings.Chunking
+	if req.Chunking != nil {
+		chunking = req.Chunking
+	}
+
+	doc := &Document{
+		ID:       req.ID,
+		Content:  req.Content,
+		Metadata: req.Metadata,
+		Tags:     req.Tags,
+	}
+	applyChunking(doc, chunking)
+
+	if embedSync {
+		embedCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		embedder, err := a.embedders.Get(settings.Embedder)
+		if err == nil {
+			err = a.embedDocuments(embedCtx, embedder, []*Document{doc})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("embedding failed: %w", err)
+		}
+	}
+	// Non-embedded documents will be picked up by background worker if embedding_job is enabled
+
+	if err := a.store.StoreDocument(dbName, tableName, doc); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// shouldEmbedSync reports whether documents in this request are embedded before they are stored
+// Config features take precedence - header can't override disabled features
+func (a *API) shouldEmbedSync(r *http.Request) bool {
+	if !a.config.Features["embedding"] {
+		return false
+	}
+
+	// Sync embedding is enabled in config, check client preference
+	clientFeatures := parseClientFeatures(r.Header.Get(ClientFeatures))
+	clientEmbedValue, clientRequestsEmbed := clientFeatures["embed"]
+
+	// Sync embed if client requests it (or doesn't specify async)
+	return !clientRequestsEmbed || clientEmbedValue == "" || clientEmbedValue == "sync"
+}
+
+// GetDocument retrieves a document by ID, or one of its versions with ?version=N
 // GET /db/{dbName}/{tableName}/{docId}
 func (a *API) GetDocument(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -197,10 +267,23 @@ func (a *API) GetDocument(w http.ResponseWriter, r *http.Request) {
 	tableName := vars["tableName"]
 	docId := vars["docId"]
 
-	doc, err := a.store.GetDocument(dbName, tableName, docId)
+	version, err := parseVersion(r)
+	if err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var doc *Document
+	if version > 0 {
+		doc, err = a.store.GetDocumentVersion(dbName, tableName, docId, version)
+	} else {
+		doc, err = a.store.GetDocument(dbName, tableName, docId)
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			a.errorResponse(w, http.StatusNotFound, "document not found")
+		} else if strings.Contains(err.Error(), "invalid table name") {
+			a.errorResponse(w, http.StatusBadRequest, err.Error())
 		} else {
 			a.errorResponse(w, http.StatusInternalServerError, err.Error())
 		}
@@ -219,7 +302,9 @@ func (a *API) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 	docId := vars["docId"]
 
 	if err := a.store.DeleteDocument(dbName, tableName, docId); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, ErrChunkID) {
+			a.errorResponse(w, http.StatusBadRequest, err.Error())
+		} else if strings.Contains(err.Error(), "not found") {
 			a.errorResponse(w, http.StatusNotFound, "document not found")
 		} else {
 			a.errorResponse(w, http.StatusInternalServerError, err.Error())
@@ -243,75 +328,183 @@ func (a *API) SearchDocuments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Query == "" {
-		a.errorResponse(w, http.StatusBadRequest, "query is required")
+	if err := validateSearchRequest(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	results, err := a.searchTable(r.Context(), dbName, tableName, req)
+	if err != nil {
+		a.searchErrorResponse(w, err)
+		return
+	}
+
+	// Add ranks to results
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+
+	response := SearchResponse{
+		Results: results,
+		Query:   req.Query,
+		Type:    req.Type,
+		DB:      dbName,
+		Total:   len(results),
+	}
+
+	a.jsonResponse(w, http.StatusOK, response)
+}
+
+// validateSearchRequest checks a search request and fills in defaults
+func validateSearchRequest(req *SearchRequest) error {
+	if req.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+
+	switch req.Type {
+	case "":
+		req.Type = SearchTypeFullText
+	case SearchTypeFullText, SearchTypeVector, SearchTypeHybrid:
+	default:
+		return fmt.Errorf("invalid search type: %s", req.Type)
+	}
+
 	if req.Limit <= 0 {
 		req.Limit = 10
 	}
 
+	if req.Highlight != nil && (req.Highlight.SnippetTokens < 0 || req.Highlight.SnippetTokens > maxSnippetTokens) {
+		return fmt.Errorf("snippet_tokens must be between 1 and %d", maxSnippetTokens)
+	}
+
+	if req.Rerank != nil {
+		if err := req.Rerank.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if req.Hybrid != nil {
+		if err := req.Hybrid.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if req.Diversify != nil {
+		if req.Type != SearchTypeVector {
+			return fmt.Errorf("diversify requires vector search")
+		}
+		if err := req.Diversify.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// searchTable runs a validated search request against one table, collapsing
+// and highlighting the results as requested
+func (a *API) searchTable(ctx context.Context, dbName, tableName string, req SearchRequest) ([]SearchResult, error) {
+	defer a.metrics.searchDuration.ObserveSince(time.Now(), string(req.Type))
+
+	// Fetch extra results when collapsing, as several may share a parent
+	limit := req.Limit
+	if req.Collapse {
+		req.Limit *= collapseOversample
+	}
+
+	// Retrieve a wider candidate set for the reranker
+	rerankLimit := req.Limit
+	var rerank RerankOptions
+	if req.Rerank != nil {
+		if a.reranker == nil {
+			return nil, ErrRerankerNotConfigured
+		}
+		rerank = normalizeRerankOptions(*req.Rerank, req.Limit)
+		req.Limit = rerank.TopN
+	}
+
 	var results []SearchResult
+	var queryVector []float32
 	var err error
 
-	switch req.Type {
-	case SearchTypeFullText, "":
-		// Default to full-text search
-		results, err = a.store.SearchFullText(dbName, tableName, req.Query, req.Limit, req.Filters)
+	if req.Type == SearchTypeVector || req.Type == SearchTypeHybrid {
+		// Convert query to vector
+		embedCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		queryVector, err = a.embedQuery(embedCtx, dbName, tableName, req.Query)
+		cancel()
 		if err != nil {
-			a.errorResponse(w, http.StatusInternalServerError,
-				fmt.Sprintf("full-text search failed: %v", err))
-			return
+			return nil, fmt.Errorf("failed to embed query: %w", err)
 		}
+	}
 
+	switch req.Type {
 	case SearchTypeVector:
-		// Convert query to vector
-		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-		defer cancel()
+		if req.Diversify == nil {
+			results, err = a.store.SearchVector(dbName, tableName, queryVector, req.Limit, req.Filters, req.VectorOptions())
+			if err != nil {
+				return nil, fmt.Errorf("vector search failed: %w", err)
+			}
+			break
+		}
 
-		queryVector, err := a.embedder.Embed(ctx, req.Query)
+		// Fetch a wider candidate set and re-rank it for diversity
+		opts := normalizeDiversifyOptions(*req.Diversify, req.Limit)
+		results, err = a.store.SearchVector(dbName, tableName, queryVector, opts.FetchK, req.Filters, req.VectorOptions())
 		if err != nil {
-			a.errorResponse(w, http.StatusInternalServerError,
-				fmt.Sprintf("failed to embed query: %v", err))
-			return
+			return nil, fmt.Errorf("vector search failed: %w", err)
 		}
-
-		results, err = a.store.SearchVector(dbName, tableName, queryVector, req.Limit, req.Filters)
+		results, err = a.store.DiversifyMMR(dbName, tableName, queryVector, results, *opts.Lambda, req.Limit)
 		if err != nil {
-			a.errorResponse(w, http.StatusInternalServerError,
-				fmt.Sprintf("vector search failed: %v", err))
-			return
+			return nil, fmt.Errorf("failed to diversify results: %w", err)
 		}
 
 	case SearchTypeHybrid:
-		// TODO: Implement hybrid search (combine full-text + vector)
-		// 1. Do full-text search to get candidates
-		// 2. Re-rank using vector similarity
-		// 3. Merge results
-		a.errorResponse(w, http.StatusNotImplemented, "hybrid search not yet implemented")
-		return
+		// Run full-text and vector search, then fuse the rankings
+		var opts HybridOptions
+		if req.Hybrid != nil {
+			opts = *req.Hybrid
+		}
+
+		results, err = a.store.SearchHybrid(dbName, tableName, req.Query, queryVector, req.Limit, opts, req.VectorOptions(), req.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("hybrid search failed: %w", err)
+		}
 
 	default:
-		a.errorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("invalid search type: %s", req.Type))
-		return
+		// Default to full-text search
+		results, err = a.store.SearchFullText(dbName, tableName, req.Query, req.Limit, req.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("full-text search failed: %w", err)
+		}
 	}
 
-	// Add ranks to results
-	for i := range results {
-		results[i].Rank = i + 1
+	if req.Rerank != nil {
+		results, err = a.rerankResults(ctx, req.Query, results, rerank.TopN)
+		if err != nil {
+			return nil, fmt.Errorf("rerank failed: %w", err)
+		}
+		if len(results) > rerankLimit {
+			results = results[:rerankLimit]
+		}
 	}
 
-	response := SearchResponse{
-		Results: results,
-		Query:   req.Query,
-		Type:    req.Type,
-		DB:      dbName,
-		Total:   len(results),
+	if req.Collapse {
+		results, err = a.store.CollapseChunks(dbName, tableName, results, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to collapse chunks: %w", err)
+		}
 	}
 
-	a.jsonResponse(w, http.StatusOK, response)
+	if req.Highlight != nil {
+		highlightCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := a.highlightResults(highlightCtx, dbName, tableName, &req, queryVector, results); err != nil {
+			return nil, fmt.Errorf("failed to highlight results: %w", err)
+		}
+	}
+
+	return results, nil
 }
 
 // ListDatabases lists all available databases
@@ -324,6 +517,11 @@ func (a *API) ListDatabases(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Keys only see the databases they may read
+	databases = slices.DeleteFunc(databases, func(info DBInfo) bool {
+		return authorize(r.Context(), info.Name, ScopeRead) != nil
+	})
+
 	a.jsonResponse(w, http.StatusOK, map[string]interface{}{
 		"databases": databases,
 		"count":     len(databases),
@@ -477,6 +675,39 @@ func (a *API) errorResponse(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// searchErrorResponse reports a failed search, using 400 for invalid filters
+// and query vectors that do not fit the table
+func (a *API) searchErrorResponse(w http.ResponseWriter, err error) {
+	var filterErr *FilterError
+	if errors.As(err, &filterErr) {
+		a.jsonResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:   http.StatusText(http.StatusBadRequest),
+			Message: filterErr.Error(),
+			Field:   filterErr.Key,
+		})
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrInvalidFilter) || errors.Is(err, ErrDimensionMismatch) || errors.Is(err, ErrRerankerNotConfigured) {
+		status = http.StatusBadRequest
+	}
+	a.errorResponse(w, status, err.Error())
+}
+
+// embedQuery embeds a search query with the embedder selected by the table
+func (a *API) embedQuery(ctx context.Context, dbName, tableName, query string) ([]float32, error) {
+	settings, err := a.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	embedder, err := a.embedders.Get(settings.Embedder)
+	if err != nil {
+		return nil, err
+	}
+	return embedder.Embed(ctx, query)
+}
+
 // Background embedding queue (stub)
 // TODO: Implement background job processing
 // Options:
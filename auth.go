@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// systemDatabase holds llmdb's own data, such as API keys. It is hidden from
+// database listings and cannot be reached through the API.
+const systemDatabase = "_system"
+
+// API key scopes; each one includes the ones before it
+const (
+	ScopeRead  = "read"  // Get, list, export and search documents
+	ScopeWrite = "write" // Store, import and delete documents, change table settings
+	ScopeAdmin = "admin" // Delete databases and manage API keys
+)
+
+// ScopeMetrics only grants scraping /metrics, so monitoring does not need an
+// admin key. Admin keys include it; read and write keys do not.
+const ScopeMetrics = "metrics"
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// apiKeyPrefix starts every API key, making leaked keys easy to spot
+const apiKeyPrefix = "llmdb_"
+
+// ErrForbidden is returned when an API key does not grant an operation
+var ErrForbidden = errors.New("forbidden")
+
+const apiKeysSchema = `
+	CREATE TABLE IF NOT EXISTS _api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		databases TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)
+`
+
+// APIKey grants access to the API. Only a hash of the key itself is stored;
+// Key is set once, in the response that mints it.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`    // "read", "write", "admin" and/or "metrics"
+	Databases []string  `json:"databases"` // Database name patterns, "*" and "?" are wildcards
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"`
+}
+
+// CreateAPIKeyRequest asks for a new API key
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Databases []string `json:"databases"`
+}
+
+// Validate checks the scopes and database patterns of a key request
+func (r *CreateAPIKeyRequest) Validate() error {
+	if len(r.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range r.Scopes {
+		if scopeLevels[scope] == 0 && scope != ScopeMetrics {
+			return fmt.Errorf("invalid scope %q, must be read, write, admin or metrics", scope)
+		}
+	}
+
+	if len(r.Databases) == 0 {
+		return fmt.Errorf("at least one database pattern is required, \"*\" for all")
+	}
+	for _, pattern := range r.Databases {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid database pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// hasScope reports whether the key grants scope or a scope including it
+func (k *APIKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin || (scopeLevels[scope] > 0 && scopeLevels[s] >= scopeLevels[scope]) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsDatabase reports whether a database matches one of the key's patterns
+func (k *APIKey) allowsDatabase(db string) bool {
+	if db == systemDatabase {
+		return false
+	}
+	for _, pattern := range k.Databases {
+		if ok, _ := path.Match(pattern, db); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey returns the stored form of a key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// systemDB opens the system database, creating the API key table if needed
+func (s *DocumentStore) systemDB() (*sql.DB, func(), error) {
+	db, release, err := s.getDB(systemDatabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := db.Exec(apiKeysSchema); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to create API key table: %w", err)
+	}
+	return db, release, nil
+}
+
+// CreateAPIKey mints a key for a validated request. The returned key carries
+// the secret, which cannot be recovered later.
+func (s *DocumentStore) CreateAPIKey(req *CreateAPIKeyRequest) (*APIKey, error) {
+	db, release, err := s.systemDB()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		Databases: req.Databases,
+		CreatedAt: time.Now().UTC(),
+		Key:       apiKeyPrefix + hex.EncodeToString(secret),
+	}
+
+	scopesJSON, _ := json.Marshal(key.Scopes)
+	databasesJSON, _ := json.Marshal(key.Databases)
+	_, err = db.Exec(`INSERT INTO _api_keys (id, name, key_hash, scopes, databases, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, hashAPIKey(key.Key), string(scopesJSON), string(databasesJSON), key.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// scanAPIKey reads a key row of id, name, scopes, databases and created_at
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+	var scopesJSON, databasesJSON string
+	if err := row.Scan(&key.ID, &key.Name, &scopesJSON, &databasesJSON, &key.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopesJSON), &key.Scopes); err != nil {
+		return nil, fmt.Errorf("invalid scopes of API key %s: %w", key.ID, err)
+	}
+	if err := json.Unmarshal([]byte(databasesJSON), &key.Databases); err != nil {
+		return nil, fmt.Errorf("invalid databases of API key %s: %w", key.ID, err)
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns all keys, oldest first, without their secrets
+func (s *DocumentStore) ListAPIKeys() ([]*APIKey, error) {
+	db, release, err := s.systemDB()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := db.Query(`SELECT id, name, scopes, databases, created_at FROM _api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// LookupAPIKey returns the key with the given secret
+func (s *DocumentStore) LookupAPIKey(secret string) (*APIKey, error) {
+	db, release, err := s.systemDB()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	row := db.QueryRow(`SELECT id, name, scopes, databases, created_at FROM _api_keys WHERE key_hash = ?`, hashAPIKey(secret))
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("API key not found")
+	}
+	return key, err
+}
+
+// RevokeAPIKey deletes a key, which stops authenticating immediately
+func (s *DocumentStore) RevokeAPIKey(id string) error {
+	db, release, err := s.systemDB()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	result, err := db.Exec(`DELETE FROM _api_keys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("API key not found: %s", id)
+	}
+	return nil
+}
+
+// apiKeyContextKey stores the authenticated key in a request context
+type apiKeyContextKey struct{}
+
+// apiKeyFromContext returns the key that authenticated a request, nil when
+// authentication is disabled or the request did not come through HTTP
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// authorize checks that the request's key grants scope on db. Without a key
+// every operation is allowed.
+func authorize(ctx context.Context, db, scope string) error {
+	key := apiKeyFromContext(ctx)
+	if key == nil {
+		return nil
+	}
+	if !key.hasScope(scope) {
+		return fmt.Errorf("%w: API key lacks the %s scope", ErrForbidden, scope)
+	}
+	if db != "" && !key.allowsDatabase(db) {
+		return fmt.Errorf("%w: API key has no access to database %s", ErrForbidden, db)
+	}
+	return nil
+}
+
+// requiredScope returns the scope a route needs, "" for public routes
+func requiredScope(r *http.Request) string {
+	template := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+
+	switch {
+	case template == "/health":
+		return ""
+	case strings.HasPrefix(template, "/_keys"):
+		return ScopeAdmin
+	case template == "/metrics":
+		return ScopeMetrics
+	case template == "/db/{dbName}" && r.Method == http.MethodDelete:
+		return ScopeAdmin
+	case r.Method == http.MethodGet:
+		return ScopeRead
+	case strings.HasSuffix(template, "search") || strings.HasSuffix(template, "/_context") || template == "/mcp":
+		return ScopeRead // Reads sent as POST; MCP tools check write access themselves
+	default:
+		return ScopeWrite
+	}
+}
+
+// authenticate returns the key for a bearer token: the configured admin key
+// or a stored one
+func (a *API) authenticate(token string) (*APIKey, error) {
+	if a.config.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.config.AdminAPIKey)) == 1 {
+		return &APIKey{ID: "admin_api_key", Name: "admin_api_key", Scopes: []string{ScopeAdmin}, Databases: []string{"*"}}, nil
+	}
+	return a.store.LookupAPIKey(token)
+}
+
+// authMiddleware rejects requests for the system database and, when
+// auth_enabled is set, requests without a bearer API key granting the route's
+// scope on its database. Key management needs an admin key for all databases,
+// metrics a metrics or admin key for all databases.
+func (a *API) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dbName := mux.Vars(r)["dbName"]
+		if dbName == systemDatabase {
+			a.errorResponse(w, http.StatusForbidden, "the system database is not accessible")
+			return
+		}
+
+		scope := requiredScope(r)
+		if !a.config.AuthEnabled || scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="llmdb"`)
+			a.errorResponse(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		key, err := a.authenticate(token)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="llmdb", error="invalid_token"`)
+				a.errorResponse(w, http.StatusUnauthorized, "invalid API key")
+			} else {
+				a.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to check API key: %v", err))
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, key)
+		if err := authorize(ctx, dbName, scope); err != nil {
+			a.errorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if (scope == ScopeAdmin || scope == ScopeMetrics) && dbName == "" && !slices.Contains(key.Databases, "*") {
+			a.errorResponse(w, http.StatusForbidden, `key management and metrics need a key for database pattern "*"`)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CreateAPIKey mints an API key; the response is the only place its secret appears
+// POST /_keys
+func (a *API) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, err := a.store.CreateAPIKey(&req)
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.jsonResponse(w, http.StatusCreated, key)
+}
+
+// ListAPIKeys lists API keys without their secrets
+// GET /_keys
+func (a *API) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.store.ListAPIKeys()
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to list API keys: %v", err))
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// RevokeAPIKey deletes an API key
+// DELETE /_keys/{keyId}
+func (a *API) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := a.store.RevokeAPIKey(mux.Vars(r)["keyId"]); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			a.errorResponse(w, http.StatusNotFound, err.Error())
+		} else {
+			a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
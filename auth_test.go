@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyAccess(t *testing.T) {
+	key := &APIKey{Scopes: []string{ScopeWrite}, Databases: []string{"team-*", "shared"}}
+
+	tests := []struct {
+		db    string
+		scope string
+		want  bool
+	}{
+		{"team-a", ScopeRead, true},
+		{"team-a", ScopeWrite, true},
+		{"team-a", ScopeAdmin, false},
+		{"shared", ScopeWrite, true},
+		{"shared-2", ScopeRead, false},
+		{"other", ScopeRead, false},
+		{systemDatabase, ScopeRead, false},
+		{"team-a", ScopeMetrics, false},
+	}
+
+	for _, tt := range tests {
+		if got := key.hasScope(tt.scope) && key.allowsDatabase(tt.db); got != tt.want {
+			t.Errorf("%s on %s = %v, want %v", tt.scope, tt.db, got, tt.want)
+		}
+	}
+
+	monitor := &APIKey{Scopes: []string{ScopeMetrics}}
+	admin := &APIKey{Scopes: []string{ScopeAdmin}}
+	if !monitor.hasScope(ScopeMetrics) || monitor.hasScope(ScopeRead) || !admin.hasScope(ScopeMetrics) {
+		t.Errorf("the metrics scope should only be granted by metrics and admin keys")
+	}
+
+	invalid := []CreateAPIKeyRequest{
+		{Scopes: nil, Databases: []string{"*"}},
+		{Scopes: []string{"owner"}, Databases: []string{"*"}},
+		{Scopes: []string{ScopeRead}, Databases: nil},
+		{Scopes: []string{ScopeRead}, Databases: []string{"team-["}},
+	}
+	for _, req := range invalid {
+		if err := req.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", req)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	config := &Config{Features: map[string]bool{}, AuthEnabled: true, AdminAPIKey: "root-key"}
+	router := newRouter(NewAPI(store, &batchEmbedder{}, config), config)
+
+	do := func(t *testing.T, method, path, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	mint := func(t *testing.T, adminKey, body string) APIKey {
+		t.Helper()
+		rec := do(t, http.MethodPost, "/_keys", adminKey, body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("minting %s: status = %d: %s", body, rec.Code, rec.Body.String())
+		}
+		var key APIKey
+		if err := json.NewDecoder(rec.Body).Decode(&key); err != nil || !strings.HasPrefix(key.Key, apiKeyPrefix) {
+			t.Fatalf("minted key = %+v, %v", key, err)
+		}
+		return key
+	}
+
+	reader := mint(t, "root-key", `{"name": "reader", "scopes": ["read"], "databases": ["team-*"]}`)
+	writer := mint(t, "root-key", `{"name": "writer", "scopes": ["write"], "databases": ["team-a"]}`)
+	monitor := mint(t, "root-key", `{"name": "monitor", "scopes": ["metrics"], "databases": ["*"]}`)
+	teamMonitor := mint(t, "root-key", `{"name": "team monitor", "scopes": ["metrics"], "databases": ["team-*"]}`)
+
+	for _, db := range []string{"team-a", "other"} {
+		if rec := do(t, http.MethodPost, "/db/"+db+"/notes", "root-key", `{"id": "n1", "content": "quarterly report"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	t.Run("Requests need a valid key", func(t *testing.T) {
+		if rec := do(t, http.MethodGet, "/health", "", ""); rec.Code != http.StatusOK {
+			t.Errorf("health: status = %d, want 200", rec.Code)
+		}
+		rec := do(t, http.MethodGet, "/db", "", "")
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("no key: status = %d, want 401 with a challenge", rec.Code)
+		}
+		if rec := do(t, http.MethodGet, "/db", "llmdb_guess", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("unknown key: status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("Scopes and databases are enforced", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			key    string
+			body   string
+			want   int
+		}{
+			{"Reader gets", http.MethodGet, "/db/team-a/notes/n1", reader.Key, "", http.StatusOK},
+			{"Reader searches", http.MethodPost, "/db/team-a/notes/search", reader.Key, `{"query": "report"}`, http.StatusOK},
+			{"Reader stores", http.MethodPost, "/db/team-a/notes", reader.Key, `{"content": "x"}`, http.StatusForbidden},
+			{"Reader outside its databases", http.MethodGet, "/db/other/notes/n1", reader.Key, "", http.StatusForbidden},
+			{"Reader names another database", http.MethodPost, "/_search", reader.Key, `{"query": "report", "databases": ["other"]}`, http.StatusForbidden},
+			{"Writer stores", http.MethodPost, "/db/team-a/notes", writer.Key, `{"content": "x"}`, http.StatusCreated},
+			{"Writer changes settings", http.MethodPut, "/db/team-a/notes/_settings", writer.Key, `{"metric": "cosine"}`, http.StatusOK},
+			{"Writer deletes a database", http.MethodDelete, "/db/team-a", writer.Key, "", http.StatusForbidden},
+			{"Writer mints keys", http.MethodPost, "/_keys", writer.Key, `{"scopes": ["admin"], "databases": ["*"]}`, http.StatusForbidden},
+			{"System database", http.MethodGet, "/db/_system", "root-key", "", http.StatusForbidden},
+			{"Admin scrapes metrics", http.MethodGet, "/metrics", "root-key", "", http.StatusOK},
+			{"Monitor scrapes metrics", http.MethodGet, "/metrics", monitor.Key, "", http.StatusOK},
+			{"Monitor reads", http.MethodGet, "/db/team-a/notes/n1", monitor.Key, "", http.StatusForbidden},
+			{"Reader scrapes metrics", http.MethodGet, "/metrics", reader.Key, "", http.StatusForbidden},
+			{"Monitor of some databases", http.MethodGet, "/metrics", teamMonitor.Key, "", http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if rec := do(t, tt.method, tt.path, tt.key, tt.body); rec.Code != tt.want {
+					t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("Listings only show permitted databases", func(t *testing.T) {
+		var list struct {
+			Databases []DBInfo `json:"databases"`
+		}
+		json.NewDecoder(do(t, http.MethodGet, "/db", reader.Key, "").Body).Decode(&list)
+		for _, info := range list.Databases {
+			if info.Name != "team-a" {
+				t.Errorf("reader sees database %s", info.Name)
+			}
+		}
+
+		var resp SearchResponse
+		json.NewDecoder(do(t, http.MethodPost, "/_search", reader.Key, `{"query": "report", "databases": ["*"]}`).Body).Decode(&resp)
+		if resp.Total != 1 || resp.Results[0].Document.DB != "team-a" {
+			t.Errorf("global search = %+v", resp.Results)
+		}
+	})
+
+	t.Run("Keys are managed by admins of all databases", func(t *testing.T) {
+		teamAdmin := mint(t, "root-key", `{"name": "team admin", "scopes": ["admin"], "databases": ["team-*"]}`)
+		if rec := do(t, http.MethodGet, "/_keys", teamAdmin.Key, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("team admin lists keys: status = %d, want 403", rec.Code)
+		}
+
+		rec := do(t, http.MethodGet, "/_keys", "root-key", "")
+		if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), apiKeyPrefix) {
+			t.Errorf("listing must not reveal secrets: %d %s", rec.Code, rec.Body.String())
+		}
+		var list struct {
+			Count int `json:"count"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if list.Count != 5 {
+			t.Errorf("count = %d, want 5", list.Count)
+		}
+
+		if rec := do(t, http.MethodDelete, "/_keys/"+writer.ID, "root-key", ""); rec.Code != http.StatusNoContent {
+			t.Fatalf("revoke: status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := do(t, http.MethodGet, "/db/team-a/notes/n1", writer.Key, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("revoked key: status = %d, want 401", rec.Code)
+		}
+		if rec := do(t, http.MethodDelete, "/_keys/"+writer.ID, "root-key", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("revoking twice: status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestCORSOrigins(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	config := &Config{Features: map[string]bool{}, CORSOrigins: []string{"https://app.example.com"}}
+	router := newRouter(NewAPI(store, &batchEmbedder{}, config), config)
+
+	for origin, want := range map[string]string{
+		"https://app.example.com":  "https://app.example.com",
+		"https://evil.example.com": "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("origin %s: Access-Control-Allow-Origin = %q, want %q", origin, got, want)
+		}
+	}
+
+	// Without auth the system database is still off limits
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/db/_system", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("system database: status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultBulkBatchSize = 500
+	maxBulkBatchSize     = 5000
+	maxBulkLineSize      = 16 << 20 // Largest accepted document line (16 MiB)
+	bulkEmbedTimeout     = 2 * time.Minute
+)
+
+// bulkItem is a parsed bulk line waiting to be stored
+type bulkItem struct {
+	line int
+	doc  *Document
+}
+
+// BulkStoreDocuments stores newline-delimited documents in batches
+// POST /db/{dbName}/{tableName}/_bulk?batch_size=500
+// Each non-empty line is a StoreDocumentRequest. Every batch is written in one
+// transaction, and a bad line is reported without aborting the upload.
+func (a *API) BulkStoreDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+	clearDeadlines(w)
+
+	if dbName == "" {
+		a.errorResponse(w, http.StatusBadRequest, "database name is required")
+		return
+	}
+
+	if !isValidTableName(tableName) {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid table name: %s", tableName))
+		return
+	}
+
+	batchSize := defaultBulkBatchSize
+	if value := r.URL.Query().Get("batch_size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxBulkBatchSize {
+			a.errorResponse(w, http.StatusBadRequest,
+				fmt.Sprintf("batch_size must be between 1 and %d", maxBulkBatchSize))
+			return
+		}
+		batchSize = n
+	}
+
+	settings, err := a.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to read table settings: %v", err))
+		return
+	}
+
+	// Documents are embedded synchronously by the table's embedder when requested
+	var embedder Embedder
+	if a.shouldEmbedSync(r) {
+		embedder, err = a.embedders.Get(settings.Embedder)
+		if err != nil {
+			a.errorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+
+	resp := BulkResponse{Results: []BulkItemResult{}}
+	var batch []bulkItem
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		doc, failure := parseBulkLine(line, text, settings.Chunking)
+		if failure != nil {
+			resp.add(*failure)
+			continue
+		}
+
+		batch = append(batch, bulkItem{line: line, doc: doc})
+		if len(batch) == batchSize {
+			a.storeBulkBatch(r.Context(), dbName, tableName, batch, embedder, &resp)
+			batch = nil
+		}
+	}
+
+	if len(batch) > 0 {
+		a.storeBulkBatch(r.Context(), dbName, tableName, batch, embedder, &resp)
+	}
+
+	// Earlier batches are already stored, so report the unreadable line
+	// instead of failing the request
+	if err := scanner.Err(); err != nil {
+		resp.add(BulkItemResult{
+			Line:   line + 1,
+			Status: http.StatusBadRequest,
+			Error:  fmt.Sprintf("failed to read line: %v", err),
+		})
+	}
+
+	sort.SliceStable(resp.Results, func(i, j int) bool {
+		return resp.Results[i].Line < resp.Results[j].Line
+	})
+
+	a.jsonResponse(w, http.StatusOK, resp)
+}
+
+// parseBulkLine decodes one bulk line, returning a failed result if it is invalid
+// Documents are chunked with the line's options, or chunking if it has none.
+func parseBulkLine(line int, text []byte, chunking *ChunkingOptions) (*Document, *BulkItemResult) {
+	var req StoreDocumentRequest
+	if err := json.Unmarshal(text, &req); err != nil {
+		return nil, &BulkItemResult{
+			Line:   line,
+			Status: http.StatusBadRequest,
+			Error:  fmt.Sprintf("invalid JSON: %v", err),
+		}
+	}
+
+	if req.Content == "" {
+		return nil, &BulkItemResult{
+			Line:   line,
+			ID:     req.ID,
+			Status: http.StatusBadRequest,
+			Error:  "content is required",
+		}
+	}
+
+	if req.Chunking != nil {
+		if err := req.Chunking.Validate(); err != nil {
+			return nil, &BulkItemResult{
+				Line:   line,
+				ID:     req.ID,
+				Status: http.StatusBadRequest,
+				Error:  err.Error(),
+			}
+		}
+		chunking = req.Chunking
+	}
+
+	doc := &Document{
+		ID:       req.ID,
+		Content:  req.Content,
+		Metadata: req.Metadata,
+		Tags:     req.Tags,
+	}
+	applyChunking(doc, chunking)
+	return doc, nil
+}
+
+// storeBulkBatch embeds (with embedder, if set) and stores one batch, recording a result per line
+func (a *API) storeBulkBatch(ctx context.Context, dbName, tableName string, batch []bulkItem, embedder Embedder, resp *BulkResponse) {
+	if embedder != nil {
+		docs := make([]*Document, len(batch))
+		for i, item := range batch {
+			docs[i] = item.doc
+		}
+
+		// Only the documents that could not be embedded fail
+		embedErrs := embedBulkDocuments(ctx, embedder, docs, dbName, tableName)
+		embedded := batch[:0:0]
+		for i, item := range batch {
+			if embedErrs[i] == nil {
+				embedded = append(embedded, item)
+				continue
+			}
+			resp.add(BulkItemResult{
+				Line:   item.line,
+				ID:     item.doc.ID,
+				Status: http.StatusInternalServerError,
+				Error:  fmt.Sprintf("embedding failed: %v", embedErrs[i]),
+			})
+		}
+		if batch = embedded; len(batch) == 0 {
+			return
+		}
+	}
+
+	docs := make([]*Document, len(batch))
+	for i, item := range batch {
+		docs[i] = item.doc
+	}
+
+	errs, batchErr := a.store.StoreDocuments(dbName, tableName, docs)
+	for i, item := range batch {
+		result := BulkItemResult{
+			Line:       item.line,
+			ID:         item.doc.ID,
+			Status:     http.StatusCreated,
+			IsEmbedded: item.doc.IsEmbedded,
+		}
+
+		err := batchErr
+		if err == nil {
+			err = errs[i]
+		}
+		if err != nil {
+			result.Status = http.StatusInternalServerError
+			if errors.Is(err, ErrDimensionMismatch) || errors.Is(err, ErrChunkID) {
+				result.Status = http.StatusBadRequest
+			}
+			result.IsEmbedded = false
+			result.Error = fmt.Sprintf("failed to store document: %v", err)
+		}
+
+		resp.add(result)
+	}
+}
+
+// embedDocuments computes vectors for a batch of documents with one EmbedBatch call
+// Chunked documents get a vector for each of their chunks instead.
+func (a *API) embedDocuments(ctx context.Context, embedder Embedder, docs []*Document) error {
+	ctx, cancel := context.WithTimeout(ctx, bulkEmbedTimeout)
+	defer cancel()
+
+	docs = embeddingTargets(docs)
+
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Content
+	}
+
+	vectors, err := embedder.EmbedBatch(ctx, texts)
+	if err != nil {
+		return err
+	}
+	if len(vectors) != len(docs) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(docs), len(vectors))
+	}
+
+	for i, doc := range docs {
+		doc.Vector = vectors[i]
+		doc.IsEmbedded = true
+	}
+
+	return nil
+}
+
+// embedBulkDocuments embeds a batch of documents like embedDocuments, but
+// when the batch call fails each text is retried on its own, so one rejected
+// document does not fail the others. It returns the error of each document,
+// nil for those embedded; a chunked document fails if any of its chunks does.
+func embedBulkDocuments(ctx context.Context, embedder Embedder, docs []*Document, dbName, tableName string) []error {
+	ctx, cancel := context.WithTimeout(ctx, bulkEmbedTimeout)
+	defer cancel()
+
+	var targets []*Document
+	var owners []int // Index in docs of each target
+	for i, doc := range docs {
+		for _, target := range embeddingTargets([]*Document{doc}) {
+			targets = append(targets, target)
+			owners = append(owners, i)
+		}
+	}
+
+	vectors, targetErrs := embedDocumentBatch(ctx, embedder, targets, dbName, tableName)
+
+	errs := make([]error, len(docs))
+	for i, target := range targets {
+		if targetErrs[i] != nil {
+			if errs[owners[i]] == nil {
+				errs[owners[i]] = targetErrs[i]
+			}
+			continue
+		}
+		target.Vector = vectors[i]
+		target.IsEmbedded = true
+	}
+	return errs
+}
+
+func (r *BulkResponse) add(result BulkItemResult) {
+	r.Total++
+	if result.Error == "" {
+		r.Succeeded++
+	} else {
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// StoreDocuments stores a batch of documents in a single transaction
+// errs holds the outcome of each document, so one failing document does not
+// affect the others; err is set when the batch as a whole could not be written
+func (s *DocumentStore) StoreDocuments(dbId, tableName string, docs []*Document) (errs []error, err error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := s.ensureTable(db, tableName); err != nil {
+		return nil, err
+	}
+
+	// Fetch the vector index before writing so it stays in sync with the table
+	idx := s.mutableVectorIndex(dbId, tableName)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Each document is written under its own savepoint, so a failed document
+	// leaves no partial rows and the transaction stays usable for the others
+	errs = make([]error, len(docs))
+	for i, doc := range docs {
+		errs[i] = upsertDocument(tx, dbId, tableName, doc)
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	queued := false
+	for i, doc := range docs {
+		if errs[i] != nil {
+			continue
+		}
+		if idx != nil {
+			syncVectorIndex(idx, doc)
+		}
+		queued = queued || !doc.IsEmbedded
+	}
+	if queued {
+		s.notifyEmbeddingJobs(dbId)
+	}
+
+	return errs, nil
+}
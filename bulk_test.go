@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// batchEmbedder records EmbedBatch calls and fails for texts containing "fail"
+type batchEmbedder struct {
+	batches [][]string
+}
+
+func (e *batchEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (e *batchEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	e.batches = append(e.batches, texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if strings.Contains(text, "fail") {
+			return nil, errors.New("embedding service unavailable")
+		}
+		vectors[i] = []float32{float32(len(text)), 1, 0}
+	}
+	return vectors, nil
+}
+
+func (e *batchEmbedder) Dimensions() int {
+	return 3
+}
+
+func bulkRequest(t *testing.T, api *API, query, body string, header http.Header) (*httptest.ResponseRecorder, BulkResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/db/test_db/articles/_bulk"+query, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req = mux.SetURLVars(req, map[string]string{"dbName": "test_db", "tableName": "articles"})
+	rec := httptest.NewRecorder()
+
+	api.BulkStoreDocuments(rec, req)
+
+	var resp BulkResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid bulk response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestBulkStoreDocuments(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	t.Run("Bad lines do not abort the upload", func(t *testing.T) {
+		api := NewAPI(store, &batchEmbedder{}, &Config{})
+		body := strings.Join([]string{
+			`{"id": "a1", "content": "First article", "tags": ["news"]}`,
+			`{"id": "a2", "content": `,
+			``,
+			`{"id": "a3"}`,
+			`{"id": "a4", "content": "Fourth article", "metadata": {"year": 2024}}`,
+			`{"content": "Generated id"}`,
+		}, "\n")
+
+		rec, resp := bulkRequest(t, api, "?batch_size=2", body, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+
+		if resp.Total != 5 || resp.Succeeded != 3 || resp.Failed != 2 {
+			t.Errorf("got total=%d succeeded=%d failed=%d, want 5/3/2", resp.Total, resp.Succeeded, resp.Failed)
+		}
+
+		wantStatus := map[int]int{1: 201, 2: 400, 4: 400, 5: 201, 6: 201}
+		for _, result := range resp.Results {
+			if result.Status != wantStatus[result.Line] {
+				t.Errorf("line %d: status = %d, want %d (%s)", result.Line, result.Status, wantStatus[result.Line], result.Error)
+			}
+		}
+		if last := resp.Results[len(resp.Results)-1]; last.ID == "" {
+			t.Error("expected a generated id for line 6")
+		}
+
+		doc, err := store.GetDocument("test_db", "articles", "a4")
+		if err != nil {
+			t.Fatalf("a4 was not stored: %v", err)
+		}
+		if doc.Metadata["year"] != float64(2024) {
+			t.Errorf("a4 metadata = %v", doc.Metadata)
+		}
+		if doc.IsEmbedded {
+			t.Error("a4 should not be embedded when embedding is disabled")
+		}
+	})
+
+	t.Run("Embeds each batch with one call", func(t *testing.T) {
+		embedder := &batchEmbedder{}
+		api := NewAPI(store, embedder, &Config{Features: map[string]bool{"embedding": true}})
+		body := strings.Join([]string{
+			`{"id": "e1", "content": "one"}`,
+			`{"id": "e2", "content": "two"}`,
+			`{"id": "e3", "content": "three"}`,
+			`{"id": "e4", "content": "please fail"}`,
+		}, "\n")
+
+		_, resp := bulkRequest(t, api, "?batch_size=3", body, nil)
+
+		if len(embedder.batches) != 2 {
+			t.Fatalf("EmbedBatch called %d times, want 2", len(embedder.batches))
+		}
+		if resp.Succeeded != 3 || resp.Failed != 1 {
+			t.Errorf("got succeeded=%d failed=%d, want 3/1", resp.Succeeded, resp.Failed)
+		}
+		if !resp.Results[0].IsEmbedded {
+			t.Error("line 1 should be embedded")
+		}
+
+		results, err := store.SearchVector("test_db", "articles", []float32{3, 1, 0}, 1, nil, VectorSearchOptions{})
+		if err != nil {
+			t.Fatalf("vector search failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Document.ID != "e1" && results[0].Document.ID != "e2" {
+			t.Errorf("unexpected vector search results: %v", results)
+		}
+
+		if _, err := store.GetDocument("test_db", "articles", "e4"); err == nil {
+			t.Error("e4 should not be stored after its embedding failed")
+		}
+	})
+
+	t.Run("A failing document does not fail its batch", func(t *testing.T) {
+		embedder := &batchEmbedder{}
+		api := NewAPI(store, embedder, &Config{Features: map[string]bool{"embedding": true}})
+		body := strings.Join([]string{
+			`{"id": "f1", "content": "first"}`,
+			`{"id": "f2", "content": "this one fails"}`,
+			`{"id": "f3", "content": "# One\nsection\n\n# Two\nanother", "chunking": {"strategy": "heading"}}`,
+		}, "\n")
+
+		_, resp := bulkRequest(t, api, "", body, nil)
+
+		if resp.Succeeded != 2 || resp.Failed != 1 {
+			t.Fatalf("got succeeded=%d failed=%d, want 2/1: %+v", resp.Succeeded, resp.Failed, resp.Results)
+		}
+		for _, result := range resp.Results {
+			if failed := result.ID == "f2"; failed != (result.Status == http.StatusInternalServerError) || !failed && !result.IsEmbedded {
+				t.Errorf("result = %+v", result)
+			}
+		}
+		if _, err := store.GetDocument("test_db", "articles", "f2"); err == nil {
+			t.Error("f2 should not be stored after its embedding failed")
+		}
+		if doc, err := store.GetDocument("test_db", "articles", "f3#1"); err != nil || !doc.IsEmbedded {
+			t.Errorf("chunk of f3 = %+v, %v", doc, err)
+		}
+	})
+
+	t.Run("Async clients skip embedding", func(t *testing.T) {
+		embedder := &batchEmbedder{}
+		api := NewAPI(store, embedder, &Config{Features: map[string]bool{"embedding": true}})
+		header := http.Header{ClientFeatures: []string{"embed=async"}}
+
+		_, resp := bulkRequest(t, api, "", `{"id": "s1", "content": "later"}`, header)
+
+		if len(embedder.batches) != 0 || resp.Succeeded != 1 {
+			t.Errorf("got %d embed calls and %d stored, want 0 and 1", len(embedder.batches), resp.Succeeded)
+		}
+	})
+
+	t.Run("Invalid batch size", func(t *testing.T) {
+		api := NewAPI(store, &batchEmbedder{}, &Config{})
+		rec, _ := bulkRequest(t, api, "?batch_size=0", `{"content": "x"}`, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
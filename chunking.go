@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Chunking strategies
+const (
+	ChunkByNone      = "none"      // Store the document as a single row
+	ChunkByHeading   = "heading"   // One chunk per markdown section
+	ChunkByParagraph = "paragraph" // Consecutive paragraphs packed up to max_tokens
+	ChunkByTokens    = "tokens"    // Fixed windows of max_tokens with overlap
+)
+
+const (
+	defaultChunkMaxTokens = 256
+	collapseOversample    = 4   // Results fetched per requested result when collapsing chunks
+	chunkIDSeparator      = "#" // Joins a document's ID and the index of its chunk
+)
+
+// ErrChunkID is returned for document IDs that contain chunkIDSeparator, and
+// for writes and deletes addressing a chunk, which only change through
+// their document
+var ErrChunkID = errors.New("chunk IDs are reserved")
+
+// ChunkingOptions controls how documents are split before embedding
+// Tokens are approximated by whitespace-separated words.
+type ChunkingOptions struct {
+	Strategy  string `json:"strategy"`             // "heading", "paragraph", "tokens" or "none"
+	MaxTokens int    `json:"max_tokens,omitempty"` // Largest chunk (default 256)
+	Overlap   int    `json:"overlap,omitempty"`    // Tokens repeated at the start of the next window
+}
+
+// Validate checks the options and fills in defaults
+func (o *ChunkingOptions) Validate() error {
+	switch o.Strategy {
+	case ChunkByNone, ChunkByHeading, ChunkByParagraph, ChunkByTokens:
+	default:
+		return fmt.Errorf("invalid chunking strategy %q, must be heading, paragraph, tokens or none", o.Strategy)
+	}
+
+	if o.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if o.MaxTokens == 0 {
+		o.MaxTokens = defaultChunkMaxTokens
+	}
+	if o.Overlap < 0 || o.Overlap >= o.MaxTokens {
+		return fmt.Errorf("overlap must be between 0 and max_tokens-1")
+	}
+	return nil
+}
+
+// enabled reports whether documents are split at all
+func (o *ChunkingOptions) enabled() bool {
+	return o != nil && o.Strategy != ChunkByNone
+}
+
+var (
+	wordPattern      = regexp.MustCompile(`\S+`)
+	paragraphPattern = regexp.MustCompile(`\n[ \t]*\n`)
+	headingPattern   = regexp.MustCompile(`^#{1,6}\s`)
+)
+
+// chunkText splits text according to opts, dropping empty chunks
+func chunkText(text string, opts ChunkingOptions) []string {
+	var chunks []string
+	switch opts.Strategy {
+	case ChunkByHeading:
+		for _, section := range splitSections(text) {
+			if countTokens(section) <= opts.MaxTokens {
+				chunks = append(chunks, section)
+			} else {
+				chunks = append(chunks, packParagraphs(section, opts)...)
+			}
+		}
+	case ChunkByParagraph:
+		chunks = packParagraphs(text, opts)
+	case ChunkByTokens:
+		chunks = tokenWindows(text, opts.MaxTokens, opts.Overlap)
+	default:
+		chunks = []string{text}
+	}
+
+	result := chunks[:0]
+	for _, chunk := range chunks {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			result = append(result, chunk)
+		}
+	}
+	return result
+}
+
+// countTokens approximates the token count of text
+func countTokens(text string) int {
+	return len(wordPattern.FindAllStringIndex(text, -1))
+}
+
+// splitSections splits markdown at headings, ignoring lines in code fences
+// Text before the first heading forms its own section.
+func splitSections(text string) []string {
+	var sections []string
+	var current strings.Builder
+	inFence := false
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if !inFence && headingPattern.MatchString(line) && current.Len() > 0 {
+			sections = append(sections, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		sections = append(sections, current.String())
+	}
+	return sections
+}
+
+// packParagraphs joins consecutive paragraphs while they fit in max_tokens,
+// splitting paragraphs that are too long on their own into token windows
+func packParagraphs(text string, opts ChunkingOptions) []string {
+	var chunks []string
+	var current []string
+	tokens := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, strings.Join(current, "\n\n"))
+			current = nil
+			tokens = 0
+		}
+	}
+
+	for _, paragraph := range paragraphPattern.Split(text, -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		n := countTokens(paragraph)
+		if n == 0 {
+			continue
+		}
+		if n > opts.MaxTokens {
+			flush()
+			chunks = append(chunks, tokenWindows(paragraph, opts.MaxTokens, opts.Overlap)...)
+			continue
+		}
+		if tokens+n > opts.MaxTokens {
+			flush()
+		}
+		current = append(current, paragraph)
+		tokens += n
+	}
+	flush()
+
+	return chunks
+}
+
+// tokenWindows cuts text into windows of maxTokens words, each starting
+// overlap words before the end of the previous one. Windows are slices of
+// the original text, so line breaks and markdown survive.
+func tokenWindows(text string, maxTokens, overlap int) []string {
+	words := wordPattern.FindAllStringIndex(text, -1)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var windows []string
+	for start := 0; ; start += maxTokens - overlap {
+		end := min(start+maxTokens, len(words))
+		windows = append(windows, text[words[start][0]:words[end-1][1]])
+		if end == len(words) {
+			break
+		}
+	}
+	return windows
+}
+
+// chunkID is the row ID of a document's chunk
+// Document IDs cannot contain the separator, so it never names a document.
+func chunkID(parentID string, index int) string {
+	return parentID + chunkIDSeparator + strconv.Itoa(index)
+}
+
+// checkDocumentID rejects IDs that could collide with chunk IDs
+func checkDocumentID(id string) error {
+	if strings.Contains(id, chunkIDSeparator) {
+		return fmt.Errorf("%w: document ID %q cannot contain %q", ErrChunkID, id, chunkIDSeparator)
+	}
+	return nil
+}
+
+// applyChunking splits doc into chunks when opts call for it
+// Documents that fit in a single chunk are stored as they are.
+func applyChunking(doc *Document, opts *ChunkingOptions) {
+	doc.Chunks = nil
+	if !opts.enabled() {
+		return
+	}
+
+	texts := chunkText(doc.Content, *opts)
+	if len(texts) < 2 {
+		return
+	}
+
+	doc.Chunks = make([]*Document, len(texts))
+	for i, text := range texts {
+		doc.Chunks[i] = &Document{
+			Content:  text,
+			Metadata: doc.Metadata,
+			Tags:     doc.Tags,
+			Chunk:    &ChunkRef{Index: i},
+		}
+	}
+}
+
+// embeddingTargets returns the rows that carry the vectors of docs: the
+// chunks of chunked documents and the other documents themselves
+func embeddingTargets(docs []*Document) []*Document {
+	var targets []*Document
+	for _, doc := range docs {
+		if len(doc.Chunks) > 0 {
+			targets = append(targets, doc.Chunks...)
+		} else {
+			targets = append(targets, doc)
+		}
+	}
+	return targets
+}
+
+// setChunkInfo fills in the chunk columns read from a document row
+func (doc *Document) setChunkInfo(parentID sql.NullString, index, count int) {
+	if parentID.Valid {
+		doc.Chunk = &ChunkRef{ParentID: parentID.String, Index: index}
+	}
+	doc.ChunkCount = count
+}
+
+// CollapseChunks replaces chunk results with their parent documents, keeping
+// each parent once at the rank of its best chunk, which is reported in
+// BestChunk. Results must be ordered best first.
+func (s *DocumentStore) CollapseChunks(dbId, tableName string, results []SearchResult, limit int) ([]SearchResult, error) {
+	seen := make(map[string]bool)
+	var collapsed []SearchResult
+
+	for _, result := range results {
+		if len(collapsed) == limit {
+			break
+		}
+
+		chunk := result.Document.Chunk
+		if chunk == nil {
+			if !seen[result.Document.ID] {
+				seen[result.Document.ID] = true
+				collapsed = append(collapsed, result)
+			}
+			continue
+		}
+		if seen[chunk.ParentID] {
+			continue
+		}
+		seen[chunk.ParentID] = true
+
+		parent, err := s.GetDocument(dbId, tableName, chunk.ParentID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				continue // Orphaned chunk
+			}
+			return nil, err
+		}
+
+		collapsed = append(collapsed, SearchResult{
+			Document: *parent,
+			Score:    result.Score,
+			BestChunk: &ChunkMatch{
+				ID:      result.Document.ID,
+				Index:   chunk.Index,
+				Content: result.Document.Content,
+				Score:   result.Score,
+			},
+		})
+	}
+
+	return collapsed, nil
+}
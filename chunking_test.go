@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestChunkText(t *testing.T) {
+	markdown := "Intro line\n\n# Setup\nInstall it.\n\n```sh\n# not a heading\nmake\n```\n\n## Usage\nRun it.\n"
+
+	tests := []struct {
+		name string
+		text string
+		opts ChunkingOptions
+		want []string
+	}{
+		{
+			name: "Headings outside code fences",
+			text: markdown,
+			opts: ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: 100},
+			want: []string{"Intro line", "# Setup\nInstall it.\n\n```sh\n# not a heading\nmake\n```", "## Usage\nRun it."},
+		},
+		{
+			name: "Long sections fall back to paragraphs",
+			text: "# Title\none two three\n\nfour five\n\nsix",
+			opts: ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: 5},
+			want: []string{"# Title\none two three", "four five\n\nsix"},
+		},
+		{
+			name: "Paragraphs are packed",
+			text: "a b\n\nc d\n  \ne f g h",
+			opts: ChunkingOptions{Strategy: ChunkByParagraph, MaxTokens: 4},
+			want: []string{"a b\n\nc d", "e f g h"},
+		},
+		{
+			name: "Token windows overlap",
+			text: "one two three four\nfive six seven",
+			opts: ChunkingOptions{Strategy: ChunkByTokens, MaxTokens: 3, Overlap: 1},
+			want: []string{"one two three", "three four\nfive", "five six seven"},
+		},
+		{
+			name: "Oversized paragraph uses token windows",
+			text: "short\n\nw1 w2 w3 w4 w5",
+			opts: ChunkingOptions{Strategy: ChunkByParagraph, MaxTokens: 3},
+			want: []string{"short", "w1 w2 w3", "w4 w5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunkText(tt.text, tt.opts)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("chunkText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkingOptionsValidate(t *testing.T) {
+	valid := ChunkingOptions{Strategy: ChunkByTokens}
+	if err := valid.Validate(); err != nil || valid.MaxTokens != defaultChunkMaxTokens {
+		t.Errorf("Validate() = %v, max_tokens = %d", err, valid.MaxTokens)
+	}
+
+	for _, opts := range []ChunkingOptions{
+		{Strategy: "sentences"},
+		{Strategy: ChunkByTokens, MaxTokens: -1},
+		{Strategy: ChunkByTokens, MaxTokens: 10, Overlap: 10},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want an error", opts)
+		}
+	}
+}
+
+func TestChunkedDocuments(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "manuals"
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{"embedding": true}})
+
+	storeDocument := func(t *testing.T, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/manuals", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.StoreDocument(rec, req)
+		return rec
+	}
+
+	t.Run("Table settings", func(t *testing.T) {
+		body := `{"chunking": {"strategy": "heading", "max_tokens": 50}}`
+		req := httptest.NewRequest(http.MethodPut, "/db/test_db/manuals/_settings", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.UpdateTableSettings(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		req = httptest.NewRequest(http.MethodPut, "/db/test_db/manuals/_settings", strings.NewReader(`{"chunking": {"strategy": "words"}}`))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec = httptest.NewRecorder()
+		api.UpdateTableSettings(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid strategy: status = %d, want 400", rec.Code)
+		}
+
+		settings, err := store.GetTableSettings(dbName, tableName)
+		if err != nil || settings.Chunking == nil || settings.Chunking.Strategy != ChunkByHeading {
+			t.Errorf("GetTableSettings = %+v, %v", settings, err)
+		}
+	})
+
+	t.Run("Documents are stored as chunks", func(t *testing.T) {
+		rec := storeDocument(t, `{"id": "guide", "content": "# Install\nRun the installer.\n\n# Upgrade\nBack up the database first.\n\n# Remove\nDelete the folder.", "tags": ["docs"]}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		parent, err := store.GetDocument(dbName, tableName, "guide")
+		if err != nil {
+			t.Fatalf("GetDocument failed: %v", err)
+		}
+		if parent.ChunkCount != 3 || !parent.IsEmbedded || len(parent.Vector) != 0 {
+			t.Errorf("parent = %+v, want 3 embedded chunks and no vector of its own", parent)
+		}
+
+		chunk, err := store.GetDocument(dbName, tableName, chunkID("guide", 1))
+		if err != nil {
+			t.Fatalf("GetDocument failed: %v", err)
+		}
+		if chunk.Chunk == nil || chunk.Chunk.ParentID != "guide" || chunk.Chunk.Index != 1 {
+			t.Errorf("chunk ref = %+v", chunk.Chunk)
+		}
+		if !strings.HasPrefix(chunk.Content, "# Upgrade") || !chunk.IsEmbedded || chunk.Tags[0] != "docs" {
+			t.Errorf("chunk = %+v", chunk)
+		}
+
+		docs, _ := store.ListDocuments(dbName, tableName, 10, 0)
+		if len(docs) != 1 {
+			t.Errorf("ListDocuments returned %d documents, chunks should be hidden", len(docs))
+		}
+	})
+
+	t.Run("Per-request options override the table", func(t *testing.T) {
+		rec := storeDocument(t, `{"id": "note", "content": "# A\none\n\n# B\ntwo", "chunking": {"strategy": "none"}}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if doc, _ := store.GetDocument(dbName, tableName, "note"); doc == nil || doc.ChunkCount != 0 {
+			t.Errorf("note should not be chunked: %+v", doc)
+		}
+
+		rec = storeDocument(t, `{"content": "text", "chunking": {"strategy": "tokens", "overlap": 300}}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid options: status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("Search collapses chunks to the parent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/manuals/search",
+			strings.NewReader(`{"query": "database OR installer OR folder", "type": "fulltext", "collapse": true}`))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.SearchDocuments(rec, req)
+
+		var resp SearchResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if len(resp.Results) != 1 {
+			t.Fatalf("got %d results, want the parent once: %+v", len(resp.Results), resp.Results)
+		}
+		result := resp.Results[0]
+		if result.Document.ID != "guide" || result.BestChunk == nil || result.BestChunk.ID != chunkID("guide", result.BestChunk.Index) {
+			t.Errorf("result = %+v", result)
+		}
+
+		// Without collapsing every matching chunk is returned
+		results, err := store.SearchFullText(dbName, tableName, "database OR installer OR folder", 10, nil)
+		if err != nil || len(results) != 3 {
+			t.Errorf("got %d chunk results (%v), want 3", len(results), err)
+		}
+	})
+
+	t.Run("Rewrites and deletes keep chunks in sync", func(t *testing.T) {
+		rec := storeDocument(t, `{"id": "guide", "content": "# Install\nRun the installer.\n\n# Remove\nDelete the folder."}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, err := store.GetDocument(dbName, tableName, chunkID("guide", 2)); err == nil {
+			t.Error("stale chunk 2 should be removed")
+		}
+
+		// Chunks only change through their document
+		if rec := storeDocument(t, `{"id": "guide#1", "content": "overwritten"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("writing a chunk: status = %d, want 400", rec.Code)
+		}
+		if err := store.DeleteDocument(dbName, tableName, chunkID("guide", 1)); !errors.Is(err, ErrChunkID) {
+			t.Errorf("deleting a chunk = %v, want ErrChunkID", err)
+		}
+		if parent, err := store.GetDocument(dbName, tableName, "guide"); err != nil || parent.ChunkCount != 2 {
+			t.Errorf("parent = %+v, %v", parent, err)
+		}
+
+		if err := store.DeleteDocument(dbName, tableName, "guide"); err != nil {
+			t.Fatalf("DeleteDocument failed: %v", err)
+		}
+		if _, err := store.GetDocument(dbName, tableName, chunkID("guide", 0)); err == nil {
+			t.Error("chunks should be deleted with their document")
+		}
+	})
+
+	t.Run("Background worker embeds chunks", func(t *testing.T) {
+		doc := &Document{ID: "async", Content: "# One\nfirst\n\n# Two\nsecond"}
+		applyChunking(doc, &ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: 10})
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		jobs := jobsByID(t, store, dbName)
+		if _, ok := jobs["async"]; ok || len(jobs) != 2 {
+			t.Fatalf("jobs = %v, want one per chunk", jobs)
+		}
+
+		pool := NewEmbeddingWorkerPool(store, &batchEmbedder{}, 1, 10)
+		for pool.ProcessBatch(context.Background()) {
+		}
+
+		parent, err := store.GetDocument(dbName, tableName, "async")
+		if err != nil || !parent.IsEmbedded {
+			t.Errorf("parent should be embedded once its chunks are: %+v %v", parent, err)
+		}
+	})
+
+	t.Run("A failed chunk leaves no partial document in a batch", func(t *testing.T) {
+		db, release, err := store.getDB(dbName)
+		if err != nil {
+			t.Fatalf("getDB failed: %v", err)
+		}
+		defer release()
+		if _, err := db.Exec(`CREATE TRIGGER reject_boom BEFORE INSERT ON manuals WHEN new.parent_id IS NOT NULL AND new.content LIKE '%boom%' BEGIN SELECT RAISE(ABORT, 'boom'); END`); err != nil {
+			t.Fatalf("Failed to create trigger: %v", err)
+		}
+		defer db.Exec(`DROP TRIGGER reject_boom`)
+
+		broken := &Document{ID: "broken", Content: "# One\nfine\n\n# Two\nboom"}
+		applyChunking(broken, &ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: 10})
+		docs := []*Document{broken, {ID: "intact", Content: "fine"}}
+
+		errs, err := store.StoreDocuments(dbName, tableName, docs)
+		if err != nil || errs[0] == nil || errs[1] != nil {
+			t.Fatalf("StoreDocuments = %v, %v", errs, err)
+		}
+		for _, id := range []string{"broken", chunkID("broken", 0)} {
+			if _, err := store.GetDocument(dbName, tableName, id); err == nil {
+				t.Errorf("%s written by the failed document", id)
+			}
+		}
+		if _, err := store.GetDocument(dbName, tableName, "intact"); err != nil {
+			t.Errorf("other document of the batch lost: %v", err)
+		}
+	})
+
+	t.Run("A failed chunk delete keeps the document", func(t *testing.T) {
+		doc := &Document{ID: "kept", Content: "# One\nfirst\n\n# Two\nsecond"}
+		applyChunking(doc, &ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: 10})
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		db, release, err := store.getDB(dbName)
+		if err != nil {
+			t.Fatalf("getDB failed: %v", err)
+		}
+		defer release()
+		if _, err := db.Exec(`CREATE TRIGGER keep_chunks BEFORE DELETE ON manuals WHEN old.parent_id = 'kept' BEGIN SELECT RAISE(ABORT, 'kept'); END`); err != nil {
+			t.Fatalf("Failed to create trigger: %v", err)
+		}
+		defer db.Exec(`DROP TRIGGER keep_chunks`)
+
+		if err := store.DeleteDocument(dbName, tableName, "kept"); err == nil {
+			t.Fatal("DeleteDocument should fail when its chunks cannot be deleted")
+		}
+		if parent, err := store.GetDocument(dbName, tableName, "kept"); err != nil || parent.ChunkCount != 2 {
+			t.Errorf("parent = %+v, %v", parent, err)
+		}
+	})
+}
+
+func TestChunkColumnsMigration(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	// A table created before chunking existed
+	db, err := sql.Open("sqlite", filepath.Join(tmpDir, "legacy.db"))
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE notes (
+		id TEXT PRIMARY KEY, content TEXT NOT NULL, metadata TEXT, tags TEXT, vector BLOB,
+		created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL, is_embedded INTEGER DEFAULT 0)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE VIRTUAL TABLE notes_fts USING fts5(id UNINDEXED, content, content='notes', content_rowid='rowid')`)
+	}
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO notes VALUES ('old', 'old note', '{"title": "Legacy"}', '', NULL, datetime(), datetime(), 0)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+
+	doc, err := store.GetDocument("legacy", "notes", "old")
+	if err != nil || doc.Chunk != nil || doc.ChunkCount != 0 {
+		t.Fatalf("GetDocument on a legacy table = %+v, %v", doc, err)
+	}
+
+	// The FTS table is rebuilt with the title and tags columns
+	results, err := store.SearchFullText("legacy", "notes", "title:legacy", 10, nil)
+	if err != nil || len(results) != 1 {
+		t.Errorf("title search on a legacy table = %v, %v", results, err)
+	}
+
+	doc = &Document{ID: "new", Content: "one two three"}
+	applyChunking(doc, &ChunkingOptions{Strategy: ChunkByTokens, MaxTokens: 2})
+	if err := store.StoreDocument("legacy", "notes", doc); err != nil {
+		t.Fatalf("StoreDocument on a legacy table failed: %v", err)
+	}
+	if doc.ChunkCount != 2 {
+		t.Errorf("chunk count = %d, want 2", doc.ChunkCount)
+	}
+}
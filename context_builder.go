@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultContextTokens     = 2048
+	defaultContextCandidates = 20 // Search results considered when no limit is given
+	minContextSourceTokens   = 16 // Smallest trimmed source worth including
+
+	contextSeparator = "\n\n" // Between sources
+	contextEllipsis  = "…"    // Ends a trimmed source
+)
+
+// TokenCounter measures text in the tokens of the model the context is for
+type TokenCounter interface {
+	// Count returns the number of tokens in text
+	Count(text string) int
+
+	// Truncate returns the longest prefix of text with at most maxTokens tokens
+	Truncate(text string, maxTokens int) string
+}
+
+// wordTokenCounter estimates tokens without a tokenizer, erring on the high
+// side. English text averages about 4/3 tokens per word, while code, numbers
+// and scripts without spaces average about one token per 4 characters, so
+// the larger of both estimates is used. Both are rounded up, so the counts
+// of pieces of a text add up to at least the count of the whole.
+type wordTokenCounter struct{}
+
+func (wordTokenCounter) Count(text string) int {
+	words := countTokens(text)
+	runes := utf8.RuneCountInString(text)
+	return max((words*4+2)/3, (runes+3)/4)
+}
+
+func (c wordTokenCounter) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if c.Count(text) <= maxTokens {
+		return text
+	}
+
+	// Whole words first, then cut a long tail down to the character budget
+	maxWords := maxTokens * 3 / 4
+	if maxWords == 0 {
+		return ""
+	}
+	if words := wordPattern.FindAllStringIndex(text, maxWords+1); len(words) > maxWords {
+		text = text[:words[maxWords-1][1]]
+	}
+	if maxRunes := maxTokens * 4; utf8.RuneCountInString(text) > maxRunes {
+		text = string([]rune(text)[:maxRunes])
+	}
+	return text
+}
+
+// contextSource is one document of an assembled context with the retrieved
+// passages of it
+type contextSource struct {
+	doc      Document // The retrieved row, for chunks the first one found
+	id       string   // Document ID, the parent's for chunks
+	score    float64
+	passages []SearchResult
+}
+
+// groupContextSources dedupes results and groups chunks under their document.
+// Documents keep the order of their best result; passages of a document are
+// ordered as they appear in it. Results must be ordered best first.
+func groupContextSources(results []SearchResult) []*contextSource {
+	var sources []*contextSource
+	byID := make(map[string]*contextSource)
+	seenRows := make(map[string]bool)
+	seenContent := make(map[string]bool)
+
+	for _, result := range results {
+		doc := result.Document
+		content := strings.TrimSpace(doc.Content)
+		if seenRows[doc.ID] || seenContent[content] {
+			continue
+		}
+		seenRows[doc.ID] = true
+		seenContent[content] = true
+
+		id := doc.ID
+		if doc.Chunk != nil {
+			id = doc.Chunk.ParentID
+		}
+
+		source, ok := byID[id]
+		if !ok {
+			source = &contextSource{doc: doc, id: id, score: result.Score}
+			byID[id] = source
+			sources = append(sources, source)
+		}
+		source.passages = append(source.passages, result)
+	}
+
+	for _, source := range sources {
+		sort.SliceStable(source.passages, func(i, j int) bool {
+			return chunkIndex(source.passages[i].Document) < chunkIndex(source.passages[j].Document)
+		})
+	}
+
+	return sources
+}
+
+// chunkIndex returns the position of a row within its document
+func chunkIndex(doc Document) int {
+	if doc.Chunk == nil {
+		return 0
+	}
+	return doc.Chunk.Index
+}
+
+// contextHeader renders the citation line that opens a source, e.g.
+// [1] notes/runbook title="Restarts"
+func contextHeader(n int, source *contextSource, fields []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%d] %s/%s", n, source.doc.Table, source.id)
+	for _, field := range fields {
+		value, ok := source.doc.Metadata[field]
+		if !ok || value == nil {
+			continue
+		}
+		text, ok := value.(string)
+		if !ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			text = string(encoded)
+		}
+		fmt.Fprintf(&b, " %s=%q", field, text)
+	}
+	return b.String()
+}
+
+// assembleContext renders search results as a context block of numbered
+// sources within a token budget. Sources are added best first; the first one
+// that does not fit is trimmed to the remaining budget and ends the context.
+// The separators and the ellipsis of a trimmed source count against the
+// budget too.
+func assembleContext(results []SearchResult, req *ContextRequest, counter TokenCounter) ContextResponse {
+	resp := ContextResponse{
+		Query:     req.Query,
+		MaxTokens: req.MaxTokens,
+		Citations: []Citation{},
+	}
+
+	var blocks []string
+	remaining := req.MaxTokens
+	for _, source := range groupContextSources(results) {
+		n := len(resp.Citations) + 1
+		header := contextHeader(n, source, req.MetadataFields)
+
+		passages := make([]string, len(source.passages))
+		var chunks []int
+		for i, passage := range source.passages {
+			passages[i] = strings.TrimSpace(passage.Document.Content)
+			if passage.Document.Chunk != nil {
+				chunks = append(chunks, passage.Document.Chunk.Index)
+			}
+		}
+		body := strings.Join(passages, "\n\n…\n\n")
+
+		separator := 0
+		if len(blocks) > 0 {
+			separator = counter.Count(contextSeparator)
+		}
+		header += "\n"
+
+		truncated := false
+		tokens := separator + counter.Count(header+body)
+		if tokens > remaining {
+			budget := remaining - separator - counter.Count(header) - counter.Count(contextEllipsis)
+			if budget < minContextSourceTokens {
+				resp.Truncated = true
+				break
+			}
+			body = counter.Truncate(body, budget) + contextEllipsis
+			truncated = true
+		}
+
+		blocks = append(blocks, header+body)
+		remaining -= tokens
+		resp.Citations = append(resp.Citations, Citation{
+			Index:     n,
+			ID:        source.id,
+			DB:        source.doc.DB,
+			Table:     source.doc.Table,
+			Chunks:    chunks,
+			Metadata:  source.doc.Metadata,
+			Score:     source.score,
+			Truncated: truncated,
+		})
+
+		if truncated {
+			resp.Truncated = true
+			break
+		}
+	}
+
+	resp.Context = strings.Join(blocks, contextSeparator)
+	resp.Tokens = counter.Count(resp.Context)
+	return resp
+}
+
+// BuildContext retrieves passages for a question and assembles them into a
+// context block with citations that fits a token budget
+// POST /db/{dbName}/{tableName}/_context
+func (a *API) BuildContext(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+
+	var req ContextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.MaxTokens < 0 {
+		a.errorResponse(w, http.StatusBadRequest, "max_tokens must not be negative")
+		return
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = defaultContextTokens
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultContextCandidates
+	}
+	if req.MetadataFields == nil {
+		req.MetadataFields = []string{"title"}
+	}
+
+	// Chunks are grouped by document below and not highlighted
+	req.Collapse = false
+	req.Highlight = nil
+
+	if err := validateSearchRequest(&req.SearchRequest); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := a.searchTable(r.Context(), dbName, tableName, req.SearchRequest)
+	if err != nil {
+		a.searchErrorResponse(w, err)
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, assembleContext(results, &req, a.tokens))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWordTokenCounter(t *testing.T) {
+	var counter wordTokenCounter
+
+	tests := []struct {
+		text      string
+		maxTokens int
+		want      string
+	}{
+		{"one two  three four", 3, "one two"},
+		{"one two", 5, "one two"},
+		{"  one two three", 2, "  one"},
+		{"abcdefghijkl", 2, "abcdefgh"},
+		{"one", 1, ""},
+		{"one", 0, ""},
+	}
+
+	for _, tt := range tests {
+		got := counter.Truncate(tt.text, tt.maxTokens)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.maxTokens, got, tt.want)
+		}
+		if counter.Count(got) > tt.maxTokens {
+			t.Errorf("Truncate(%q, %d) has %d tokens", tt.text, tt.maxTokens, counter.Count(got))
+		}
+	}
+
+	for text, want := range map[string]int{
+		"one two\nthree":     4, // 4/3 tokens per word
+		"数据库备份每晚运行":          3, // One token per 4 characters
+		"0x1f2e3d4c5b6a7988": 5,
+	} {
+		if got := counter.Count(text); got != want {
+			t.Errorf("Count(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestAssembleContext(t *testing.T) {
+	chunk := func(parent string, index int, content string, score float64) SearchResult {
+		return SearchResult{
+			Document: Document{ID: chunkID(parent, index), Table: "docs", Content: content,
+				Chunk: &ChunkRef{ParentID: parent, Index: index}, Metadata: map[string]interface{}{"title": "Guide"}},
+			Score: score,
+		}
+	}
+	results := []SearchResult{
+		chunk("guide", 3, "Step three", 0.9),
+		{Document: Document{ID: "copy", Table: "docs", Content: "Step three"}, Score: 0.8},
+		{Document: Document{ID: "faq", Table: "docs", Content: "Frequently asked questions", Metadata: map[string]interface{}{"page": 7.0}}, Score: 0.7},
+		chunk("guide", 1, "Step one", 0.6),
+	}
+
+	req := &ContextRequest{MaxTokens: 100, MetadataFields: []string{"title", "page"}}
+	resp := assembleContext(results, req, wordTokenCounter{})
+
+	want := "[1] docs/guide title=\"Guide\"\nStep one\n\n…\n\nStep three\n\n[2] docs/faq page=\"7\"\nFrequently asked questions"
+	if resp.Context != want {
+		t.Errorf("context = %q, want %q", resp.Context, want)
+	}
+	if len(resp.Citations) != 2 || resp.Citations[0].ID != "guide" || resp.Citations[0].Score != 0.9 {
+		t.Fatalf("citations = %+v", resp.Citations)
+	}
+	if chunks := resp.Citations[0].Chunks; len(chunks) != 2 || chunks[0] != 1 || chunks[1] != 3 {
+		t.Errorf("chunks = %v, want [1 3]", chunks)
+	}
+	if resp.Truncated || resp.Tokens != (wordTokenCounter{}).Count(resp.Context) {
+		t.Errorf("tokens = %d, truncated = %v", resp.Tokens, resp.Truncated)
+	}
+
+	// A long source is trimmed to the budget and ends the context
+	long := []SearchResult{
+		{Document: Document{ID: "long", Table: "docs", Content: strings.Repeat("word ", 100)}, Score: 1},
+		{Document: Document{ID: "next", Table: "docs", Content: "never included"}, Score: 0.5},
+	}
+	resp = assembleContext(long, &ContextRequest{MaxTokens: 30}, wordTokenCounter{})
+	if !resp.Truncated || len(resp.Citations) != 1 || !resp.Citations[0].Truncated || resp.Tokens > 30 {
+		t.Errorf("trimmed context = %+v", resp)
+	}
+	if !strings.HasSuffix(resp.Context, "word…") {
+		t.Errorf("trimmed context should end with an ellipsis: %q", resp.Context)
+	}
+}
+
+func TestBuildContext(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "docs"
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	for _, body := range []string{
+		`{"id": "manual", "metadata": {"title": "Ops manual"}, "chunking": {"strategy": "heading"},
+		  "content": "# Backups\nBackups run nightly.\n\n# Restore\nRestore from a snapshot.\n\n# Retention\nOld backups expire after thirty days."}`,
+		`{"id": "faq", "content": "Do backups cover attachments? Yes, backups include every attachment."}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/docs", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.StoreDocument(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	buildContext := func(t *testing.T, body string) (*httptest.ResponseRecorder, ContextResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/docs/_context", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.BuildContext(rec, req)
+
+		var resp ContextResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	t.Run("Chunks are grouped under their document", func(t *testing.T) {
+		rec, resp := buildContext(t, `{"query": "backups"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if len(resp.Citations) != 2 {
+			t.Fatalf("citations = %+v", resp.Citations)
+		}
+
+		var manual Citation
+		for _, citation := range resp.Citations {
+			if citation.ID == "manual" {
+				manual = citation
+			}
+		}
+		if manual.DB != dbName || manual.Table != tableName || manual.Metadata["title"] != "Ops manual" {
+			t.Errorf("manual citation = %+v", manual)
+		}
+		if len(manual.Chunks) != 2 || manual.Chunks[0] != 0 || manual.Chunks[1] != 2 {
+			t.Errorf("manual chunks = %v, want [0 2]", manual.Chunks)
+		}
+		header := "] docs/manual title=\"Ops manual\"\n# Backups\nBackups run nightly.\n\n…\n\n# Retention"
+		if !strings.Contains(resp.Context, header) {
+			t.Errorf("context = %q", resp.Context)
+		}
+		if resp.MaxTokens != defaultContextTokens || resp.Truncated {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("Context fits the token budget", func(t *testing.T) {
+		_, resp := buildContext(t, `{"query": "backups", "max_tokens": 40}`)
+		if !resp.Truncated || resp.Tokens > 40 || len(resp.Citations) != 1 {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		for _, body := range []string{
+			`{"query": "backups", "max_tokens": -1}`,
+			`{"query": ""}`,
+			`{"query": "backups", "type": "semantic"}`,
+		} {
+			if rec, _ := buildContext(t, body); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", body, rec.Code)
+			}
+		}
+	})
+}
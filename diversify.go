@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+const (
+	DiversifyMMR = "mmr"
+
+	defaultMMRLambda     = 0.5
+	defaultMMRFetchRatio = 4    // Candidates fetched per requested result
+	maxMMRFetchK         = 1000 // MMR compares every pick against every candidate
+)
+
+// normalizeDiversifyOptions fills in defaults for unset diversify options
+func normalizeDiversifyOptions(opts DiversifyOptions, limit int) DiversifyOptions {
+	if opts.Method == "" {
+		opts.Method = DiversifyMMR
+	}
+	if opts.Lambda == nil {
+		lambda := defaultMMRLambda
+		opts.Lambda = &lambda
+	}
+	if opts.FetchK < limit {
+		opts.FetchK = max(limit, min(limit*defaultMMRFetchRatio, maxMMRFetchK))
+	}
+	return opts
+}
+
+// Validate checks diversify options
+func (o *DiversifyOptions) Validate() error {
+	if o.Method != "" && o.Method != DiversifyMMR {
+		return fmt.Errorf("invalid diversify method: %s", o.Method)
+	}
+	if o.Lambda != nil && (*o.Lambda < 0 || *o.Lambda > 1) {
+		return fmt.Errorf("diversify lambda must be between 0 and 1")
+	}
+	if o.FetchK < 0 || o.FetchK > maxMMRFetchK {
+		return fmt.Errorf("diversify fetch_k must be between 1 and %d", maxMMRFetchK)
+	}
+	return nil
+}
+
+// DiversifyMMR re-ranks vector search results with maximal marginal relevance
+// and returns at most limit of them. Each pick maximizes
+// lambda*sim(query, doc) - (1-lambda)*max sim(doc, picked), so results close
+// to one already returned are pushed down. Similarities use the table's
+// metric on the stored vectors. Results must be ordered best first.
+func (s *DocumentStore) DiversifyMMR(dbId, tableName string, queryVector []float32, results []SearchResult, lambda float64, limit int) ([]SearchResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	settings, err := readTableSettings(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Document.ID
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT id, vector FROM "%s"
+		WHERE vector IS NOT NULL AND id IN (SELECT value FROM json_each(?))
+	`, tableName), string(idsJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load candidate vectors: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string][]float32, len(results))
+	for rows.Next() {
+		var id string
+		var vectorBytes []byte
+		if err := rows.Scan(&id, &vectorBytes); err != nil {
+			return nil, err
+		}
+		stored[id] = deserializeVector(vectorBytes)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Candidates without a stored vector cannot be compared and keep their
+	// relative order after the diversified ones
+	var candidates, rest []SearchResult
+	var vectors [][]float32
+	for _, result := range results {
+		if vector, ok := stored[result.Document.ID]; ok {
+			candidates = append(candidates, result)
+			vectors = append(vectors, vector)
+		} else {
+			rest = append(rest, result)
+		}
+	}
+
+	order := maximalMarginalRelevance(settings.Metric, queryVector, vectors, lambda, limit)
+	diversified := make([]SearchResult, 0, min(limit, len(results)))
+	for _, i := range order {
+		diversified = append(diversified, candidates[i])
+	}
+	for _, result := range rest {
+		if len(diversified) == limit {
+			break
+		}
+		diversified = append(diversified, result)
+	}
+
+	return diversified, nil
+}
+
+// maximalMarginalRelevance greedily picks up to k of vectors and returns
+// their indexes in pick order. Ties go to the earlier vector.
+func maximalMarginalRelevance(metric string, queryVector []float32, vectors [][]float32, lambda float64, k int) []int {
+	relevance := make([]float64, len(vectors))
+	redundancy := make([]float64, len(vectors)) // Highest similarity to any pick so far
+	for i, vector := range vectors {
+		relevance[i] = vectorScore(metric, queryVector, vector)
+		redundancy[i] = math.Inf(-1)
+	}
+
+	picked := make([]bool, len(vectors))
+	var order []int
+	for len(order) < k && len(order) < len(vectors) {
+		best, bestScore := -1, math.Inf(-1)
+		for i := range vectors {
+			if picked[i] {
+				continue
+			}
+			score := lambda * relevance[i]
+			if len(order) > 0 {
+				score -= (1 - lambda) * redundancy[i]
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		picked[best] = true
+		order = append(order, best)
+		for i := range vectors {
+			if !picked[i] {
+				redundancy[i] = max(redundancy[i], vectorScore(metric, vectors[best], vectors[i]))
+			}
+		}
+	}
+
+	return order
+}
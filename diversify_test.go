@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMaximalMarginalRelevance(t *testing.T) {
+	query := []float32{1, 0}
+	vectors := [][]float32{
+		{0.9, 0.436}, // Best match
+		{0.9, 0.436}, // Duplicate of the best match
+		{0.8, -0.6},  // Slightly less relevant, but different
+		{0.1, 0.995}, // Barely relevant
+	}
+
+	tests := []struct {
+		name   string
+		lambda float64
+		want   []int
+	}{
+		{"Relevance only", 1, []int{0, 1, 2}},
+		{"Balanced", 0.5, []int{0, 2, 1}},
+		{"Diversity only", 0, []int{0, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := maximalMarginalRelevance(MetricCosine, query, vectors, tt.lambda, 3)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchDiversify(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "notes"
+	api := NewAPI(store, &keywordEmbedder{keyword: "cat"}, &Config{Features: map[string]bool{"embedding": true}})
+
+	for _, doc := range []*Document{
+		{ID: "copy1", Content: "Cats sleep a lot", Vector: []float32{0.9, 0.436}},
+		{ID: "copy2", Content: "Cats sleep a lot", Vector: []float32{0.9, 0.436}},
+		{ID: "other", Content: "Cats chase mice", Vector: []float32{0.8, -0.6}},
+	} {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+	}
+
+	search := func(t *testing.T, body string) (*httptest.ResponseRecorder, []string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/notes/search", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.SearchDocuments(rec, req)
+
+		var resp SearchResponse
+		var ids []string
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			for _, r := range resp.Results {
+				ids = append(ids, r.Document.ID)
+			}
+		}
+		return rec, ids
+	}
+
+	_, ids := search(t, `{"query": "cat", "type": "vector", "limit": 2}`)
+	if strings.Join(ids, ",") != "copy1,copy2" && strings.Join(ids, ",") != "copy2,copy1" {
+		t.Errorf("plain search = %v, want both copies", ids)
+	}
+
+	_, ids = search(t, `{"query": "cat", "type": "vector", "limit": 2, "diversify": {"method": "mmr"}}`)
+	if len(ids) != 2 || !strings.HasPrefix(ids[0], "copy") || ids[1] != "other" {
+		t.Errorf("diversified search = %v, want a copy then other", ids)
+	}
+
+	// Lambda 0 ranks by diversity only instead of falling back to the default
+	if opts := normalizeDiversifyOptions(DiversifyOptions{Lambda: new(float64)}, 2); *opts.Lambda != 0 {
+		t.Errorf("lambda 0 normalized to %v", *opts.Lambda)
+	}
+	if opts := normalizeDiversifyOptions(DiversifyOptions{}, 2); *opts.Lambda != defaultMMRLambda {
+		t.Errorf("unset lambda normalized to %v, want %v", *opts.Lambda, defaultMMRLambda)
+	}
+
+	for _, body := range []string{
+		`{"query": "cat", "type": "vector", "diversify": {"method": "random"}}`,
+		`{"query": "cat", "type": "vector", "diversify": {"lambda": 1.5}}`,
+		`{"query": "cat", "type": "vector", "diversify": {"fetch_k": 5000}}`,
+		`{"query": "cat", "type": "fulltext", "diversify": {}}`,
+	} {
+		if rec, _ := search(t, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -24,15 +24,126 @@ type Embedder interface {
 	Dimensions() int
 }
 
+// Embedding providers selectable with Config.EmbeddingProvider
+const (
+	EmbeddingProviderLlamaCpp = "llamacpp"
+	EmbeddingProviderOpenAI   = "openai"
+	EmbeddingProviderStub     = "stub"
+)
+
+// DefaultEmbedderName names the embedder configured with the embedding_* options
+const DefaultEmbedderName = "default"
+
+// NewEmbedder creates the embedder selected by the configuration
+// Without an explicit provider, "stub" or an empty URL selects the stub
+// embedder and any other URL selects llama.cpp
+func NewEmbedder(config *Config) (Embedder, error) {
+	return newEmbedder(EmbedderConfig{
+		Provider:   config.EmbeddingProvider,
+		URL:        config.EmbeddingURL,
+		Model:      config.EmbeddingModel,
+		APIKey:     config.EmbeddingAPIKey,
+		Dimensions: config.EmbeddingDimensions,
+		BatchSize:  config.EmbeddingBatchSize,
+	}, config)
+}
+
+// newEmbedder creates one embedder; TLS options are shared by all of them
+func newEmbedder(ec EmbedderConfig, config *Config) (Embedder, error) {
+	provider := ec.Provider
+	if provider == "" {
+		provider = EmbeddingProviderLlamaCpp
+		if ec.URL == "" || ec.URL == "stub" {
+			provider = EmbeddingProviderStub
+		}
+	}
+
+	switch provider {
+	case EmbeddingProviderLlamaCpp:
+		return NewLlamaCppEmbedder(ec.URL, ec.Dimensions, ec.BatchSize,
+			config.InsecureSkipVerify, config.CACertPath)
+	case EmbeddingProviderOpenAI:
+		return NewOpenAIEmbedder(ec.URL, ec.Model, ec.APIKey,
+			ec.Dimensions, ec.BatchSize, config.InsecureSkipVerify, config.CACertPath)
+	case EmbeddingProviderStub:
+		return NewStubEmbedder(), nil
+	}
+
+	return nil, fmt.Errorf("unknown embedding provider: %s", provider)
+}
+
+// Embedders maps names to embedders, so each table can pick its own
+type Embedders map[string]Embedder
+
+// NewEmbedders creates the default embedder and every named one in the configuration
+func NewEmbedders(config *Config) (Embedders, error) {
+	embedder, err := NewEmbedder(config)
+	if err != nil {
+		return nil, err
+	}
+	embedders := Embedders{DefaultEmbedderName: embedder}
+
+	for name, ec := range config.Embedders {
+		if name == DefaultEmbedderName {
+			return nil, fmt.Errorf("embedder name %q is reserved for the embedding_* options", name)
+		}
+		embedder, err := newEmbedder(ec, config)
+		if err != nil {
+			return nil, fmt.Errorf("embedder %s: %w", name, err)
+		}
+		embedders[name] = embedder
+	}
+
+	return embedders, nil
+}
+
+// Get returns the embedder with the given name, the default one for ""
+func (e Embedders) Get(name string) (Embedder, error) {
+	if name == "" {
+		name = DefaultEmbedderName
+	}
+	embedder, ok := e[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedder: %s", name)
+	}
+	return embedder, nil
+}
+
 // LlamaCppEmbedder calls llama.cpp server for embeddings
 type LlamaCppEmbedder struct {
 	baseURL    string
 	dimensions int
+	batchSize  int // Maximum texts sent in one request by EmbedBatch
 	client     *http.Client
 }
 
+// defaultEmbeddingBatchSize is used when no embedding_batch_size is configured
+const defaultEmbeddingBatchSize = 32
+
+// defaultLlamaCppDimensions is used when no dimensions are configured for llama.cpp
+const defaultLlamaCppDimensions = 2560 // Qwen3 8b embedding size
+
 // NewLlamaCppEmbedder creates an embedder that calls llama.cpp
-func NewLlamaCppEmbedder(baseURL string, dimensions int, insecureSkipVerify bool, caCertPath string) (*LlamaCppEmbedder, error) {
+func NewLlamaCppEmbedder(baseURL string, dimensions, batchSize int, insecureSkipVerify bool, caCertPath string) (*LlamaCppEmbedder, error) {
+	client, err := newHTTPClient(insecureSkipVerify, caCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if dimensions <= 0 {
+		dimensions = defaultLlamaCppDimensions
+	}
+
+	return &LlamaCppEmbedder{
+		baseURL:    baseURL,
+		dimensions: dimensions,
+		batchSize:  batchSize,
+		client:     client,
+	}, nil
+}
+
+// newHTTPClient creates the HTTP client used to reach an embedding service
+func newHTTPClient(insecureSkipVerify bool, caCertPath string) (*http.Client, error) {
 	// Create custom TLS config
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: insecureSkipVerify,
@@ -58,12 +169,8 @@ func NewLlamaCppEmbedder(baseURL string, dimensions int, insecureSkipVerify bool
 		TLSClientConfig: tlsConfig,
 	}
 
-	return &LlamaCppEmbedder{
-		baseURL:    baseURL,
-		dimensions: dimensions,
-		client: &http.Client{
-			Transport: transport,
-		},
+	return &http.Client{
+		Transport: transport,
 	}, nil
 }
 
@@ -72,11 +179,27 @@ type EmbeddingRequest struct {
 	Content string `json:"content"`
 }
 
+// EmbeddingBatchRequest embeds several texts in one llama.cpp request
+type EmbeddingBatchRequest struct {
+	Content []string `json:"content"`
+}
+
 func (e *LlamaCppEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
-	reqBody := EmbeddingRequest{
-		Content: text,
+	vectors, err := e.request(ctx, EmbeddingRequest{Content: text}, 1)
+	if err != nil {
+		return nil, err
 	}
+	return vectors[0], nil
+}
+
+func (e *LlamaCppEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, e.batchSize, func(ctx context.Context, batch []string) ([][]float32, error) {
+		return e.request(ctx, EmbeddingBatchRequest{Content: batch}, len(batch))
+	})
+}
 
+// request posts to /embedding and returns the n vectors of the response
+func (e *LlamaCppEmbedder) request(ctx context.Context, reqBody interface{}, n int) ([][]float32, error) {
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -106,39 +229,64 @@ func (e *LlamaCppEmbedder) Embed(ctx context.Context, text string) ([]float32, e
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Try parsing as array first (simple float array)
-	var embeddingArray []float32
-	if err := json.Unmarshal(body, &embeddingArray); err == nil && len(embeddingArray) > 0 {
-		if len(embeddingArray) != e.dimensions {
-			return nil, fmt.Errorf("expected embedding dimension %d, got %d", e.dimensions, len(embeddingArray))
-		}
-		return embeddingArray, nil
+	vectors, err := parseLlamaCppEmbeddings(body, n)
+	if err != nil {
+		return nil, err
 	}
 
-	// Try parsing as object with "embedding" field
-	var embeddingResp struct {
-		Embedding []float32 `json:"embedding"`
+	for _, vector := range vectors {
+		if len(vector) != e.dimensions {
+			return nil, fmt.Errorf("expected embedding dimension %d, got %d", e.dimensions, len(vector))
+		}
 	}
-	if err := json.Unmarshal(body, &embeddingResp); err == nil && len(embeddingResp.Embedding) > 0 {
-		if len(embeddingResp.Embedding) != e.dimensions {
-			return nil, fmt.Errorf("expected embedding dimension %d, got %d", e.dimensions, len(embeddingResp.Embedding))
+
+	return vectors, nil
+}
+
+// parseLlamaCppEmbeddings understands the response shapes of llama.cpp and
+// similar servers:
+//   - [0.1, ...]                              a single vector
+//   - {"embedding": [0.1, ...]}               a single vector
+//   - [{"index": 0, "embedding": [[0.1, ...]]}, ...]  one entry per input text
+func parseLlamaCppEmbeddings(body []byte, n int) ([][]float32, error) {
+	if n == 1 {
+		// Try parsing as array first (simple float array)
+		var embeddingArray []float32
+		if err := json.Unmarshal(body, &embeddingArray); err == nil && len(embeddingArray) > 0 {
+			return [][]float32{embeddingArray}, nil
+		}
+
+		// Try parsing as object with "embedding" field
+		var embeddingResp struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		if err := json.Unmarshal(body, &embeddingResp); err == nil && len(embeddingResp.Embedding) > 0 {
+			return [][]float32{embeddingResp.Embedding}, nil
 		}
-		return embeddingResp.Embedding, nil
 	}
 
-	// Try parsing as array of objects with nested embedding (llama.cpp batch format)
+	// Try parsing as array of objects (llama.cpp batch format)
 	var batchResp []struct {
-		Index     int         `json:"index"`
-		Embedding [][]float32 `json:"embedding"`
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
 	}
 	if err := json.Unmarshal(body, &batchResp); err == nil && len(batchResp) > 0 {
-		if len(batchResp[0].Embedding) > 0 && len(batchResp[0].Embedding[0]) > 0 {
-			embedding := batchResp[0].Embedding[0]
-			if len(embedding) != e.dimensions {
-				return nil, fmt.Errorf("expected embedding dimension %d, got %d", e.dimensions, len(embedding))
+		if len(batchResp) != n {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", n, len(batchResp))
+		}
+
+		vectors := make([][]float32, n)
+		for _, item := range batchResp {
+			if item.Index < 0 || item.Index >= n || vectors[item.Index] != nil {
+				return nil, fmt.Errorf("unexpected embedding index %d", item.Index)
 			}
-			return embedding, nil
+			vector, err := decodePooledEmbedding(item.Embedding)
+			if err != nil {
+				return nil, err
+			}
+			vectors[item.Index] = vector
 		}
+		return vectors, nil
 	}
 
 	// If all failed, return error with response preview
@@ -149,14 +297,39 @@ func (e *LlamaCppEmbedder) Embed(ctx context.Context, text string) ([]float32, e
 	return nil, fmt.Errorf("failed to parse embedding response. Preview: %s", preview)
 }
 
-func (e *LlamaCppEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
-	vectors := make([][]float32, len(texts))
-	for i, text := range texts {
-		vector, err := e.Embed(ctx, text)
+// decodePooledEmbedding accepts a vector, or llama.cpp's list holding one pooled vector
+func decodePooledEmbedding(raw json.RawMessage) ([]float32, error) {
+	var vector []float32
+	if err := json.Unmarshal(raw, &vector); err == nil && len(vector) > 0 {
+		return vector, nil
+	}
+
+	var nested [][]float32
+	if err := json.Unmarshal(raw, &nested); err == nil && len(nested) > 0 && len(nested[0]) > 0 {
+		if len(nested) > 1 {
+			// One vector per token: the server runs without pooling
+			return nil, fmt.Errorf("got %d token embeddings instead of one pooled embedding, start llama.cpp with --pooling", len(nested))
+		}
+		return nested[0], nil
+	}
+
+	return nil, fmt.Errorf("failed to parse embedding")
+}
+
+// embedInBatches embeds texts with requests of at most batchSize texts each
+func embedInBatches(ctx context.Context, texts []string, batchSize int, embed func(context.Context, []string) ([][]float32, error)) ([][]float32, error) {
+	if batchSize <= 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		batch, err := embed(ctx, texts[start:end])
 		if err != nil {
-			return nil, fmt.Errorf("failed to embed text %d: %w", i, err)
+			return nil, fmt.Errorf("failed to embed texts %d-%d: %w", start, end-1, err)
 		}
-		vectors[i] = vector
+		vectors = append(vectors, batch...)
 	}
 
 	return vectors, nil
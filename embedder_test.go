@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLlamaCppEmbedBatch(t *testing.T) {
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content json.RawMessage `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		var single string
+		if json.Unmarshal(body.Content, &single) == nil {
+			requests = append(requests, []string{single})
+			json.NewEncoder(w).Encode(map[string][]float32{"embedding": {float32(len(single)), 0}})
+			return
+		}
+
+		var texts []string
+		json.Unmarshal(body.Content, &texts)
+		requests = append(requests, texts)
+
+		if texts[0] == "unpooled" {
+			w.Write([]byte(`[{"index": 0, "embedding": [[1, 0], [0, 1]]}]`))
+			return
+		}
+
+		// llama.cpp format, answered in reverse order
+		type item struct {
+			Index     int         `json:"index"`
+			Embedding [][]float32 `json:"embedding"`
+		}
+		var resp []item
+		for i := len(texts) - 1; i >= 0; i-- {
+			resp = append(resp, item{Index: i, Embedding: [][]float32{{float32(len(texts[i])), 1}}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	embedder, err := NewLlamaCppEmbedder(server.URL, 2, 2, false, "")
+	if err != nil {
+		t.Fatalf("NewLlamaCppEmbedder failed: %v", err)
+	}
+
+	t.Run("Batches", func(t *testing.T) {
+		requests = nil
+		vectors, err := embedder.EmbedBatch(context.Background(), []string{"a", "bb", "ccc", "dddd", "eeeee"})
+		if err != nil {
+			t.Fatalf("EmbedBatch failed: %v", err)
+		}
+
+		if len(requests) != 3 {
+			t.Errorf("sent %d requests, want 3: %v", len(requests), requests)
+		}
+		for i, vector := range vectors {
+			if vector[0] != float32(i+1) {
+				t.Errorf("vector %d = %v, results are out of order", i, vector)
+			}
+		}
+	})
+
+	t.Run("Single", func(t *testing.T) {
+		vector, err := embedder.Embed(context.Background(), "abc")
+		if err != nil {
+			t.Fatalf("Embed failed: %v", err)
+		}
+		if vector[0] != 3 {
+			t.Errorf("vector = %v", vector)
+		}
+	})
+
+	t.Run("Unpooled", func(t *testing.T) {
+		_, err := embedder.EmbedBatch(context.Background(), []string{"unpooled"})
+		if err == nil || !strings.Contains(err.Error(), "--pooling") {
+			t.Errorf("got error %v, want a pooling hint", err)
+		}
+	})
+}
+
+func TestNewEmbedders(t *testing.T) {
+	config := &Config{
+		EmbeddingURL: "stub",
+		Embedders: map[string]EmbedderConfig{
+			"small":  {Provider: EmbeddingProviderLlamaCpp, URL: "http://localhost:1234", Dimensions: 384},
+			"llama":  {Provider: EmbeddingProviderLlamaCpp, URL: "http://localhost:1234"},
+			"openai": {Provider: EmbeddingProviderOpenAI, Model: "text-embedding-ada-002"},
+		},
+	}
+
+	embedders, err := NewEmbedders(config)
+	if err != nil {
+		t.Fatalf("NewEmbedders failed: %v", err)
+	}
+	if e, err := embedders.Get(""); err != nil || e != embedders[DefaultEmbedderName] {
+		t.Errorf("Get(\"\") = %v, %v, want the default embedder", e, err)
+	}
+	if e, err := embedders.Get("small"); err != nil || e.Dimensions() != 384 {
+		t.Errorf("Get(small) = %v, %v", e, err)
+	}
+	if e, _ := embedders.Get("llama"); e.Dimensions() != defaultLlamaCppDimensions {
+		t.Errorf("llama.cpp dimensions = %d, want %d", e.Dimensions(), defaultLlamaCppDimensions)
+	}
+	// OpenAI-compatible models are only asked for dimensions when configured
+	if e, _ := embedders.Get("openai"); e.Dimensions() != 0 {
+		t.Errorf("openai dimensions = %d, want none", e.Dimensions())
+	}
+	if _, err := embedders.Get("large"); err == nil {
+		t.Error("Get(large) should fail for an unknown embedder")
+	}
+
+	config.Embedders[DefaultEmbedderName] = EmbedderConfig{Provider: EmbeddingProviderStub}
+	if _, err := NewEmbedders(config); err == nil {
+		t.Error("NewEmbedders should reject a named embedder called default")
+	}
+}
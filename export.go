@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ErrDocumentExists is returned by an import in ConflictFail mode
+var ErrDocumentExists = errors.New("document already exists")
+
+// ConflictMode decides what an import does with IDs that already exist
+type ConflictMode string
+
+const (
+	ConflictFail      ConflictMode = "fail"      // Abort the import, rolling back the current batch
+	ConflictSkip      ConflictMode = "skip"      // Keep the existing document
+	ConflictOverwrite ConflictMode = "overwrite" // Replace the existing document
+)
+
+// ExportTable streams every document of a table as JSONL
+// GET /db/{dbName}/{tableName}/_export?vectors=base64|none
+func (a *API) ExportTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+	clearDeadlines(w)
+
+	if !isValidTableName(tableName) {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid table name: %s", tableName))
+		return
+	}
+
+	includeVectors := true
+	switch r.URL.Query().Get("vectors") {
+	case "", "base64":
+	case "none":
+		includeVectors = false
+	default:
+		a.errorResponse(w, http.StatusBadRequest, "vectors must be base64 or none")
+		return
+	}
+
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	started := false
+
+	err := a.store.ExportDocuments(dbName, tableName, func(doc *Document) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s.jsonl"`, dbName, tableName))
+			started = true
+		}
+		return encoder.Encode(exportDocument(doc, includeVectors))
+	})
+	if err != nil {
+		if started {
+			// Headers are already sent, all we can do is cut the stream short
+			log.Printf("Export of %s.%s failed: %v", dbName, tableName, err)
+			return
+		}
+		if strings.Contains(err.Error(), "not found") {
+			a.errorResponse(w, http.StatusNotFound, err.Error())
+		} else {
+			a.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("export failed: %v", err))
+		}
+		return
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Export of %s.%s failed: %v", dbName, tableName, err)
+	}
+}
+
+// ImportTable loads JSONL produced by ExportTable into a table
+// POST /db/{dbName}/{tableName}/_import?on_conflict=fail|skip|overwrite
+// Documents are committed in batches of importBatchSize: an invalid line, or
+// a conflict in fail mode, stops the import and rolls back its batch, while
+// the batches before it are kept.
+func (a *API) ImportTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+	clearDeadlines(w)
+
+	if !isValidTableName(tableName) {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid table name: %s", tableName))
+		return
+	}
+
+	mode := ConflictMode(r.URL.Query().Get("on_conflict"))
+	switch mode {
+	case "":
+		mode = ConflictFail
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+	default:
+		a.errorResponse(w, http.StatusBadRequest, "on_conflict must be fail, skip or overwrite")
+		return
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+	line := 0
+	invalidLine := false
+
+	next := func() (*Document, error) {
+		for scanner.Scan() {
+			line++
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+
+			var exported ExportedDocument
+			if err := json.Unmarshal(text, &exported); err != nil {
+				invalidLine = true
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			doc, err := exported.toDocument()
+			if err != nil {
+				invalidLine = true
+			}
+			return doc, err
+		}
+		if err := scanner.Err(); err != nil {
+			line++
+			invalidLine = true
+			return nil, fmt.Errorf("failed to read line: %w", err)
+		}
+		return nil, io.EOF
+	}
+
+	summary, err := a.store.ImportDocuments(dbName, tableName, mode, next)
+	if err != nil {
+		committed := summary.Imported + summary.Overwritten + summary.Skipped
+		message := fmt.Sprintf("import stopped at line %d after %d committed documents: %v", line, committed, err)
+		switch {
+		case invalidLine:
+			a.errorResponse(w, http.StatusBadRequest, message)
+		case errors.Is(err, ErrDocumentExists):
+			a.errorResponse(w, http.StatusConflict, message)
+		case errors.Is(err, ErrDimensionMismatch), errors.Is(err, ErrChunkID):
+			a.errorResponse(w, http.StatusBadRequest, message)
+		default:
+			a.errorResponse(w, http.StatusInternalServerError, message)
+		}
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, summary)
+}
+
+// exportDocument converts a stored document to its export representation
+func exportDocument(doc *Document, includeVector bool) ExportedDocument {
+	exported := ExportedDocument{
+		ID:         doc.ID,
+		Content:    doc.Content,
+		Metadata:   doc.Metadata,
+		Tags:       doc.Tags,
+		CreatedAt:  doc.CreatedAt,
+		UpdatedAt:  doc.UpdatedAt,
+		IsEmbedded: doc.IsEmbedded,
+		ChunkCount: doc.ChunkCount,
+	}
+	if doc.Chunk != nil {
+		exported.ParentID = doc.Chunk.ParentID
+		exported.ChunkIndex = doc.Chunk.Index
+	}
+	if includeVector && len(doc.Vector) > 0 {
+		exported.Vector = base64.StdEncoding.EncodeToString(serializeVector(doc.Vector))
+	}
+	return exported
+}
+
+// toDocument validates an imported line and converts it to a document
+// Documents without a vector are imported as not embedded so the embedding
+// worker picks them up again
+func (e *ExportedDocument) toDocument() (*Document, error) {
+	if e.Content == "" {
+		return nil, fmt.Errorf("content is required")
+	}
+
+	doc := &Document{
+		ID:        e.ID,
+		Content:   e.Content,
+		Metadata:  e.Metadata,
+		Tags:      e.Tags,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+
+		ChunkCount: e.ChunkCount,
+	}
+	if e.ParentID != "" {
+		doc.Chunk = &ChunkRef{ParentID: e.ParentID, Index: e.ChunkIndex}
+	}
+	// Chunked documents are embedded through their chunks
+	if e.ChunkCount > 0 {
+		doc.IsEmbedded = e.IsEmbedded
+	}
+
+	if e.Vector != "" {
+		raw, err := base64.StdEncoding.DecodeString(e.Vector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector encoding: %w", err)
+		}
+		if len(raw)%4 != 0 {
+			return nil, fmt.Errorf("invalid vector length %d bytes", len(raw))
+		}
+		doc.Vector = deserializeVector(raw)
+		doc.IsEmbedded = true
+	}
+
+	return doc, nil
+}
+
+// ExportDocuments calls fn for every document of a table in insertion order
+func (s *DocumentStore) ExportDocuments(dbId, tableName string, fn func(*Document) error) error {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	exists, err := tableExists(db, tableName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("table not found: %s", tableName)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded,
+		       parent_id, chunk_index, chunk_count
+		FROM "%s"
+		ORDER BY rowid
+	`, tableName)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc Document
+		var metadataJSON sql.NullString
+		var tagsStr sql.NullString
+		var vectorBytes []byte
+		var isEmbedded int
+		var parentID sql.NullString
+		var chunkIndex, chunkCount int
+
+		if err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &tagsStr, &vectorBytes,
+			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &parentID, &chunkIndex, &chunkCount); err != nil {
+			return err
+		}
+
+		doc.DB = dbId
+		doc.Table = tableName
+		doc.IsEmbedded = isEmbedded == 1
+		doc.setChunkInfo(parentID, chunkIndex, chunkCount)
+
+		if metadataJSON.String != "" {
+			if err := json.Unmarshal([]byte(metadataJSON.String), &doc.Metadata); err != nil {
+				return fmt.Errorf("failed to unmarshal metadata of %s: %w", doc.ID, err)
+			}
+		}
+		if tagsStr.String != "" {
+			doc.Tags = strings.Split(tagsStr.String, ",")
+		}
+		if len(vectorBytes) > 0 {
+			doc.Vector = deserializeVector(vectorBytes)
+		}
+
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// importBatchSize is the number of documents an import writes per transaction
+const importBatchSize = 500
+
+// ImportDocuments writes the documents returned by next, keeping IDs,
+// timestamps, tags and vectors. next returns io.EOF when done. Documents are
+// read and committed in batches of importBatchSize, so a long upload never
+// holds the write lock while it is being read. An error rolls back the batch
+// it occurred in; the returned summary counts the batches committed before.
+func (s *DocumentStore) ImportDocuments(dbId, tableName string, mode ConflictMode, next func() (*Document, error)) (ImportSummary, error) {
+	var summary ImportSummary
+
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return summary, err
+	}
+	defer release()
+
+	if err := s.ensureTable(db, tableName); err != nil {
+		return summary, err
+	}
+
+	// Rebuilding once is cheaper than applying a large import to the graph
+	defer func() {
+		if summary.Imported+summary.Overwritten > 0 {
+			s.dropVectorIndex(dbId, tableName)
+			s.notifyEmbeddingJobs(dbId)
+		}
+	}()
+
+	for {
+		batch := make([]*Document, 0, importBatchSize)
+		var readErr error
+		for len(batch) < importBatchSize {
+			var doc *Document
+			if doc, readErr = next(); readErr != nil {
+				break
+			}
+			batch = append(batch, doc)
+		}
+		if readErr != nil && readErr != io.EOF {
+			return summary, readErr
+		}
+
+		if len(batch) > 0 {
+			batchSummary, err := importBatch(db, tableName, mode, batch)
+			if err != nil {
+				return summary, err
+			}
+			summary.Imported += batchSummary.Imported
+			summary.Overwritten += batchSummary.Overwritten
+			summary.Skipped += batchSummary.Skipped
+		}
+
+		if readErr == io.EOF {
+			return summary, nil
+		}
+	}
+}
+
+// importBatch writes one batch of an import in a transaction
+func importBatch(db *sql.DB, tableName string, mode ConflictMode, docs []*Document) (ImportSummary, error) {
+	var summary ImportSummary
+
+	tx, err := db.Begin()
+	if err != nil {
+		return summary, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existsQuery := fmt.Sprintf(`SELECT 1 FROM "%s" WHERE id = ?`, tableName)
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO "%s" (id, content, metadata, tags, vector, created_at, updated_at, is_embedded, parent_id, chunk_index, chunk_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			content = excluded.content,
+			metadata = excluded.metadata,
+			tags = excluded.tags,
+			vector = excluded.vector,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			is_embedded = excluded.is_embedded,
+			parent_id = excluded.parent_id,
+			chunk_index = excluded.chunk_index,
+			chunk_count = excluded.chunk_count
+	`, tableName)
+
+	for _, doc := range docs {
+		if doc.ID == "" {
+			doc.ID = uuid.New().String()
+		}
+		if err := checkImportedID(doc); err != nil {
+			return ImportSummary{}, err
+		}
+
+		var exists int
+		err = tx.QueryRow(existsQuery, doc.ID).Scan(&exists)
+		if err != nil && err != sql.ErrNoRows {
+			return ImportSummary{}, err
+		}
+
+		if exists == 1 {
+			switch mode {
+			case ConflictSkip:
+				summary.Skipped++
+				continue
+			case ConflictOverwrite:
+				summary.Overwritten++
+				// Chunks beyond the imported chunk count belong to the replaced version
+				if doc.Chunk == nil {
+					if _, err := deleteChunks(tx, tableName, doc.ID, doc.ChunkCount); err != nil {
+						return ImportSummary{}, fmt.Errorf("document %s: %w", doc.ID, err)
+					}
+				}
+			default:
+				return ImportSummary{}, fmt.Errorf("%w: %s", ErrDocumentExists, doc.ID)
+			}
+		} else {
+			summary.Imported++
+		}
+
+		now := time.Now()
+		if doc.CreatedAt.IsZero() {
+			doc.CreatedAt = now
+		}
+		if doc.UpdatedAt.IsZero() {
+			doc.UpdatedAt = doc.CreatedAt
+		}
+
+		metadataJSON, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return ImportSummary{}, fmt.Errorf("failed to marshal metadata of %s: %w", doc.ID, err)
+		}
+
+		if err := checkDimensions(tx, tableName, doc.Vector); err != nil {
+			return ImportSummary{}, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+
+		var vectorBytes []byte
+		if len(doc.Vector) > 0 {
+			vectorBytes = serializeVector(doc.Vector)
+		}
+
+		var parentID interface{}
+		chunkIndex := 0
+		if doc.Chunk != nil {
+			parentID = doc.Chunk.ParentID
+			chunkIndex = doc.Chunk.Index
+		}
+
+		if _, err := tx.Exec(insertQuery, doc.ID, doc.Content, string(metadataJSON),
+			strings.Join(doc.Tags, ","), vectorBytes, doc.CreatedAt, doc.UpdatedAt, boolToInt(doc.IsEmbedded),
+			parentID, chunkIndex, doc.ChunkCount); err != nil {
+			return ImportSummary{}, fmt.Errorf("failed to import %s: %w", doc.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportSummary{}, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return summary, nil
+}
+
+// checkImportedID rejects documents whose IDs could collide with chunk IDs
+// and chunks whose IDs do not match their parent and index
+func checkImportedID(doc *Document) error {
+	if doc.Chunk == nil {
+		return checkDocumentID(doc.ID)
+	}
+	if err := checkDocumentID(doc.Chunk.ParentID); err != nil {
+		return err
+	}
+	if want := chunkID(doc.Chunk.ParentID, doc.Chunk.Index); doc.ID != want || doc.Chunk.Index < 0 {
+		return fmt.Errorf("%w: chunk %d of %s must have ID %q, not %q", ErrChunkID, doc.Chunk.Index, doc.Chunk.ParentID, want, doc.ID)
+	}
+	return nil
+}
+
+// tableExists reports whether a document table exists in the database
+func tableExists(db *sql.DB, tableName string) (bool, error) {
+	var name string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, tableName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
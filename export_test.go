@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func exportTable(t *testing.T, api *API, dbName, tableName, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/db/"+dbName+"/"+tableName+"/_export"+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+	rec := httptest.NewRecorder()
+	api.ExportTable(rec, req)
+	return rec
+}
+
+func importTable(t *testing.T, api *API, dbName, tableName, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/db/"+dbName+"/"+tableName+"/_import"+query, strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+	rec := httptest.NewRecorder()
+	api.ImportTable(rec, req)
+	return rec
+}
+
+func TestExportImport(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	api := NewAPI(store, nil, &Config{})
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	docs := []*Document{
+		{
+			ID:        "doc1",
+			Content:   "Embedded document",
+			Tags:      []string{"a", "b"},
+			Metadata:  map[string]interface{}{"year": float64(2024)},
+			Vector:    []float32{0.25, -1.5, 3},
+			CreatedAt: created,
+		},
+		{
+			ID:        "doc2",
+			Content:   "Plain document",
+			CreatedAt: created.Add(time.Hour),
+		},
+	}
+	for _, doc := range docs {
+		if err := store.StoreDocument("source", "articles", doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	rec := exportTable(t, api, "source", "articles", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d: %s", rec.Code, rec.Body.String())
+	}
+	export := rec.Body.String()
+	if lines := strings.Count(export, "\n"); lines != 2 {
+		t.Fatalf("export has %d lines, want 2:\n%s", lines, export)
+	}
+
+	t.Run("Round trip keeps every field", func(t *testing.T) {
+		rec := importTable(t, api, "target", "articles", "", export)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("import status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		for _, want := range docs {
+			got, err := store.GetDocument("target", "articles", want.ID)
+			if err != nil {
+				t.Fatalf("GetDocument(%s) failed: %v", want.ID, err)
+			}
+			if got.Content != want.Content || !reflect.DeepEqual(got.Tags, want.Tags) ||
+				!reflect.DeepEqual(got.Vector, want.Vector) || got.IsEmbedded != want.IsEmbedded {
+				t.Errorf("%s: got %+v, want %+v", want.ID, got, want)
+			}
+			if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+				t.Errorf("%s: timestamps %v/%v, want %v/%v", want.ID, got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+			}
+		}
+
+		results, err := store.SearchVector("target", "articles", []float32{0.25, -1.5, 3}, 1, nil, VectorSearchOptions{})
+		if err != nil || len(results) != 1 || results[0].Document.ID != "doc1" {
+			t.Errorf("imported vector not searchable: %v %v", results, err)
+		}
+	})
+
+	t.Run("Conflict fail rolls back", func(t *testing.T) {
+		body := `{"id": "new", "content": "New document"}` + "\n" + export
+		rec := importTable(t, api, "target", "articles", "?on_conflict=fail", body)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body.String())
+		}
+		if _, err := store.GetDocument("target", "articles", "new"); err == nil {
+			t.Error("document before the conflict should have been rolled back")
+		}
+	})
+
+	t.Run("Conflict skip", func(t *testing.T) {
+		body := `{"id": "doc1", "content": "Changed"}` + "\n" + `{"id": "doc3", "content": "Third"}`
+		rec := importTable(t, api, "target", "articles", "?on_conflict=skip", body)
+
+		var summary ImportSummary
+		json.NewDecoder(rec.Body).Decode(&summary)
+		if summary != (ImportSummary{Imported: 1, Skipped: 1}) {
+			t.Errorf("summary = %+v", summary)
+		}
+		if doc, _ := store.GetDocument("target", "articles", "doc1"); doc.Content != "Embedded document" {
+			t.Errorf("skipped document changed to %q", doc.Content)
+		}
+	})
+
+	t.Run("Conflict overwrite", func(t *testing.T) {
+		body := `{"id": "doc1", "content": "Changed"}`
+		rec := importTable(t, api, "target", "articles", "?on_conflict=overwrite", body)
+
+		var summary ImportSummary
+		json.NewDecoder(rec.Body).Decode(&summary)
+		if summary != (ImportSummary{Overwritten: 1}) {
+			t.Errorf("summary = %+v", summary)
+		}
+		doc, _ := store.GetDocument("target", "articles", "doc1")
+		if doc.Content != "Changed" || doc.IsEmbedded || len(doc.Vector) != 0 {
+			t.Errorf("overwritten document = %+v", doc)
+		}
+	})
+
+	t.Run("Overwriting a chunked document drops its old chunks", func(t *testing.T) {
+		long := &Document{ID: "long", Content: "# One\nfirst\n\n# Two\nsecond\n\n# Three\nthird"}
+		applyChunking(long, &ChunkingOptions{Strategy: ChunkByHeading, MaxTokens: defaultChunkMaxTokens})
+		if err := store.StoreDocument("target", "articles", long); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		body := `{"id": "long", "content": "# One\nfirst\n\n# Two\nchanged", "chunk_count": 2}
+{"id": "long#0", "content": "# One\nfirst", "parent_id": "long", "chunk_index": 0}
+{"id": "long#1", "content": "# Two\nchanged", "parent_id": "long", "chunk_index": 1}`
+		if rec := importTable(t, api, "target", "articles", "?on_conflict=overwrite", body); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if doc, err := store.GetDocument("target", "articles", "long#1"); err != nil || doc.Content != "# Two\nchanged" {
+			t.Errorf("imported chunk = %+v, %v", doc, err)
+		}
+		if _, err := store.GetDocument("target", "articles", "long#2"); err == nil {
+			t.Error("chunk beyond the imported chunk count should be deleted")
+		}
+
+		if rec := importTable(t, api, "target", "articles", "?on_conflict=overwrite", `{"id": "long", "content": "short"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, err := store.GetDocument("target", "articles", "long#0"); err == nil {
+			t.Error("chunks of a document imported unchunked should be deleted")
+		}
+	})
+
+	t.Run("Chunk IDs must match their parent and index", func(t *testing.T) {
+		for _, line := range []string{
+			`{"id": "other", "content": "x", "parent_id": "long", "chunk_index": 0}`,
+			`{"id": "long#3", "content": "x", "parent_id": "long", "chunk_index": 4}`,
+			`{"id": "a#b#0", "content": "x", "parent_id": "a#b", "chunk_index": 0}`,
+		} {
+			if rec := importTable(t, api, "target", "articles", "", line); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want %d", line, rec.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("Export without vectors", func(t *testing.T) {
+		rec := exportTable(t, api, "source", "articles", "?vectors=none")
+		if strings.Contains(rec.Body.String(), `"vector"`) {
+			t.Errorf("export contains vectors:\n%s", rec.Body.String())
+		}
+	})
+
+	t.Run("Invalid line", func(t *testing.T) {
+		rec := importTable(t, api, "target", "articles", "", `{"id": "bad", "content": "x", "vector": "not base64!"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Missing table", func(t *testing.T) {
+		rec := exportTable(t, api, "source", "missing", "")
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		if rec := exportTable(t, api, "source", "_table_settings", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("internal table: status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Large imports commit in batches", func(t *testing.T) {
+		// The second batch repeats an ID of the first one
+		var body strings.Builder
+		for i := 0; i < importBatchSize+10; i++ {
+			fmt.Fprintf(&body, `{"id": "bulk-%d", "content": "Document %d"}`+"\n", i, i)
+		}
+		body.WriteString(`{"id": "bulk-0", "content": "Conflict"}`)
+
+		rec := importTable(t, api, "target", "batched", "", body.String())
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body.String())
+		}
+		if _, err := store.GetDocument("target", "batched", "bulk-0"); err != nil {
+			t.Errorf("committed batch lost: %v", err)
+		}
+		if _, err := store.GetDocument("target", "batched", fmt.Sprintf("bulk-%d", importBatchSize)); err == nil {
+			t.Error("document of the failed batch should have been rolled back")
+		}
+	})
+}
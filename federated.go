@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// federatedSearchConcurrency bounds the tables searched at once by one request
+const federatedSearchConcurrency = 8
+
+// ErrSearchTargetNotFound is returned when a federated search names a
+// database or table that does not exist
+var ErrSearchTargetNotFound = errors.New("not found")
+
+// searchTarget is one table searched by a federated search
+type searchTarget struct {
+	db    string
+	table string
+}
+
+// FederatedSearch searches several tables of a database as one
+// POST /db/{dbName}/_search
+func (a *API) FederatedSearch(w http.ResponseWriter, r *http.Request) {
+	dbName := mux.Vars(r)["dbName"]
+
+	var req FederatedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	a.federatedSearch(w, r, dbName, []string{dbName}, req)
+}
+
+// GlobalSearch searches tables across several databases as one
+// POST /_search
+func (a *API) GlobalSearch(w http.ResponseWriter, r *http.Request) {
+	var req FederatedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// Naming a database the API key may not read is an error; wildcards
+	// only select the readable ones
+	for _, db := range req.Databases {
+		if db == "*" {
+			continue
+		}
+		if err := authorize(r.Context(), db, ScopeRead); err != nil {
+			a.errorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	a.federatedSearch(w, r, "", req.Databases, req)
+}
+
+// federatedSearch runs req against every selected table in parallel and
+// merges the results into one ranking
+func (a *API) federatedSearch(w http.ResponseWriter, r *http.Request, dbName string, databases []string, req FederatedSearchRequest) {
+	if err := validateSearchRequest(&req.SearchRequest); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	targets, err := a.searchTargets(databases, req.Tables, dbName != "")
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSearchTargetNotFound) {
+			status = http.StatusNotFound
+		}
+		a.errorResponse(w, status, err.Error())
+		return
+	}
+	targets = slices.DeleteFunc(targets, func(target searchTarget) bool {
+		return authorize(r.Context(), target.db, ScopeRead) != nil
+	})
+
+	lists := make([][]SearchResult, len(targets))
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, federatedSearchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			lists[i], errs[i] = a.searchTable(r.Context(), target.db, target.table, req.SearchRequest)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			a.searchErrorResponse(w, fmt.Errorf("%s.%s: %w", targets[i].db, targets[i].table, err))
+			return
+		}
+	}
+
+	results := mergeRankings(lists, req.Limit)
+
+	a.jsonResponse(w, http.StatusOK, SearchResponse{
+		Results: results,
+		Query:   req.Query,
+		Type:    req.Type,
+		DB:      dbName,
+		Total:   len(results),
+	})
+}
+
+// searchTargets resolves the databases and tables to search. Empty lists and
+// "*" select everything. Naming a missing database is an error; a missing
+// table is one too when strictTables is set, otherwise it is skipped so a
+// table list can span databases with different tables.
+func (a *API) searchTargets(databases, tables []string, strictTables bool) ([]searchTarget, error) {
+	existing, err := a.store.databaseNames()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(databases) == 0 || slices.Contains(databases, "*") {
+		databases = existing
+	}
+
+	var targets []searchTarget
+	for _, db := range databases {
+		if !slices.Contains(existing, db) {
+			return nil, fmt.Errorf("database %w: %s", ErrSearchTargetNotFound, db)
+		}
+
+		dbTables, err := a.store.ListTables(db)
+		if err != nil {
+			return nil, err
+		}
+
+		selected := tables
+		if len(selected) == 0 || slices.Contains(selected, "*") {
+			selected = dbTables
+		}
+		for _, table := range selected {
+			if !slices.Contains(dbTables, table) {
+				if strictTables {
+					return nil, fmt.Errorf("table %w: %s.%s", ErrSearchTargetNotFound, db, table)
+				}
+				continue
+			}
+			targets = append(targets, searchTarget{db: db, table: table})
+		}
+	}
+
+	return targets, nil
+}
+
+// mergeRankings merges per-table rankings into one by normalized score.
+// Raw scores are not comparable across tables (bm25 depends on each table's
+// statistics and is lower-is-better, similarities depend on the embedder),
+// so each list is min-max scaled to [0, 1] from its worst to its best
+// result. A list whose results all score the same scales to 1. Each list
+// must be ordered best first; ties keep the order of the lists.
+func mergeRankings(lists [][]SearchResult, limit int) []SearchResult {
+	var merged []SearchResult
+	for _, list := range lists {
+		if len(list) == 0 {
+			continue
+		}
+		best, worst := list[0].Score, list[len(list)-1].Score
+		for _, result := range list {
+			if best == worst {
+				result.Score = 1
+			} else {
+				result.Score = (result.Score - worst) / (best - worst)
+			}
+			merged = append(merged, result)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	for i := range merged {
+		merged[i].Rank = i + 1
+	}
+	return merged
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMergeRankings(t *testing.T) {
+	result := func(table, id string, score float64) SearchResult {
+		return SearchResult{Document: Document{DB: "kb", Table: table, ID: id}, Score: score}
+	}
+
+	// bm25 scores are lower-is-better, similarities higher-is-better; a
+	// single weak result still ranks first in its table
+	fullText := []SearchResult{result("a", "ft1", -9), result("a", "ft2", -5), result("a", "ft3", -1)}
+	vector := []SearchResult{result("b", "v1", 0.9), result("b", "v2", 0.8)}
+	single := []SearchResult{result("c", "ft1", 0.2)}
+
+	merged := mergeRankings([][]SearchResult{fullText, vector, single}, 4)
+
+	var got []string
+	for _, r := range merged {
+		got = append(got, r.Document.Table+"/"+r.Document.ID)
+	}
+	if strings.Join(got, ",") != "a/ft1,b/v1,c/ft1,a/ft2" {
+		t.Errorf("merged order = %v, documents of different tables must stay apart", got)
+	}
+	if merged[0].Score != 1 || merged[2].Score != 1 || merged[3].Score != 0.5 || merged[3].Rank != 4 {
+		t.Errorf("merged = %+v", merged)
+	}
+
+	// A table whose results are all close to its best outranks the tail of a
+	// table whose results fall off quickly
+	even := []SearchResult{result("a", "e1", 0.9), result("a", "e2", 0.89), result("a", "e3", 0.88), result("a", "e4", 0.1)}
+	steep := []SearchResult{result("b", "s1", 0.9), result("b", "s2", 0.5), result("b", "s3", 0.1)}
+
+	got = nil
+	for _, r := range mergeRankings([][]SearchResult{even, steep}, 10) {
+		got = append(got, r.Document.Table+"/"+r.Document.ID)
+	}
+	if strings.Join(got, ",") != "a/e1,b/s1,a/e2,a/e3,b/s2,a/e4,b/s3" {
+		t.Errorf("merged order = %v, want results ordered by normalized score", got)
+	}
+}
+
+func TestFederatedSearch(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	for _, doc := range []struct{ db, table, id, content string }{
+		{"kb", "runbooks", "r1", "Restart the database after an outage"},
+		{"kb", "runbooks", "r2", "Rotate the certificates yearly"},
+		{"kb", "tickets", "t1", "Database outage on Monday"},
+		{"kb", "notes", "n1", "Lunch menu"},
+		{"archive", "tickets", "t9", "Old database migration"},
+	} {
+		if err := store.StoreDocument(doc.db, doc.table, &Document{ID: doc.id, Content: doc.content}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+	}
+
+	search := func(t *testing.T, dbName, body string) (*httptest.ResponseRecorder, SearchResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/_search", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		if dbName == "" {
+			api.GlobalSearch(rec, req)
+		} else {
+			api.FederatedSearch(rec, mux.SetURLVars(req, map[string]string{"dbName": dbName}))
+		}
+
+		var resp SearchResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	sources := func(resp SearchResponse) []string {
+		var got []string
+		for _, r := range resp.Results {
+			got = append(got, r.Document.DB+"."+r.Document.Table+"/"+r.Document.ID)
+		}
+		return got
+	}
+
+	t.Run("Tables of a database", func(t *testing.T) {
+		rec, resp := search(t, "kb", `{"query": "database", "tables": ["runbooks", "tickets"]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		got := sources(resp)
+		if len(got) != 2 || !strings.Contains(strings.Join(got, " "), "kb.runbooks/r1") || !strings.Contains(strings.Join(got, " "), "kb.tickets/t1") {
+			t.Errorf("results = %v", got)
+		}
+		if resp.DB != "kb" || resp.Results[1].Rank != 2 {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("Wildcard across databases", func(t *testing.T) {
+		_, resp := search(t, "", `{"query": "database", "databases": ["*"], "limit": 10}`)
+		if got := sources(resp); len(got) != 3 || !strings.Contains(strings.Join(got, " "), "archive.tickets/t9") {
+			t.Errorf("results = %v", got)
+		}
+
+		// A table list spans databases that lack some of the tables
+		_, resp = search(t, "", `{"query": "database", "tables": ["runbooks"]}`)
+		if got := sources(resp); len(got) != 1 || got[0] != "kb.runbooks/r1" {
+			t.Errorf("results = %v", got)
+		}
+	})
+
+	t.Run("Vector search in parallel", func(t *testing.T) {
+		rec, resp := search(t, "kb", `{"query": "database", "type": "vector", "tables": ["*"]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if resp.Total != 0 {
+			t.Errorf("unembedded documents should not match: %v", sources(resp))
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		if rec, _ := search(t, "", `{"query": "x", "databases": ["missing"]}`); rec.Code != http.StatusNotFound {
+			t.Errorf("missing database: status = %d, want 404", rec.Code)
+		}
+		if rec, _ := search(t, "kb", `{"query": "x", "tables": ["missing"]}`); rec.Code != http.StatusNotFound {
+			t.Errorf("missing table: status = %d, want 404", rec.Code)
+		}
+		if rec, _ := search(t, "kb", `{"query": "x", "type": "fuzzy"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid type: status = %d, want 400", rec.Code)
+		}
+		if rec, _ := search(t, "kb", `{"query": "x", "filters": {"$bogus": 1}}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid filter: status = %d, want 400", rec.Code)
+		}
+	})
+}
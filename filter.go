@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidFilter is returned when search filters cannot be compiled
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// FilterError describes a filter that was rejected, naming the offending key
+type FilterError struct {
+	Key    string // Filter key as given by the client, e.g. "author.name" or "$or"
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter %q: %s", e.Key, e.Reason)
+}
+
+// Unwrap lets callers test for ErrInvalidFilter with errors.Is
+func (e *FilterError) Unwrap() error {
+	return ErrInvalidFilter
+}
+
+func filterErrorf(key, format string, args ...interface{}) error {
+	return &FilterError{Key: key, Reason: fmt.Sprintf(format, args...)}
+}
+
+// filterCompiler turns a filter document into a SQL condition
+// Supported syntax (Mongo-style):
+//   - {"field": value}                      equality, matches any element of an array field
+//   - {"field": {"$op": value, ...}}        operators, combined with AND
+//   - {"$and": [...]}, {"$or": [...]}       boolean groups of filter documents
+//   - {"$not": {...}}                       negation of a filter document
+//   - {"tag": "x"}, {"tags": ["x", "y"]}    tag filters (AND logic for lists)
+//
+// Field operators: $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $contains
+// Tag operators: $in (any), $nin (none), $all (every)
+// Fields are JSON paths into metadata, see parseFieldPath
+//
+// Values and JSON paths are always bound as query arguments, never spliced
+// into the SQL text.
+type filterCompiler struct {
+	prefix string // Table alias prefix, e.g. "d."
+	args   []interface{}
+}
+
+// filterField is a metadata field referenced by a filter
+type filterField struct {
+	name string // Key as written in the filter
+	path string // Validated SQLite JSON path, e.g. $."author"."name"
+}
+
+// buildFilterClause builds a WHERE clause from filters
+// tableAlias: optional table alias prefix (e.g., "d" for "d.tags")
+// Returns the clause (starting with " AND ") and the arguments for the query
+func buildFilterClause(filters map[string]interface{}, tableAlias string) (string, []interface{}, error) {
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+
+	c := &filterCompiler{}
+	if tableAlias != "" {
+		c.prefix = tableAlias + "."
+	}
+
+	condition, err := c.compileDocument(filters)
+	if err != nil {
+		return "", nil, err
+	}
+	if condition == "" {
+		return "", nil, nil
+	}
+
+	return " AND " + condition, c.args, nil
+}
+
+// compileDocument compiles a filter object; all of its keys must match
+func (c *filterCompiler) compileDocument(doc map[string]interface{}) (string, error) {
+	var conditions []string
+	// Sorted keys keep the generated SQL deterministic
+	for _, key := range sortedKeys(doc) {
+		condition, err := c.compileKey(key, doc[key])
+		if err != nil {
+			return "", err
+		}
+		if condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	return joinConditions(conditions, " AND "), nil
+}
+
+// compileKey compiles a single key/value pair of a filter object
+func (c *filterCompiler) compileKey(key string, value interface{}) (string, error) {
+	switch key {
+	case "$and", "$or":
+		docs, err := filterDocuments(key, value)
+		if err != nil {
+			return "", err
+		}
+		var conditions []string
+		for _, doc := range docs {
+			condition, err := c.compileDocument(doc)
+			if err != nil {
+				return "", err
+			}
+			if condition != "" {
+				conditions = append(conditions, "("+condition+")")
+			}
+		}
+		if key == "$and" {
+			return joinConditions(conditions, " AND "), nil
+		}
+		if len(conditions) == 0 {
+			// An empty $or matches nothing
+			return "0", nil
+		}
+		return joinConditions(conditions, " OR "), nil
+
+	case "$not":
+		doc, ok := value.(map[string]interface{})
+		if !ok {
+			return "", filterErrorf(key, "expects an object")
+		}
+		condition, err := c.compileDocument(doc)
+		if err != nil {
+			return "", err
+		}
+		if condition == "" {
+			return "", nil
+		}
+		return "NOT (" + condition + ")", nil
+
+	case "tag", "tags":
+		return c.compileTags(key, value)
+	}
+
+	if strings.HasPrefix(key, "$") {
+		return "", filterErrorf(key, "unknown operator")
+	}
+
+	path, err := parseFieldPath(key)
+	if err != nil {
+		return "", &FilterError{Key: key, Reason: err.Error()}
+	}
+
+	return c.compileField(filterField{name: key, path: path}, value)
+}
+
+// compileTags compiles a tag filter against the comma-separated tags column
+func (c *filterCompiler) compileTags(key string, value interface{}) (string, error) {
+	ops, isOps := operatorObject(value)
+	if !isOps {
+		// Plain tags: every listed tag must be present
+		tags, err := stringList(key, value)
+		if err != nil {
+			return "", err
+		}
+		return c.tagConditions(tags, " AND "), nil
+	}
+
+	var conditions []string
+	for _, op := range sortedKeys(ops) {
+		tags, err := stringList(key, ops[op])
+		if err != nil {
+			return "", err
+		}
+
+		switch op {
+		case "$in":
+			if len(tags) == 0 {
+				conditions = append(conditions, "0")
+				continue
+			}
+			conditions = append(conditions, "("+c.tagConditions(tags, " OR ")+")")
+		case "$nin":
+			if len(tags) == 0 {
+				continue
+			}
+			conditions = append(conditions, "NOT ("+c.tagConditions(tags, " OR ")+")")
+		case "$all", "$eq", "$contains":
+			if condition := c.tagConditions(tags, " AND "); condition != "" {
+				conditions = append(conditions, condition)
+			}
+		default:
+			return "", filterErrorf(key, "unsupported tag operator %s", op)
+		}
+	}
+
+	return joinConditions(conditions, " AND "), nil
+}
+
+// tagConditions matches each tag in the comma-separated tags field
+func (c *filterCompiler) tagConditions(tags []string, sep string) string {
+	var conditions []string
+	for _, tag := range tags {
+		// Match: exact tag, or tag at start, or tag in middle/end
+		p := c.prefix
+		conditions = append(conditions, fmt.Sprintf("(%stags = ? OR %stags LIKE ? OR %stags LIKE ? OR %stags LIKE ?)", p, p, p, p))
+		c.args = append(c.args, tag, tag+",%", "%,"+tag+",%", "%,"+tag)
+	}
+	return joinConditions(conditions, sep)
+}
+
+// compileField compiles a filter on a metadata field
+func (c *filterCompiler) compileField(field filterField, value interface{}) (string, error) {
+	ops, isOps := operatorObject(value)
+	if !isOps {
+		return c.fieldEquals(field, value)
+	}
+
+	var conditions []string
+	for _, op := range sortedKeys(ops) {
+		condition, err := c.fieldOperator(field, op, ops[op])
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return joinConditions(conditions, " AND "), nil
+}
+
+// fieldOperator compiles one operator applied to a metadata field
+func (c *filterCompiler) fieldOperator(field filterField, op string, operand interface{}) (string, error) {
+	switch op {
+	case "$eq":
+		return c.fieldEquals(field, operand)
+
+	case "$ne":
+		if operand == nil {
+			return fmt.Sprintf("COALESCE(%s, 'null') != 'null'", c.jsonType(field)), nil
+		}
+		condition, err := c.fieldEquals(field, operand)
+		if err != nil {
+			return "", err
+		}
+		// Missing fields are "not equal"
+		return "NOT " + condition, nil
+
+	case "$gt", "$gte", "$lt", "$lte":
+		arg, err := scalarArg(field.name, op, operand)
+		if err != nil {
+			return "", err
+		}
+		sqlOp := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+		return c.anyElement(field, "je.value "+sqlOp+" ?", arg), nil
+
+	case "$in", "$nin":
+		list, ok := operand.([]interface{})
+		if !ok {
+			return "", filterErrorf(field.name, "%s expects an array", op)
+		}
+		if len(list) == 0 {
+			if op == "$in" {
+				return "0", nil
+			}
+			return "1", nil
+		}
+		placeholders := make([]string, len(list))
+		args := make([]interface{}, len(list))
+		for i, item := range list {
+			arg, err := scalarArg(field.name, op, item)
+			if err != nil {
+				return "", err
+			}
+			placeholders[i] = "?"
+			args[i] = arg
+		}
+		condition := c.anyElement(field, "je.value IN ("+strings.Join(placeholders, ", ")+")", args...)
+		if op == "$nin" {
+			return "NOT " + condition, nil
+		}
+		return condition, nil
+
+	case "$exists":
+		exists, ok := operand.(bool)
+		if !ok {
+			return "", filterErrorf(field.name, "$exists expects true or false")
+		}
+		if exists {
+			return c.jsonType(field) + " IS NOT NULL", nil
+		}
+		return c.jsonType(field) + " IS NULL", nil
+
+	case "$contains":
+		arg, err := scalarArg(field.name, op, operand)
+		if err != nil {
+			return "", err
+		}
+		// Array fields: element membership, string fields: substring match
+		p := c.prefix
+		c.args = append(c.args, field.path, field.path, arg, field.path, arg)
+		return fmt.Sprintf("(CASE json_type(%smetadata, ?) WHEN 'array' THEN EXISTS (SELECT 1 FROM json_each(%smetadata, ?) AS je WHERE je.value = ?) ELSE instr(json_extract(%smetadata, ?), ?) > 0 END)", p, p, p), nil
+	}
+
+	return "", filterErrorf(field.name, "unknown operator %s", op)
+}
+
+// fieldEquals matches a field equal to value, or an array field containing it
+func (c *filterCompiler) fieldEquals(field filterField, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return fmt.Sprintf("COALESCE(%s, 'null') = 'null'", c.jsonType(field)), nil
+	case []interface{}, map[string]interface{}, []string:
+		// Compare structured values by their canonical JSON text
+		jsonValue, err := json.Marshal(v)
+		if err != nil {
+			return "", filterErrorf(field.name, "cannot encode value")
+		}
+		condition := c.jsonExtract(field) + " = json(?)"
+		c.args = append(c.args, string(jsonValue))
+		return condition, nil
+	}
+
+	arg, err := scalarArg(field.name, "$eq", value)
+	if err != nil {
+		return "", err
+	}
+	return c.anyElement(field, "je.value = ?", arg), nil
+}
+
+// anyElement matches when the field, or any element of an array field, satisfies condition
+// json_each yields a single row for scalar values, so this covers both cases
+func (c *filterCompiler) anyElement(field filterField, condition string, args ...interface{}) string {
+	c.args = append(c.args, field.path)
+	c.args = append(c.args, args...)
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%smetadata, ?) AS je WHERE %s)", c.prefix, condition)
+}
+
+// jsonExtract and jsonType bind the field path as the next query argument,
+// so the returned SQL must be placed before any placeholder added later
+func (c *filterCompiler) jsonExtract(field filterField) string {
+	c.args = append(c.args, field.path)
+	return fmt.Sprintf("json_extract(%smetadata, ?)", c.prefix)
+}
+
+func (c *filterCompiler) jsonType(field filterField) string {
+	c.args = append(c.args, field.path)
+	return fmt.Sprintf("json_type(%smetadata, ?)", c.prefix)
+}
+
+// parseFieldPath validates a filter field and converts it to a SQLite JSON path
+// Accepted forms:
+//   - author.name         dotted object keys (letters, digits, '_' and '-')
+//   - items[0].sku        array indices
+//   - "first.name"        double-quoted keys for any other characters
+//
+// Every key is emitted quoted, e.g. author.name becomes $."author"."name"
+func parseFieldPath(field string) (string, error) {
+	if field == "" {
+		return "", errors.New("field name is empty")
+	}
+
+	var path strings.Builder
+	path.WriteString("$")
+
+	i := 0
+	for {
+		// Object key, either quoted or bare
+		var key string
+		if field[i] == '"' {
+			end := strings.IndexByte(field[i+1:], '"')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote at offset %d", i)
+			}
+			key = field[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(field) && isPathKeyChar(field[i:]) {
+				_, size := utf8.DecodeRuneInString(field[i:])
+				i += size
+			}
+			key = field[start:i]
+		}
+		if key == "" {
+			return "", fmt.Errorf("empty key at offset %d", i)
+		}
+		path.WriteString(`."`)
+		path.WriteString(key)
+		path.WriteString(`"`)
+
+		// Any number of array indices
+		for i < len(field) && field[i] == '[' {
+			end := strings.IndexByte(field[i:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated index at offset %d", i)
+			}
+			index := field[i+1 : i+end]
+			if _, err := strconv.ParseUint(index, 10, 32); err != nil {
+				return "", fmt.Errorf("array index %q is not a non-negative integer", index)
+			}
+			path.WriteString("[" + index + "]")
+			i += end + 1
+		}
+
+		if i == len(field) {
+			return path.String(), nil
+		}
+		if field[i] != '.' {
+			return "", fmt.Errorf("unexpected character %q at offset %d", field[i], i)
+		}
+		i++
+		if i == len(field) {
+			return "", errors.New("field name ends with '.'")
+		}
+	}
+}
+
+// isPathKeyChar reports whether s starts with a character allowed in a bare key
+func isPathKeyChar(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// scalarArg converts a filter operand to a SQL argument comparable with json_extract output
+func scalarArg(field, op string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, float64, float32, int, int64, int32:
+		return v, nil
+	case bool:
+		// json_extract returns booleans as 0 or 1
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return nil, filterErrorf(field, "%s expects a string, number or boolean", op)
+}
+
+// operatorObject reports whether value is an object of $-operators
+func operatorObject(value interface{}) (map[string]interface{}, bool) {
+	obj, ok := value.(map[string]interface{})
+	if !ok || len(obj) == 0 {
+		return nil, false
+	}
+	for key := range obj {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+// filterDocuments validates the operand of $and / $or
+func filterDocuments(op string, value interface{}) ([]map[string]interface{}, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []map[string]interface{}:
+		docs := make([]map[string]interface{}, len(v))
+		copy(docs, v)
+		return docs, nil
+	default:
+		return nil, filterErrorf(op, "expects an array of objects")
+	}
+
+	docs := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		doc, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, filterErrorf(op, "expects an array of objects")
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// stringList accepts a string or a list of strings
+func stringList(key string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, filterErrorf(key, "expects strings")
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	}
+	return nil, filterErrorf(key, "expects a string or an array of strings")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinConditions(conditions []string, sep string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return "(" + strings.Join(conditions, sep) + ")"
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/gorilla/mux"
 )
 
 func setupTestStore(t *testing.T) (*DocumentStore, string) {
@@ -547,6 +555,302 @@ func TestMetadataFiltering(t *testing.T) {
 	}
 }
 
+func TestFilterOperators(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "documents"
+
+	docs := []*Document{
+		{
+			ID:      "doc1",
+			Content: "Report on solar energy",
+			Tags:    []string{"energy", "solar"},
+			Vector:  []float32{1, 0, 0},
+			Metadata: map[string]interface{}{
+				"year":   2022,
+				"rating": 4.5,
+				"topics": []interface{}{"solar", "climate"},
+				"author": map[string]interface{}{"name": "Alice", "country": "NL"},
+				"draft":  false,
+			},
+		},
+		{
+			ID:      "doc2",
+			Content: "Report on wind energy",
+			Tags:    []string{"energy", "wind"},
+			Vector:  []float32{0.9, 0.1, 0},
+			Metadata: map[string]interface{}{
+				"year":   2023,
+				"rating": 3.0,
+				"topics": []interface{}{"wind", "climate"},
+				"author": map[string]interface{}{"name": "Bob", "country": "DK"},
+			},
+		},
+		{
+			ID:      "doc3",
+			Content: "Report on nuclear energy",
+			Tags:    []string{"energy", "nuclear"},
+			Vector:  []float32{0.8, 0.2, 0},
+			Metadata: map[string]interface{}{
+				"year":   2024,
+				"rating": 4.0,
+				"topics": []interface{}{"nuclear"},
+				"author": map[string]interface{}{"name": "Alice Smith", "country": "FR"},
+				"draft":  true,
+			},
+		},
+	}
+
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filters string // JSON, decoded the same way as API requests
+		wantIDs []string
+	}{
+		{"Greater than", `{"year": {"$gt": 2022}}`, []string{"doc2", "doc3"}},
+		{"Range", `{"year": {"$gte": 2022, "$lt": 2024}}`, []string{"doc1", "doc2"}},
+		{"Less than or equal on float", `{"rating": {"$lte": 4.0}}`, []string{"doc2", "doc3"}},
+		{"Not equal includes missing", `{"draft": {"$ne": true}}`, []string{"doc1", "doc2"}},
+		{"In", `{"year": {"$in": [2022, 2024]}}`, []string{"doc1", "doc3"}},
+		{"Not in", `{"year": {"$nin": [2022, 2024]}}`, []string{"doc2"}},
+		{"Exists", `{"draft": {"$exists": true}}`, []string{"doc1", "doc3"}},
+		{"Not exists", `{"draft": {"$exists": false}}`, []string{"doc2"}},
+		{"Dotted path", `{"author.country": "DK"}`, []string{"doc2"}},
+		{"Contains substring", `{"author.name": {"$contains": "Alice"}}`, []string{"doc1", "doc3"}},
+		{"Contains array element", `{"topics": {"$contains": "climate"}}`, []string{"doc1", "doc2"}},
+		{"Array membership equality", `{"topics": "wind"}`, []string{"doc2"}},
+		{"Array membership in", `{"topics": {"$in": ["solar", "nuclear"]}}`, []string{"doc1", "doc3"}},
+		{"Or", `{"$or": [{"year": 2022}, {"author.country": "FR"}]}`, []string{"doc1", "doc3"}},
+		{"And", `{"$and": [{"topics": "climate"}, {"rating": {"$gt": 4}}]}`, []string{"doc1"}},
+		{"Not", `{"$not": {"topics": "climate"}}`, []string{"doc3"}},
+		{"Nested boolean", `{"$or": [{"$and": [{"year": 2023}, {"tag": "wind"}]}, {"draft": true}]}`, []string{"doc2", "doc3"}},
+		{"Tags in", `{"tags": {"$in": ["wind", "nuclear"]}}`, []string{"doc2", "doc3"}},
+		{"Tags not in", `{"tags": {"$nin": ["wind"]}}`, []string{"doc1", "doc3"}},
+		{"Tags all", `{"tags": {"$all": ["energy", "solar"]}}`, []string{"doc1"}},
+	}
+
+	for _, tt := range tests {
+		var filters map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.filters), &filters); err != nil {
+			t.Fatalf("%s: bad test filter: %v", tt.name, err)
+		}
+
+		check := func(t *testing.T, results []SearchResult) {
+			t.Helper()
+			gotIDs := make([]string, len(results))
+			for i, r := range results {
+				gotIDs[i] = r.Document.ID
+			}
+			sort.Strings(gotIDs)
+
+			if strings.Join(gotIDs, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("got IDs %v, want %v", gotIDs, tt.wantIDs)
+			}
+		}
+
+		t.Run(tt.name+"/fulltext", func(t *testing.T) {
+			results, err := store.SearchFullText(dbName, tableName, "energy", 10, filters)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			check(t, results)
+		})
+
+		t.Run(tt.name+"/vector", func(t *testing.T) {
+			results, err := store.SearchVector(dbName, tableName, []float32{1, 0, 0}, 10, filters, VectorSearchOptions{})
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			check(t, results)
+		})
+	}
+
+	t.Run("Limit applies after filtering", func(t *testing.T) {
+		filters := map[string]interface{}{"year": map[string]interface{}{"$gte": 2023}}
+		results, err := store.SearchVector(dbName, tableName, []float32{1, 0, 0}, 1, filters, VectorSearchOptions{})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Document.ID != "doc2" {
+			t.Errorf("got %v, want [doc2]", results)
+		}
+	})
+
+	invalid := []string{
+		`{"year": {"$regex": "20.*"}}`,
+		`{"$or": {"year": 2022}}`,
+		`{"year": {"$in": 2022}}`,
+		`{"year": {"$gt": [1, 2]}}`,
+		`{"draft": {"$exists": "yes"}}`,
+		`{"$unknown": []}`,
+	}
+	for _, raw := range invalid {
+		t.Run("Invalid "+raw, func(t *testing.T) {
+			var filters map[string]interface{}
+			if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+				t.Fatalf("bad test filter: %v", err)
+			}
+			_, err := store.SearchFullText(dbName, tableName, "energy", 10, filters)
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("got error %v, want ErrInvalidFilter", err)
+			}
+		})
+	}
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{field: "author", want: `$."author"`},
+		{field: "author.name", want: `$."author"."name"`},
+		{field: "release-year_2", want: `$."release-year_2"`},
+		{field: "items[0].sku", want: `$."items"[0]."sku"`},
+		{field: "matrix[1][2]", want: `$."matrix"[1][2]`},
+		{field: `"first.name"`, want: `$."first.name"`},
+		{field: `meta."it's here".x`, want: `$."meta"."it's here"."x"`},
+		{field: "auteur.prénom", want: `$."auteur"."prénom"`},
+		{field: "", wantErr: true},
+		{field: "a..b", wantErr: true},
+		{field: "a.", wantErr: true},
+		{field: ".a", wantErr: true},
+		{field: "a[", wantErr: true},
+		{field: "a[x]", wantErr: true},
+		{field: "a[-1]", wantErr: true},
+		{field: `a"b`, wantErr: true},
+		{field: `"unterminated`, wantErr: true},
+		{field: `""`, wantErr: true},
+		{field: "a b", wantErr: true},
+		{field: "x') OR 1=1 --", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseFieldPath(tt.field)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseFieldPath(%q) = %q, want error", tt.field, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFieldPath(%q) failed: %v", tt.field, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseFieldPath(%q) = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPaths(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "documents"
+
+	docs := []*Document{
+		{
+			ID:      "doc1",
+			Content: "Order with widgets",
+			Metadata: map[string]interface{}{
+				"items":      []interface{}{map[string]interface{}{"sku": "W-1"}, map[string]interface{}{"sku": "W-2"}},
+				"first.name": "Alice",
+			},
+		},
+		{
+			ID:      "doc2",
+			Content: "Order with gadgets",
+			Metadata: map[string]interface{}{
+				"items":      []interface{}{map[string]interface{}{"sku": "G-1"}},
+				"first.name": "Bob",
+			},
+		},
+	}
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filters map[string]interface{}
+		wantIDs []string
+	}{
+		{"Array index", map[string]interface{}{"items[1].sku": "W-2"}, []string{"doc1"}},
+		{"Array index out of range", map[string]interface{}{"items[1].sku": "G-1"}, nil},
+		{"Quoted key with dot", map[string]interface{}{`"first.name"`: "Bob"}, []string{"doc2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.SearchFullText(dbName, tableName, "order", 10, tt.filters)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var gotIDs []string
+			for _, r := range results {
+				gotIDs = append(gotIDs, r.Document.ID)
+			}
+			sort.Strings(gotIDs)
+			if strings.Join(gotIDs, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("got IDs %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("Injection attempt is rejected", func(t *testing.T) {
+		key := "x') OR 1=1 --"
+		filters := map[string]interface{}{key: "anything"}
+
+		_, err := store.SearchFullText(dbName, tableName, "order", 10, filters)
+		var filterErr *FilterError
+		if !errors.As(err, &filterErr) {
+			t.Fatalf("got error %v, want *FilterError", err)
+		}
+		if filterErr.Key != key {
+			t.Errorf("FilterError.Key = %q, want %q", filterErr.Key, key)
+		}
+
+		_, err = store.SearchVector(dbName, tableName, []float32{1, 0, 0}, 10, filters, VectorSearchOptions{Exact: true})
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("vector search got error %v, want ErrInvalidFilter", err)
+		}
+	})
+
+	t.Run("API reports the bad key", func(t *testing.T) {
+		api := NewAPI(store, nil, &Config{})
+		body := `{"query": "order", "filters": {"$or": [{"a[": 1}]}}`
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/documents/search", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+
+		api.SearchDocuments(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid error response: %v", err)
+		}
+		if resp.Field != "a[" {
+			t.Errorf("field = %q, want %q", resp.Field, "a[")
+		}
+	})
+}
+
 func BenchmarkFilterByTags(b *testing.B) {
 	tmpDir, _ := os.MkdirTemp("", "llmdb-bench-*")
 	defer os.RemoveAll(tmpDir)
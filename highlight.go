@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultSnippetTokens  = 16
+	maxSnippetTokens      = 64 // Limit of the FTS5 snippet() function
+	maxHighlightSentences = 64 // Sentences compared per document for vector results
+)
+
+// normalizeHighlightOptions fills in defaults for unset highlight options
+func normalizeHighlightOptions(opts HighlightOptions) HighlightOptions {
+	if opts.PreTag == "" {
+		opts.PreTag = "<mark>"
+	}
+	if opts.PostTag == "" {
+		opts.PostTag = "</mark>"
+	}
+	if opts.Ellipsis == "" {
+		opts.Ellipsis = "…"
+	}
+	if opts.SnippetTokens == 0 {
+		opts.SnippetTokens = defaultSnippetTokens
+	}
+	return opts
+}
+
+// HighlightFullText fills in Highlight and Snippet of the results whose
+// content matches a full-text query, using the FTS5 highlight() and
+// snippet() functions. Other results are left untouched.
+func (s *DocumentStore) HighlightFullText(dbId, tableName, query string, results []SearchResult, opts HighlightOptions) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Document.ID
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	// Column 2 of the FTS table is the content
+	sqlQuery := fmt.Sprintf(`
+		SELECT d.id, highlight("%s_fts", 2, ?, ?), snippet("%s_fts", 2, ?, ?, ?, ?)
+		FROM "%s_fts"
+		JOIN "%s" d ON "%s_fts".rowid = d.rowid
+		WHERE "%s_fts" MATCH ? AND d.id IN (SELECT value FROM json_each(?))
+	`, tableName, tableName, tableName, tableName, tableName, tableName)
+
+	rows, err := db.Query(sqlQuery, opts.PreTag, opts.PostTag,
+		opts.PreTag, opts.PostTag, opts.Ellipsis, opts.SnippetTokens, query, string(idsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to highlight results: %w", err)
+	}
+	defer rows.Close()
+
+	type marked struct{ highlight, snippet string }
+	matches := make(map[string]marked)
+	for rows.Next() {
+		var id string
+		var m marked
+		if err := rows.Scan(&id, &m.highlight, &m.snippet); err != nil {
+			return err
+		}
+		matches[id] = m
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range results {
+		if m, ok := matches[results[i].Document.ID]; ok {
+			results[i].Highlight = m.highlight
+			results[i].Snippet = m.snippet
+		}
+	}
+	return nil
+}
+
+// sentencePattern matches a sentence: text up to closing punctuation, a
+// blank line or the end
+var sentencePattern = regexp.MustCompile(`[^.!?\n]+(?:[.!?]+|\n|$)`)
+
+// sentenceSpans returns the byte ranges of the sentences in text
+func sentenceSpans(text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range sentencePattern.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		for start < end && strings.ContainsRune(" \t\r\n", rune(text[start])) {
+			start++
+		}
+		for end > start && strings.ContainsRune(" \t\r\n", rune(text[end-1])) {
+			end--
+		}
+		if start < end {
+			spans = append(spans, [2]int{start, end})
+		}
+	}
+	return spans
+}
+
+// markPassage sets Highlight to content with the passage at span wrapped in
+// the highlight tags, and Snippet to the passage
+func markPassage(result *SearchResult, span [2]int, opts HighlightOptions) {
+	content := result.Document.Content
+	passage := content[span[0]:span[1]]
+
+	result.Highlight = content[:span[0]] + opts.PreTag + passage + opts.PostTag + content[span[1]:]
+	result.Snippet = passage
+	if span[0] > 0 {
+		result.Snippet = opts.Ellipsis + result.Snippet
+	}
+	if span[1] < len(content) {
+		result.Snippet += opts.Ellipsis
+	}
+}
+
+// highlightSemantic marks the passage closest to queryVector in results
+// without a highlight: the best chunk of collapsed results, otherwise the
+// content sentence whose embedding scores best under metric
+func highlightSemantic(ctx context.Context, embedder Embedder, metric string, queryVector []float32, results []SearchResult, opts HighlightOptions) error {
+	type candidate struct {
+		result int
+		span   [2]int
+	}
+	var candidates []candidate
+	var texts []string
+
+	for i := range results {
+		result := &results[i]
+		if result.Highlight != "" {
+			continue
+		}
+		content := result.Document.Content
+
+		if result.BestChunk != nil {
+			if start := strings.Index(content, result.BestChunk.Content); start >= 0 {
+				markPassage(result, [2]int{start, start + len(result.BestChunk.Content)}, opts)
+			} else {
+				result.Snippet = result.BestChunk.Content
+			}
+			continue
+		}
+
+		spans := sentenceSpans(content)
+		if len(spans) > maxHighlightSentences {
+			spans = spans[:maxHighlightSentences]
+		}
+		if len(spans) == 1 {
+			markPassage(result, spans[0], opts)
+			continue
+		}
+		for _, span := range spans {
+			candidates = append(candidates, candidate{result: i, span: span})
+			texts = append(texts, content[span[0]:span[1]])
+		}
+	}
+
+	if len(texts) == 0 {
+		return nil
+	}
+
+	vectors, err := embedder.EmbedBatch(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed sentences: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+
+	best := make(map[int]int) // Result index to its best candidate
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		scores[i] = vectorScore(metric, queryVector, vectors[i])
+		if b, ok := best[c.result]; !ok || scores[i] > scores[b] {
+			best[c.result] = i
+		}
+	}
+	for resultIndex, candidateIndex := range best {
+		markPassage(&results[resultIndex], candidates[candidateIndex].span, opts)
+	}
+
+	return nil
+}
+
+// highlightResults fills in Highlight and Snippet of search results. Full-text
+// matches are marked by FTS5; for vector matches the best chunk or sentence
+// is marked instead.
+func (a *API) highlightResults(ctx context.Context, dbName, tableName string, req *SearchRequest, queryVector []float32, results []SearchResult) error {
+	opts := normalizeHighlightOptions(*req.Highlight)
+
+	if req.Type != SearchTypeVector {
+		if err := a.store.HighlightFullText(dbName, tableName, req.Query, results, opts); err != nil {
+			return err
+		}
+	}
+	if len(queryVector) == 0 {
+		return nil
+	}
+
+	settings, err := a.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		return err
+	}
+	embedder, err := a.embedders.Get(settings.Embedder)
+	if err != nil {
+		return err
+	}
+	return highlightSemantic(ctx, embedder, settings.Metric, queryVector, results, opts)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// keywordEmbedder maps texts containing keyword to one axis and everything else to another
+type keywordEmbedder struct {
+	keyword string
+}
+
+func (e *keywordEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if strings.Contains(strings.ToLower(text), e.keyword) {
+		return []float32{1, 0}, nil
+	}
+	return []float32{0, 1}, nil
+}
+
+func (e *keywordEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i], _ = e.Embed(ctx, text)
+	}
+	return vectors, nil
+}
+
+func (e *keywordEmbedder) Dimensions() int {
+	return 2
+}
+
+func TestSentenceSpans(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"One. Two!  Three?", []string{"One.", "Two!", "Three?"}},
+		{"# Title\nBody text without a stop", []string{"# Title", "Body text without a stop"}},
+		{"Wait... what?!\n\n", []string{"Wait...", "what?!"}},
+		{"   ", nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, span := range sentenceSpans(tt.text) {
+			got = append(got, tt.text[span[0]:span[1]])
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("sentenceSpans(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchHighlighting(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "pets"
+	api := NewAPI(store, &keywordEmbedder{keyword: "cat"}, &Config{Features: map[string]bool{"embedding": true}})
+
+	for _, content := range []string{
+		"Dogs bark at the mailman. The cat sleeps all day. Birds sing in the morning.",
+		"Goldfish need clean water and a quiet room.",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/pets", strings.NewReader(`{"content": "`+content+`"}`))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.StoreDocument(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	search := func(t *testing.T, body string) (*httptest.ResponseRecorder, SearchResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/pets/search", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.SearchDocuments(rec, req)
+
+		var resp SearchResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	t.Run("Full-text matches are highlighted", func(t *testing.T) {
+		_, resp := search(t, `{"query": "mailman", "type": "fulltext", "highlight": {}}`)
+		if len(resp.Results) != 1 {
+			t.Fatalf("got %d results, want 1", len(resp.Results))
+		}
+		result := resp.Results[0]
+		if !strings.Contains(result.Highlight, "the <mark>mailman</mark>. The cat") {
+			t.Errorf("highlight = %q", result.Highlight)
+		}
+		if !strings.HasPrefix(result.Snippet, "Dogs bark at the <mark>mailman</mark>.") {
+			t.Errorf("snippet = %q", result.Snippet)
+		}
+	})
+
+	t.Run("Markers and snippet length are configurable", func(t *testing.T) {
+		_, resp := search(t, `{"query": "water", "highlight": {"pre_tag": "[", "post_tag": "]", "ellipsis": "...", "snippet_tokens": 3}}`)
+		if len(resp.Results) != 1 {
+			t.Fatalf("got %d results, want 1", len(resp.Results))
+		}
+		if got := resp.Results[0].Snippet; got != "...clean [water] and..." {
+			t.Errorf("snippet = %q", got)
+		}
+
+		rec, _ := search(t, `{"query": "water", "highlight": {"snippet_tokens": 65}}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("snippet_tokens 65: status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("Vector results mark the best sentence", func(t *testing.T) {
+		_, resp := search(t, `{"query": "cat", "type": "vector", "limit": 1, "highlight": {}}`)
+		if len(resp.Results) != 1 {
+			t.Fatalf("got %d results, want 1", len(resp.Results))
+		}
+		result := resp.Results[0]
+		if result.Snippet != "…The cat sleeps all day.…" {
+			t.Errorf("snippet = %q", result.Snippet)
+		}
+		if !strings.Contains(result.Highlight, "mailman. <mark>The cat sleeps all day.</mark> Birds") {
+			t.Errorf("highlight = %q", result.Highlight)
+		}
+	})
+
+	t.Run("Results are not highlighted by default", func(t *testing.T) {
+		_, resp := search(t, `{"query": "mailman"}`)
+		if len(resp.Results) != 1 || resp.Results[0].Highlight != "" || resp.Results[0].Snippet != "" {
+			t.Errorf("results = %+v", resp.Results)
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// documentHistorySchema keeps the superseded versions of documents in tables
+// with history enabled. Rows are written by triggers on the document table,
+// so all write paths (single, bulk, import, delete) are recorded. Versions
+// are numbered per document in the order they were superseded, starting at
+// 1; the stored document is the version after the last archived one.
+// archived_at is in unix seconds, like the embedding job timestamps.
+const documentHistorySchema = `
+	CREATE TABLE IF NOT EXISTS _document_history (
+		table_name TEXT NOT NULL,
+		doc_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		metadata TEXT,
+		tags TEXT,
+		vector BLOB,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		archived_at INTEGER NOT NULL,
+		deleted INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (table_name, doc_id, version)
+	);
+`
+
+// initDocumentHistory creates the history table
+func initDocumentHistory(db *sql.DB) error {
+	if _, err := db.Exec(documentHistorySchema); err != nil {
+		return fmt.Errorf("failed to create document history table: %w", err)
+	}
+	return nil
+}
+
+// setHistoryTriggers attaches the triggers archiving a table's documents,
+// or removes them when history is disabled. Archived versions are kept
+// either way. Chunks are not archived since their parent holds the full
+// content, and the embedding worker filling in a vector is not a new version.
+func setHistoryTriggers(db sqlExecer, tableName string, enabled bool) error {
+	for _, suffix := range []string{"au", "ad"} {
+		if _, err := db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_history_%s"`, tableName, suffix)); err != nil {
+			return fmt.Errorf("failed to drop history trigger for %s: %w", tableName, err)
+		}
+	}
+	if !enabled {
+		return nil
+	}
+
+	archive := func(deleted int) string {
+		return fmt.Sprintf(`
+			INSERT INTO _document_history (table_name, doc_id, version, content, metadata, tags, vector, created_at, updated_at, archived_at, deleted)
+			SELECT '%s', old.id, COALESCE(MAX(version), 0) + 1, old.content, old.metadata, old.tags, old.vector,
+			       old.created_at, old.updated_at, unixepoch(), %d
+			FROM _document_history WHERE table_name = '%s' AND doc_id = old.id;
+		`, tableName, deleted, tableName)
+	}
+
+	triggerAU := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS "%s_history_au" AFTER UPDATE ON "%s"
+		WHEN old.parent_id IS NULL AND (old.content IS NOT new.content OR old.metadata IS NOT new.metadata OR old.tags IS NOT new.tags) BEGIN
+			%s
+		END;
+	`, tableName, tableName, archive(0))
+
+	triggerAD := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS "%s_history_ad" AFTER DELETE ON "%s"
+		WHEN old.parent_id IS NULL BEGIN
+			%s
+		END;
+	`, tableName, tableName, archive(1))
+
+	for _, trigger := range []string{triggerAU, triggerAD} {
+		if _, err := db.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create history trigger for %s: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+// currentVersion returns the version number of the stored document, 0 when
+// it does not exist
+func currentVersion(db *sql.DB, tableName, id string) (int, error) {
+	var exists int
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE id = ?`, tableName)
+	if err := db.QueryRow(query, id).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var latest int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM _document_history WHERE table_name = ? AND doc_id = ?`,
+		tableName, id).Scan(&latest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read document history: %w", err)
+	}
+	return latest + 1, nil
+}
+
+// historyTable checks that a table exists before its history is read
+func historyTable(db *sql.DB, tableName string) error {
+	if !isValidTableName(tableName) {
+		return fmt.Errorf("invalid table name: %s", tableName)
+	}
+	exists, err := tableExists(db, tableName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("table not found: %s", tableName)
+	}
+	return nil
+}
+
+// GetDocumentHistory lists the archived versions of a document, newest first
+// Deleted documents keep their history.
+func (s *DocumentStore) GetDocumentHistory(dbId, tableName, id string) (*DocumentHistory, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := historyTable(db, tableName); err != nil {
+		return nil, err
+	}
+
+	history := &DocumentHistory{ID: id, Versions: []DocumentVersion{}}
+	if history.CurrentVersion, err = currentVersion(db, tableName, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT version, content, metadata, tags, vector IS NOT NULL, updated_at, archived_at, deleted
+		FROM _document_history
+		WHERE table_name = ? AND doc_id = ?
+		ORDER BY version DESC
+	`, tableName, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version DocumentVersion
+		var metadataJSON, tagsStr sql.NullString
+		var archivedAt int64
+		if err := rows.Scan(&version.Version, &version.Content, &metadataJSON, &tagsStr,
+			&version.IsEmbedded, &version.UpdatedAt, &archivedAt, &version.Deleted); err != nil {
+			return nil, err
+		}
+		version.ArchivedAt = time.Unix(archivedAt, 0).UTC()
+		if metadataJSON.String != "" {
+			if err := json.Unmarshal([]byte(metadataJSON.String), &version.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+			}
+		}
+		if tagsStr.String != "" {
+			version.Tags = strings.Split(tagsStr.String, ",")
+		}
+		history.Versions = append(history.Versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if history.CurrentVersion == 0 && len(history.Versions) == 0 {
+		return nil, fmt.Errorf("document not found")
+	}
+	history.Count = len(history.Versions)
+	return history, nil
+}
+
+// GetDocumentVersion returns a document as it was at the given version, the
+// stored document when version is the current one
+func (s *DocumentStore) GetDocumentVersion(dbId, tableName, id string, version int) (*Document, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := historyTable(db, tableName); err != nil {
+		return nil, err
+	}
+	current, err := currentVersion(db, tableName, id)
+	if err != nil {
+		return nil, err
+	}
+	if version == current {
+		doc, err := s.GetDocument(dbId, tableName, id)
+		if err != nil {
+			return nil, err
+		}
+		doc.Version = version
+		return doc, nil
+	}
+
+	doc := Document{ID: id, DB: dbId, Table: tableName, Version: version}
+	var metadataJSON, tagsStr sql.NullString
+	var vectorBytes []byte
+	err = db.QueryRow(`
+		SELECT content, metadata, tags, vector, created_at, updated_at
+		FROM _document_history
+		WHERE table_name = ? AND doc_id = ? AND version = ?
+	`, tableName, id, version).Scan(&doc.Content, &metadataJSON, &tagsStr, &vectorBytes, &doc.CreatedAt, &doc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("version %d of document %s not found", version, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document version: %w", err)
+	}
+
+	if metadataJSON.String != "" {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &doc.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+	if tagsStr.String != "" {
+		doc.Tags = strings.Split(tagsStr.String, ",")
+	}
+	if len(vectorBytes) > 0 {
+		doc.Vector = deserializeVector(vectorBytes)
+		doc.IsEmbedded = true
+	}
+
+	return &doc, nil
+}
+
+// parseVersion reads the version query parameter, 0 when absent
+func parseVersion(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("version")
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("version must be a positive integer")
+	}
+	return version, nil
+}
+
+// GetDocumentHistory lists the archived versions of a document
+// GET /db/{dbName}/{tableName}/{docId}/_history
+func (a *API) GetDocumentHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	history, err := a.store.GetDocumentHistory(vars["dbName"], vars["tableName"], vars["docId"])
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			a.errorResponse(w, http.StatusNotFound, err.Error())
+		} else if strings.Contains(err.Error(), "invalid table name") {
+			a.errorResponse(w, http.StatusBadRequest, err.Error())
+		} else {
+			a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, history)
+}
+
+// RestoreDocument makes an earlier version of a document current again
+// The version is stored like any write: the table's chunking applies, it is
+// embedded again, and the version it replaces is archived in turn, so a
+// restore can itself be undone. Deleted documents can be restored too.
+// POST /db/{dbName}/{tableName}/{docId}/_restore
+// Body: {"version": 3}
+func (a *API) RestoreDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+	docId := vars["docId"]
+
+	var req RestoreDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Version < 1 {
+		a.errorResponse(w, http.StatusBadRequest, "version must be a positive integer")
+		return
+	}
+
+	version, err := a.store.GetDocumentVersion(dbName, tableName, docId, req.Version)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			a.errorResponse(w, http.StatusNotFound, err.Error())
+		} else if strings.Contains(err.Error(), "invalid table name") {
+			a.errorResponse(w, http.StatusBadRequest, err.Error())
+		} else {
+			a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	storeReq := StoreDocumentRequest{
+		ID:       docId,
+		Content:  version.Content,
+		Metadata: version.Metadata,
+		Tags:     version.Tags,
+	}
+	doc, err := a.storeDocument(r.Context(), dbName, tableName, &storeReq, a.shouldEmbedSync(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrDimensionMismatch) || errors.Is(err, ErrChunkID) {
+			status = http.StatusBadRequest
+		}
+		a.errorResponse(w, status, err.Error())
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, doc)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDocumentHistory(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	config := &Config{Features: map[string]bool{}}
+	router := newRouter(NewAPI(store, &batchEmbedder{}, config), config)
+
+	do := func(t *testing.T, method, path, body string, wantStatus int) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if rec.Code != wantStatus {
+			t.Fatalf("%s %s: status = %d, want %d: %s", method, path, rec.Code, wantStatus, rec.Body.String())
+		}
+		return rec
+	}
+	history := func(t *testing.T, path string) DocumentHistory {
+		t.Helper()
+		var h DocumentHistory
+		json.NewDecoder(do(t, http.MethodGet, path+"/_history", "", http.StatusOK).Body).Decode(&h)
+		return h
+	}
+	get := func(t *testing.T, path string) Document {
+		t.Helper()
+		var doc Document
+		json.NewDecoder(do(t, http.MethodGet, path, "", http.StatusOK).Body).Decode(&doc)
+		return doc
+	}
+
+	do(t, http.MethodPut, "/db/kb/notes/_settings", `{"history": true}`, http.StatusOK)
+	do(t, http.MethodPost, "/db/kb/notes", `{"id": "n1", "content": "first draft", "metadata": {"status": "draft"}, "tags": ["a"]}`, http.StatusCreated)
+	do(t, http.MethodPost, "/db/kb/notes", `{"id": "n1", "content": "second draft"}`, http.StatusCreated)
+
+	t.Run("Only content changes create versions", func(t *testing.T) {
+		do(t, http.MethodPost, "/db/kb/notes", `{"id": "n1", "content": "second draft"}`, http.StatusCreated)
+		if err := store.UpdateDocumentVector("kb", "notes", "n1", []float32{1, 0, 0}); err != nil {
+			t.Fatalf("UpdateDocumentVector failed: %v", err)
+		}
+
+		h := history(t, "/db/kb/notes/n1")
+		if h.CurrentVersion != 2 || h.Count != 1 || h.Versions[0].Content != "first draft" {
+			t.Fatalf("history = %+v", h)
+		}
+		if h.Versions[0].Metadata["status"] != "draft" || h.Versions[0].ArchivedAt.IsZero() || h.Versions[0].Deleted {
+			t.Errorf("version = %+v", h.Versions[0])
+		}
+	})
+
+	t.Run("Versions can be read back", func(t *testing.T) {
+		do(t, http.MethodPost, "/db/kb/notes", `{"id": "n1", "content": "final"}`, http.StatusCreated)
+
+		h := history(t, "/db/kb/notes/n1")
+		if h.CurrentVersion != 3 || h.Count != 2 || h.Versions[0].Version != 2 || !h.Versions[0].IsEmbedded {
+			t.Fatalf("history = %+v", h)
+		}
+
+		if doc := get(t, "/db/kb/notes/n1?version=1"); doc.Content != "first draft" || doc.Version != 1 || doc.Tags[0] != "a" {
+			t.Errorf("version 1 = %+v", doc)
+		}
+		if doc := get(t, "/db/kb/notes/n1?version=2"); len(doc.Vector) != 3 || !doc.IsEmbedded {
+			t.Errorf("version 2 should keep its vector: %+v", doc)
+		}
+		if doc := get(t, "/db/kb/notes/n1?version=3"); doc.Content != "final" || doc.Version != 3 {
+			t.Errorf("current version = %+v", doc)
+		}
+		do(t, http.MethodGet, "/db/kb/notes/n1?version=9", "", http.StatusNotFound)
+		do(t, http.MethodGet, "/db/kb/notes/n1?version=0", "", http.StatusBadRequest)
+	})
+
+	t.Run("Restore archives the version it replaces", func(t *testing.T) {
+		rec := do(t, http.MethodPost, "/db/kb/notes/n1/_restore", `{"version": 1}`, http.StatusOK)
+		var doc Document
+		json.NewDecoder(rec.Body).Decode(&doc)
+		if doc.Content != "first draft" || doc.Metadata["status"] != "draft" {
+			t.Errorf("restored = %+v", doc)
+		}
+
+		h := history(t, "/db/kb/notes/n1")
+		if h.CurrentVersion != 4 || h.Versions[0].Content != "final" {
+			t.Errorf("history = %+v", h)
+		}
+
+		do(t, http.MethodPost, "/db/kb/notes/n1/_restore", `{"version": 7}`, http.StatusNotFound)
+		do(t, http.MethodPost, "/db/kb/notes/n1/_restore", `{}`, http.StatusBadRequest)
+	})
+
+	t.Run("Deleted documents keep their history", func(t *testing.T) {
+		do(t, http.MethodDelete, "/db/kb/notes/n1", "", http.StatusNoContent)
+
+		h := history(t, "/db/kb/notes/n1")
+		if h.CurrentVersion != 0 || h.Count != 4 || !h.Versions[0].Deleted {
+			t.Fatalf("history = %+v", h)
+		}
+
+		do(t, http.MethodPost, "/db/kb/notes/n1/_restore", `{"version": 4}`, http.StatusOK)
+		if doc := get(t, "/db/kb/notes/n1"); doc.Content != "first draft" {
+			t.Errorf("restored = %+v", doc)
+		}
+		do(t, http.MethodGet, "/db/kb/notes/never/_history", "", http.StatusNotFound)
+	})
+
+	t.Run("Chunks are not archived", func(t *testing.T) {
+		for _, content := range []string{"# One\nfirst\n\n# Two\nsecond", "# One\nfirst\n\n# Two\nchanged"} {
+			body := `{"id": "long", "chunking": {"strategy": "heading"}, "content": ` + jsonString(content) + `}`
+			do(t, http.MethodPost, "/db/kb/notes", body, http.StatusCreated)
+		}
+		if h := history(t, "/db/kb/notes/long"); h.CurrentVersion != 2 || h.Count != 1 {
+			t.Errorf("history = %+v", h)
+		}
+	})
+
+	t.Run("History is off by default and can be disabled", func(t *testing.T) {
+		do(t, http.MethodPost, "/db/kb/plain", `{"id": "p1", "content": "one"}`, http.StatusCreated)
+		do(t, http.MethodPost, "/db/kb/plain", `{"id": "p1", "content": "two"}`, http.StatusCreated)
+		if h := history(t, "/db/kb/plain/p1"); h.CurrentVersion != 1 || h.Count != 0 {
+			t.Errorf("plain history = %+v", h)
+		}
+
+		do(t, http.MethodPut, "/db/kb/notes/_settings", `{}`, http.StatusOK)
+		do(t, http.MethodPost, "/db/kb/notes", `{"id": "n1", "content": "unrecorded"}`, http.StatusCreated)
+		if h := history(t, "/db/kb/notes/n1"); h.Count != 4 || h.CurrentVersion != 5 {
+			t.Errorf("disabled history = %+v", h)
+		}
+		do(t, http.MethodGet, "/db/kb/missing/n1/_history", "", http.StatusNotFound)
+	})
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	hnswDefaultM              = 16
+	hnswDefaultEfConstruction = 200
+	hnswDefaultEfSearch       = 64
+	hnswFileVersion           = 1
+
+	// hnswMaxDeletedRatio is the share of tombstones above which the graph
+	// is rebuilt from its live vectors
+	hnswMaxDeletedRatio = 0.25
+)
+
+// hnswIndex is an in-memory Hierarchical Navigable Small World graph used for
+// approximate nearest-neighbour search over one table's vectors.
+// Deleted documents are tombstoned and skipped in results; once they make up
+// more than hnswMaxDeletedRatio of the graph it is rebuilt in the background
+// from the live vectors, so writes and searches are not blocked meanwhile.
+type hnswIndex struct {
+	mu sync.RWMutex
+
+	metric         string // "cosine", "euclidean", or "dot"
+	m              int    // Max links per node on upper layers (2*m on layer 0)
+	efConstruction int
+	levelMult      float64
+
+	nodes    []*hnswNode
+	ids      map[string]int // document id -> live node
+	entry    int            // entry point node, -1 when empty
+	maxLevel int
+	deleted  int
+
+	// fingerprint identifies the table state the index was built from
+	fingerprint string
+	dirty       bool
+	rng         *rand.Rand
+
+	compacted chan struct{} // Closed when the running rebuild was swapped in, nil when idle
+	changes   []hnswChange  // Writes made during the rebuild, replayed on the rebuilt graph
+}
+
+// hnswChange is a write to replay on a graph rebuilt in the background
+type hnswChange struct {
+	id     string
+	vector []float32 // nil for removals
+}
+
+// hnswNode is a single vector in the graph
+type hnswNode struct {
+	ID      string
+	Vector  []float32
+	Level   int
+	Links   [][]int
+	Deleted bool
+}
+
+// hnswHit is a search result from the index
+type hnswHit struct {
+	ID       string
+	Distance float64
+}
+
+// hnswSnapshot is the on-disk representation of an index
+type hnswSnapshot struct {
+	Version        int
+	Metric         string
+	M              int
+	EfConstruction int
+	Nodes          []*hnswNode
+	Entry          int
+	MaxLevel       int
+	Fingerprint    string
+}
+
+// newHNSWIndex creates an empty index for the given metric
+func newHNSWIndex(metric string) *hnswIndex {
+	return &hnswIndex{
+		metric:         metric,
+		m:              hnswDefaultM,
+		efConstruction: hnswDefaultEfConstruction,
+		levelMult:      1 / math.Log(float64(hnswDefaultM)),
+		ids:            make(map[string]int),
+		entry:          -1,
+		rng:            rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// Len returns the number of live vectors in the index
+func (h *hnswIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+// Add inserts or replaces the vector for a document
+func (h *hnswIndex) Add(id string, vector []float32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removeLocked(id)
+	h.addLocked(id, vector)
+	if h.compacted != nil {
+		h.changes = append(h.changes, hnswChange{id: id, vector: vector})
+	}
+	h.startCompactionLocked()
+}
+
+func (h *hnswIndex) addLocked(id string, vector []float32) {
+	vec := h.prepare(vector)
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	node := &hnswNode{
+		ID:     id,
+		Vector: vec,
+		Level:  level,
+		Links:  make([][]int, level+1),
+	}
+	nodeID := len(h.nodes)
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = nodeID
+	h.dirty = true
+
+	if h.entry < 0 {
+		h.entry = nodeID
+		h.maxLevel = level
+		return
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedyClosest(vec, ep, l)
+	}
+
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vec, []int{ep}, h.efConstruction, l)
+		neighbours := h.selectNeighbours(candidates, h.maxLinks(l))
+		node.Links[l] = neighbours
+
+		for _, n := range neighbours {
+			h.link(n, nodeID, l)
+		}
+
+		ep = candidates[0].node
+	}
+
+	if level > h.maxLevel {
+		h.entry = nodeID
+		h.maxLevel = level
+	}
+}
+
+// Remove tombstones the vector for a document
+func (h *hnswIndex) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(id)
+	if h.compacted != nil {
+		h.changes = append(h.changes, hnswChange{id: id})
+	}
+	h.startCompactionLocked()
+}
+
+func (h *hnswIndex) removeLocked(id string) {
+	nodeID, ok := h.ids[id]
+	if !ok {
+		return
+	}
+	h.nodes[nodeID].Deleted = true
+	delete(h.ids, id)
+	h.deleted++
+	h.dirty = true
+}
+
+// Search returns up to k live documents closest to the query vector
+func (h *hnswIndex) Search(query []float32, k, efSearch int) []hnswHit {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.entry < 0 || k <= 0 {
+		return nil
+	}
+	if efSearch < k {
+		efSearch = k
+	}
+
+	vec := h.prepare(query)
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedyClosest(vec, ep, l)
+	}
+
+	// Widen the beam by the share of tombstones, which are filtered out below
+	ef := efSearch
+	if h.deleted > 0 && len(h.ids) > 0 {
+		ef = (efSearch*len(h.nodes) + len(h.ids) - 1) / len(h.ids)
+	}
+	candidates := h.searchLayer(vec, []int{ep}, ef, 0)
+
+	hits := make([]hnswHit, 0, k)
+	for _, c := range candidates {
+		node := h.nodes[c.node]
+		if node.Deleted {
+			continue
+		}
+		hits = append(hits, hnswHit{ID: node.ID, Distance: c.dist})
+		if len(hits) == k {
+			break
+		}
+	}
+
+	return hits
+}
+
+// startCompactionLocked starts rebuilding the graph from its live vectors
+// once tombstones make up more than hnswMaxDeletedRatio of it, so searches do
+// not wade through them. Only one rebuild runs at a time.
+func (h *hnswIndex) startCompactionLocked() {
+	if h.compacted != nil || float64(h.deleted) <= hnswMaxDeletedRatio*float64(len(h.nodes)) {
+		return
+	}
+
+	// Vectors are never modified once added, so the rebuild can share them
+	live := make([]hnswChange, 0, len(h.ids))
+	for _, node := range h.nodes {
+		if !node.Deleted {
+			live = append(live, hnswChange{id: node.ID, vector: node.Vector})
+		}
+	}
+	h.compacted = make(chan struct{})
+	go h.compact(live)
+}
+
+// compact builds a graph of the live vectors without holding the lock, then
+// replays the writes made meanwhile and swaps it in
+func (h *hnswIndex) compact(live []hnswChange) {
+	rebuilt := newHNSWIndex(h.metric)
+	rebuilt.m = h.m
+	rebuilt.efConstruction = h.efConstruction
+	rebuilt.levelMult = h.levelMult
+	for _, node := range live {
+		// Vectors are already prepared for the metric
+		rebuilt.addLocked(node.id, node.vector)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, change := range h.changes {
+		rebuilt.removeLocked(change.id)
+		if change.vector != nil {
+			rebuilt.addLocked(change.id, change.vector)
+		}
+	}
+
+	h.nodes = rebuilt.nodes
+	h.ids = rebuilt.ids
+	h.entry = rebuilt.entry
+	h.maxLevel = rebuilt.maxLevel
+	h.deleted = rebuilt.deleted
+	h.dirty = true
+	h.changes = nil
+	close(h.compacted)
+	h.compacted = nil
+}
+
+// prepare copies a vector, normalizing it for cosine so distances reduce to a dot product
+func (h *hnswIndex) prepare(vector []float32) []float32 {
+	vec := make([]float32, len(vector))
+	copy(vec, vector)
+	if h.metric != "cosine" && h.metric != "" {
+		return vec
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vec
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return vec
+}
+
+// distance returns a value where lower means more similar
+func (h *hnswIndex) distance(a, b []float32) float64 {
+	switch h.metric {
+	case "euclidean":
+		return euclideanDistance(a, b)
+	case "dot":
+		return -dotProduct(a, b)
+	default:
+		// Vectors are normalized on insert, so this is 1 - cosine similarity
+		return 1 - dotProduct(a, b)
+	}
+}
+
+func (h *hnswIndex) maxLinks(level int) int {
+	if level == 0 {
+		return h.m * 2
+	}
+	return h.m
+}
+
+// greedyClosest walks a single layer towards the query, returning the closest node found
+func (h *hnswIndex) greedyClosest(query []float32, ep, level int) int {
+	best := ep
+	bestDist := h.distance(query, h.nodes[ep].Vector)
+
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.nodes[best].Links[level] {
+			if d := h.distance(query, h.nodes[n].Vector); d < bestDist {
+				best, bestDist = n, d
+				changed = true
+			}
+		}
+	}
+
+	return best
+}
+
+// searchLayer runs a beam search on one layer and returns up to ef nodes sorted by distance
+func (h *hnswIndex) searchLayer(query []float32, entryPoints []int, ef, level int) []hnswCandidate {
+	visited := make(map[int]struct{}, ef*4)
+	candidates := &candidateHeap{}
+	found := &candidateHeap{max: true}
+
+	for _, ep := range entryPoints {
+		c := hnswCandidate{node: ep, dist: h.distance(query, h.nodes[ep].Vector)}
+		visited[ep] = struct{}{}
+		heap.Push(candidates, c)
+		heap.Push(found, c)
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if found.Len() >= ef && current.dist > found.items[0].dist {
+			break
+		}
+
+		node := h.nodes[current.node]
+		if level >= len(node.Links) {
+			continue
+		}
+
+		for _, n := range node.Links[level] {
+			if _, seen := visited[n]; seen {
+				continue
+			}
+			visited[n] = struct{}{}
+
+			d := h.distance(query, h.nodes[n].Vector)
+			if found.Len() < ef || d < found.items[0].dist {
+				heap.Push(candidates, hnswCandidate{node: n, dist: d})
+				heap.Push(found, hnswCandidate{node: n, dist: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	result := found.items
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].dist < result[j].dist
+	})
+	return result
+}
+
+// selectNeighbours picks up to max links from candidates sorted by distance,
+// preferring nodes that are not already covered by a closer neighbour
+func (h *hnswIndex) selectNeighbours(candidates []hnswCandidate, max int) []int {
+	selected := make([]int, 0, max)
+	var pruned []int
+
+	for _, c := range candidates {
+		if len(selected) >= max {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if h.distance(h.nodes[c.node].Vector, h.nodes[s].Vector) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.node)
+		} else {
+			pruned = append(pruned, c.node)
+		}
+	}
+
+	// Fill remaining slots with the closest pruned candidates
+	for _, n := range pruned {
+		if len(selected) >= max {
+			break
+		}
+		selected = append(selected, n)
+	}
+
+	return selected
+}
+
+// link adds a backlink from node to target, shrinking the link list if it overflows
+func (h *hnswIndex) link(node, target, level int) {
+	n := h.nodes[node]
+	n.Links[level] = append(n.Links[level], target)
+
+	max := h.maxLinks(level)
+	if len(n.Links[level]) <= max {
+		return
+	}
+
+	candidates := make([]hnswCandidate, len(n.Links[level]))
+	for i, l := range n.Links[level] {
+		candidates[i] = hnswCandidate{node: l, dist: h.distance(n.Vector, h.nodes[l].Vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].dist < candidates[j].dist
+	})
+	n.Links[level] = h.selectNeighbours(candidates, max)
+}
+
+// Save writes the index to path, replacing any previous file atomically
+func (h *hnswIndex) Save(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create index file: %w", err)
+	}
+
+	snapshot := hnswSnapshot{
+		Version:        hnswFileVersion,
+		Metric:         h.metric,
+		M:              h.m,
+		EfConstruction: h.efConstruction,
+		Nodes:          h.nodes,
+		Entry:          h.entry,
+		MaxLevel:       h.maxLevel,
+		Fingerprint:    h.fingerprint,
+	}
+
+	if err := gob.NewEncoder(f).Encode(&snapshot); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace index file: %w", err)
+	}
+
+	h.dirty = false
+	return nil
+}
+
+// loadHNSWIndex reads an index previously written by Save
+func loadHNSWIndex(path string) (*hnswIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshot hnswSnapshot
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode index: %w", err)
+	}
+	if snapshot.Version != hnswFileVersion {
+		return nil, fmt.Errorf("unsupported index version %d", snapshot.Version)
+	}
+
+	h := newHNSWIndex(snapshot.Metric)
+	h.m = snapshot.M
+	h.efConstruction = snapshot.EfConstruction
+	h.levelMult = 1 / math.Log(float64(snapshot.M))
+	h.nodes = snapshot.Nodes
+	h.entry = snapshot.Entry
+	h.maxLevel = snapshot.MaxLevel
+	h.fingerprint = snapshot.Fingerprint
+
+	for i, node := range h.nodes {
+		if node.Deleted {
+			h.deleted++
+			continue
+		}
+		h.ids[node.ID] = i
+	}
+
+	return h, nil
+}
+
+// hnswCandidate is a node and its distance to the current query
+type hnswCandidate struct {
+	node int
+	dist float64
+}
+
+// candidateHeap is a min-heap on distance, or a max-heap when max is set
+type candidateHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+
+func (c *candidateHeap) Less(i, j int) bool {
+	if c.max {
+		return c.items[i].dist > c.items[j].dist
+	}
+	return c.items[i].dist < c.items[j].dist
+}
+
+func (c *candidateHeap) Swap(i, j int) { c.items[i], c.items[j] = c.items[j], c.items[i] }
+
+func (c *candidateHeap) Push(x interface{}) { c.items = append(c.items, x.(hnswCandidate)) }
+
+func (c *candidateHeap) Pop() interface{} {
+	old := c.items
+	n := len(old)
+	item := old[n-1]
+	c.items = old[:n-1]
+	return item
+}
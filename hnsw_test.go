@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func randomVectors(rng *rand.Rand, n, dims int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dims)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()*2 - 1
+		}
+	}
+	return vectors
+}
+
+func TestHNSWRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	vectors := randomVectors(rng, 2000, 32)
+
+	idx := newHNSWIndex("cosine")
+	for i, v := range vectors {
+		idx.Add(docIDForIndex(i), v)
+	}
+
+	const k = 10
+	queries := randomVectors(rng, 20, 32)
+	found := 0
+	for _, q := range queries {
+		// Exact top-k for comparison
+		type scored struct {
+			id    string
+			score float64
+		}
+		exact := make([]scored, len(vectors))
+		for i, v := range vectors {
+			exact[i] = scored{id: docIDForIndex(i), score: cosineSimilarity(q, v)}
+		}
+		sort.Slice(exact, func(i, j int) bool { return exact[i].score > exact[j].score })
+
+		want := make(map[string]bool)
+		for _, e := range exact[:k] {
+			want[e.id] = true
+		}
+
+		for _, hit := range idx.Search(q, k, 100) {
+			if want[hit.ID] {
+				found++
+			}
+		}
+	}
+
+	recall := float64(found) / float64(k*len(queries))
+	if recall < 0.9 {
+		t.Errorf("recall %.2f below 0.90", recall)
+	}
+}
+
+func TestHNSWCompaction(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	vectors := randomVectors(rng, 100, 8)
+
+	idx := newHNSWIndex("cosine")
+	for i, v := range vectors {
+		idx.Add(docIDForIndex(i), v)
+	}
+
+	// Replacing a vector tombstones the old node too
+	for i := 0; i < 30; i++ {
+		idx.Remove(docIDForIndex(i))
+	}
+	idx.Add(docIDForIndex(99), vectors[99])
+
+	// Writes made during the rebuild are carried over to the rebuilt graph
+	idx.Remove(docIDForIndex(30))
+	idx.Add(docIDForIndex(30), vectors[30])
+	idx.Remove(docIDForIndex(31))
+	idx.Add(docIDForIndex(0), vectors[0])
+	idx.waitForCompaction()
+
+	if float64(idx.deleted) > hnswMaxDeletedRatio*float64(len(idx.nodes)) {
+		t.Errorf("%d of %d nodes are tombstones", idx.deleted, len(idx.nodes))
+	}
+	if idx.Len() != 70 || len(idx.nodes) >= 100 {
+		t.Errorf("len = %d with %d nodes, want 70 live vectors after compaction", idx.Len(), len(idx.nodes))
+	}
+
+	for _, i := range []int{0, 30, 50} {
+		hits := idx.Search(vectors[i], 1, 50)
+		if len(hits) != 1 || hits[0].ID != docIDForIndex(i) {
+			t.Errorf("compacted index did not find exact match for %d: %v", i, hits)
+		}
+	}
+	if hits := idx.Search(vectors[31], 70, 100); slices.ContainsFunc(hits, func(h hnswHit) bool { return h.ID == docIDForIndex(31) }) {
+		t.Errorf("removed vector still found after compaction")
+	}
+}
+
+// waitForCompaction blocks until a running rebuild has been swapped in
+func (h *hnswIndex) waitForCompaction() {
+	h.mu.RLock()
+	done := h.compacted
+	h.mu.RUnlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestHNSWRemoveAndPersist(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 200, 8)
+
+	idx := newHNSWIndex("cosine")
+	for i, v := range vectors {
+		idx.Add(docIDForIndex(i), v)
+	}
+
+	idx.Remove(docIDForIndex(0))
+	for _, hit := range idx.Search(vectors[0], 5, 50) {
+		if hit.ID == docIDForIndex(0) {
+			t.Fatalf("removed document returned by search")
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "test.hnsw")
+	idx.fingerprint = "fp"
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := loadHNSWIndex(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Len() != idx.Len() {
+		t.Errorf("loaded %d vectors, want %d", loaded.Len(), idx.Len())
+	}
+	if loaded.fingerprint != "fp" {
+		t.Errorf("fingerprint not preserved: %q", loaded.fingerprint)
+	}
+
+	hits := loaded.Search(vectors[1], 1, 50)
+	if len(hits) != 1 || hits[0].ID != docIDForIndex(1) {
+		t.Errorf("loaded index did not find exact match: %v", hits)
+	}
+}
+
+func TestVectorIndexStaysInSync(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "documents"
+
+	docs := []*Document{
+		{ID: "doc1", Content: "first", Vector: []float32{1, 0, 0}},
+		{ID: "doc2", Content: "second", Vector: []float32{0, 1, 0}},
+		{ID: "doc3", Content: "third"},
+	}
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	search := func(opts VectorSearchOptions) []string {
+		t.Helper()
+		results, err := store.SearchVector(dbName, tableName, []float32{0, 0, 1}, 10, nil, opts)
+		if err != nil {
+			t.Fatalf("Vector search failed: %v", err)
+		}
+		ids := make([]string, len(results))
+		for i, r := range results {
+			ids[i] = r.Document.ID
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if err := store.UpdateDocumentVector(dbName, tableName, "doc3", []float32{0, 0, 1}); err != nil {
+		t.Fatalf("Failed to update vector: %v", err)
+	}
+	if err := store.DeleteDocument(dbName, tableName, "doc1"); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+
+	indexed := search(VectorSearchOptions{})
+	exact := search(VectorSearchOptions{Exact: true})
+	if len(indexed) != 2 || len(exact) != 2 || indexed[0] != exact[0] || indexed[1] != exact[1] {
+		t.Errorf("index results %v differ from exact results %v", indexed, exact)
+	}
+
+	// A saved index is reused, a missing one is rebuilt
+	if err := store.SaveVectorIndexes(); err != nil {
+		t.Fatalf("Failed to save indexes: %v", err)
+	}
+	reopened, err := NewDocumentStore(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+
+	idx, err := reopened.vectorIndex(dbName, tableName)
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if idx.dirty || idx.Len() != 2 {
+		t.Errorf("expected clean saved index with 2 vectors, got dirty=%v len=%d", idx.dirty, idx.Len())
+	}
+}
+
+func docIDForIndex(i int) string {
+	return fmt.Sprintf("doc-%d", i)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	defaultRRFConstant          = 60
+	defaultHybridCandidateRatio = 4
+)
+
+// SearchHybrid runs full-text and vector search against the same table and
+// merges both rankings with reciprocal rank fusion (RRF)
+func (s *DocumentStore) SearchHybrid(dbId, tableName, query string, queryVector []float32, limit int, opts HybridOptions, vectorOpts VectorSearchOptions, filters map[string]interface{}) ([]SearchResult, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	opts = normalizeHybridOptions(opts, limit)
+
+	// A side weighted 0 does not contribute, so it is not run
+	var textResults, vectorResults []SearchResult
+	var err error
+	if *opts.FullTextWeight > 0 {
+		textResults, err = s.SearchFullText(dbId, tableName, query, opts.Candidates, filters)
+		if err != nil {
+			return nil, fmt.Errorf("hybrid full-text leg failed: %w", err)
+		}
+	}
+
+	if *opts.VectorWeight > 0 {
+		vectorResults, err = s.SearchVector(dbId, tableName, queryVector, opts.Candidates, filters, vectorOpts)
+		if err != nil {
+			return nil, fmt.Errorf("hybrid vector leg failed: %w", err)
+		}
+	}
+
+	results := fuseReciprocalRank(opts.RRFConstant,
+		rankedList{results: textResults, weight: *opts.FullTextWeight},
+		rankedList{results: vectorResults, weight: *opts.VectorWeight},
+	)
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// normalizeHybridOptions fills in defaults for unset hybrid options
+func normalizeHybridOptions(opts HybridOptions, limit int) HybridOptions {
+	defaultWeight := 1.0
+	if opts.FullTextWeight == nil {
+		opts.FullTextWeight = &defaultWeight
+	}
+	if opts.VectorWeight == nil {
+		opts.VectorWeight = &defaultWeight
+	}
+	if opts.RRFConstant <= 0 {
+		opts.RRFConstant = defaultRRFConstant
+	}
+	if opts.Candidates < limit {
+		opts.Candidates = limit * defaultHybridCandidateRatio
+	}
+	return opts
+}
+
+// Validate checks hybrid options
+func (o *HybridOptions) Validate() error {
+	if (o.FullTextWeight != nil && *o.FullTextWeight < 0) || (o.VectorWeight != nil && *o.VectorWeight < 0) {
+		return fmt.Errorf("hybrid weights must not be negative")
+	}
+	if o.FullTextWeight != nil && o.VectorWeight != nil && *o.FullTextWeight == 0 && *o.VectorWeight == 0 {
+		return fmt.Errorf("at least one hybrid weight must be positive")
+	}
+	return nil
+}
+
+// rankedList is one input ranking for reciprocal rank fusion
+type rankedList struct {
+	results []SearchResult
+	weight  float64
+}
+
+// fuseReciprocalRank merges rankings by summing weight / (k + rank) for every
+// list a document appears in. Documents are told apart by database, table
+// and ID. Each input list must already be ordered best first. Ties keep the
+// order in which documents were first seen.
+func fuseReciprocalRank(k int, lists ...rankedList) []SearchResult {
+	scores := make(map[string]float64)
+	docs := make(map[string]SearchResult)
+	var order []string
+
+	for _, list := range lists {
+		for i, result := range list.results {
+			id := result.Document.DB + "/" + result.Document.Table + "/" + result.Document.ID
+			if _, seen := docs[id]; !seen {
+				docs[id] = result
+				order = append(order, id)
+			}
+			scores[id] += list.weight / float64(k+i+1)
+		}
+	}
+
+	fused := make([]SearchResult, 0, len(order))
+	for _, id := range order {
+		result := docs[id]
+		result.Score = scores[id]
+		fused = append(fused, result)
+	}
+
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].Score > fused[j].Score
+	})
+
+	for i := range fused {
+		fused[i].Rank = i + 1
+	}
+
+	return fused
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFuseReciprocalRank(t *testing.T) {
+	text := []SearchResult{
+		{Document: Document{ID: "a"}},
+		{Document: Document{ID: "b"}},
+		{Document: Document{ID: "c"}},
+	}
+	vector := []SearchResult{
+		{Document: Document{ID: "b"}},
+		{Document: Document{ID: "c"}},
+		{Document: Document{ID: "d"}},
+	}
+
+	tests := []struct {
+		name         string
+		textWeight   float64
+		vectorWeight float64
+		wantIDs      []string
+	}{
+		{
+			name:         "Equal weights favour documents found by both",
+			textWeight:   1,
+			vectorWeight: 1,
+			wantIDs:      []string{"b", "c", "a", "d"},
+		},
+		{
+			name:         "Heavy full-text weight keeps keyword order",
+			textWeight:   100,
+			vectorWeight: 1,
+			wantIDs:      []string{"a", "b", "c", "d"},
+		},
+		{
+			name:         "Heavy vector weight keeps vector order",
+			textWeight:   1,
+			vectorWeight: 100,
+			wantIDs:      []string{"b", "c", "d", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := fuseReciprocalRank(defaultRRFConstant,
+				rankedList{results: text, weight: tt.textWeight},
+				rankedList{results: vector, weight: tt.vectorWeight},
+			)
+
+			if len(results) != len(tt.wantIDs) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.wantIDs))
+			}
+
+			for i, id := range tt.wantIDs {
+				if results[i].Document.ID != id {
+					t.Errorf("position %d: got %s, want %s", i, results[i].Document.ID, id)
+				}
+				if results[i].Rank != i+1 {
+					t.Errorf("position %d: got rank %d, want %d", i, results[i].Rank, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchHybrid(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "documents"
+
+	docs := []*Document{
+		{ID: "doc1", Content: "Python web frameworks compared", Vector: []float32{1, 0, 0}, Tags: []string{"python"}},
+		{ID: "doc2", Content: "Python data science notebooks", Vector: []float32{0, 1, 0}, Tags: []string{"python"}},
+		{ID: "doc3", Content: "Rust systems programming", Vector: []float32{0.9, 0.1, 0}, Tags: []string{"rust"}},
+	}
+
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	t.Run("Merges both result sets", func(t *testing.T) {
+		results, err := store.SearchHybrid(dbName, tableName, "Python", []float32{1, 0, 0}, 10, HybridOptions{}, VectorSearchOptions{}, nil)
+		if err != nil {
+			t.Fatalf("Hybrid search failed: %v", err)
+		}
+
+		if len(results) != 3 {
+			t.Fatalf("got %d results, want 3", len(results))
+		}
+		// doc1 ranks high in both lists
+		if results[0].Document.ID != "doc1" {
+			t.Errorf("got top result %s, want doc1", results[0].Document.ID)
+		}
+	})
+
+	t.Run("Applies filters to both sides", func(t *testing.T) {
+		results, err := store.SearchHybrid(dbName, tableName, "Python", []float32{1, 0, 0}, 10, HybridOptions{}, VectorSearchOptions{},
+			map[string]interface{}{"tag": "python"})
+		if err != nil {
+			t.Fatalf("Hybrid search failed: %v", err)
+		}
+
+		for _, r := range results {
+			if r.Document.ID == "doc3" {
+				t.Errorf("filtered document doc3 returned")
+			}
+		}
+	})
+
+	t.Run("Respects limit", func(t *testing.T) {
+		results, err := store.SearchHybrid(dbName, tableName, "Python", []float32{1, 0, 0}, 1, HybridOptions{}, VectorSearchOptions{}, nil)
+		if err != nil {
+			t.Fatalf("Hybrid search failed: %v", err)
+		}
+
+		if len(results) != 1 {
+			t.Errorf("got %d results, want 1", len(results))
+		}
+	})
+
+	t.Run("Zero weight turns a side off", func(t *testing.T) {
+		zero := 0.0
+		results, err := store.SearchHybrid(dbName, tableName, "Python", []float32{1, 0, 0}, 10, HybridOptions{VectorWeight: &zero}, VectorSearchOptions{}, nil)
+		if err != nil {
+			t.Fatalf("Hybrid search failed: %v", err)
+		}
+
+		// Only the full-text matches remain
+		if len(results) != 2 {
+			t.Errorf("got %d results, want 2", len(results))
+		}
+		for _, r := range results {
+			if r.Document.ID == "doc3" {
+				t.Errorf("vector-only match doc3 returned")
+			}
+		}
+	})
+
+	t.Run("Rejects negative and all-zero weights", func(t *testing.T) {
+		negative, zero := -1.0, 0.0
+		for _, opts := range []HybridOptions{
+			{FullTextWeight: &negative},
+			{FullTextWeight: &zero, VectorWeight: &zero},
+		} {
+			if err := opts.Validate(); err == nil {
+				t.Errorf("Validate(%+v) should fail", opts)
+			}
+		}
+	})
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Embedding job states
+const (
+	JobStatusPending = "pending" // Waiting for its next attempt
+	JobStatusDead    = "dead"    // Gave up after maxEmbeddingAttempts, needs a manual retry
+)
+
+const (
+	maxEmbeddingAttempts = 5
+	embeddingRetryBase   = 30 * time.Second // Delay after the first failure, doubled per attempt
+	embeddingRetryMax    = time.Hour
+)
+
+// embeddingJobsSchema creates the per-database queue of documents waiting
+// for an embedding. Rows are maintained by triggers on every document table,
+// so all write paths (single, bulk, import) enqueue consistently.
+// Timestamps are unix seconds so triggers and Go code agree on the format.
+const embeddingJobsSchema = `
+	CREATE TABLE IF NOT EXISTS _embedding_jobs (
+		table_name TEXT NOT NULL,
+		doc_id TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (table_name, doc_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_embedding_jobs_due ON _embedding_jobs(status, next_attempt_at);
+`
+
+// initEmbeddingJobs creates the job table and attaches the job triggers to
+// every document table, queueing unembedded documents written before the
+// queue existed
+func initEmbeddingJobs(db *sql.DB) error {
+	if _, err := db.Exec(embeddingJobsSchema); err != nil {
+		return fmt.Errorf("failed to create embedding job table: %w", err)
+	}
+
+	tables, err := documentTables(db)
+	if err != nil {
+		return err
+	}
+
+	for _, tableName := range tables {
+		// Recreate the triggers so tables created by older versions pick up changes
+		for _, suffix := range []string{"ai", "au", "ad"} {
+			if _, err := db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_job_%s"`, tableName, suffix)); err != nil {
+				return fmt.Errorf("failed to drop embedding job trigger for %s: %w", tableName, err)
+			}
+		}
+		if err := createEmbeddingJobTriggers(db, tableName); err != nil {
+			return err
+		}
+
+		backfill := fmt.Sprintf(`
+			INSERT INTO _embedding_jobs (table_name, doc_id, status, attempts, next_attempt_at, created_at, updated_at)
+			SELECT '%s', id, 'pending', 0, unixepoch(), unixepoch(), unixepoch()
+			FROM "%s" WHERE is_embedded = 0 AND chunk_count = 0
+			ON CONFLICT(table_name, doc_id) DO NOTHING
+		`, tableName, tableName)
+		if _, err := db.Exec(backfill); err != nil {
+			return fmt.Errorf("failed to queue embedding jobs for %s: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+// createEmbeddingJobTriggers keeps _embedding_jobs in sync with a document table:
+// unembedded inserts and content changes queue a job, embedding or deleting
+// the document removes it. Chunked documents are embedded through their
+// chunks and never get a job of their own.
+func createEmbeddingJobTriggers(db *sql.DB, tableName string) error {
+	enqueue := fmt.Sprintf(`
+		INSERT INTO _embedding_jobs (table_name, doc_id, status, attempts, next_attempt_at, created_at, updated_at)
+		SELECT '%s', new.id, 'pending', 0, unixepoch(), unixepoch(), unixepoch()
+		WHERE new.is_embedded = 0 AND new.chunk_count = 0
+		ON CONFLICT(table_name, doc_id) DO UPDATE SET
+			status = 'pending',
+			attempts = 0,
+			last_error = NULL,
+			next_attempt_at = excluded.next_attempt_at,
+			updated_at = excluded.updated_at;
+	`, tableName)
+
+	triggerAI := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS "%s_job_ai" AFTER INSERT ON "%s" BEGIN
+			%s
+		END;
+	`, tableName, tableName, enqueue)
+
+	triggerAU := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS "%s_job_au" AFTER UPDATE ON "%s"
+		WHEN new.is_embedded = 1 OR old.is_embedded = 1 OR old.content IS NOT new.content OR new.chunk_count > 0 BEGIN
+			DELETE FROM _embedding_jobs WHERE (new.is_embedded = 1 OR new.chunk_count > 0) AND table_name = '%s' AND doc_id = old.id;
+			%s
+		END;
+	`, tableName, tableName, tableName, enqueue)
+
+	triggerAD := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS "%s_job_ad" AFTER DELETE ON "%s" BEGIN
+			DELETE FROM _embedding_jobs WHERE table_name = '%s' AND doc_id = old.id;
+		END;
+	`, tableName, tableName, tableName)
+
+	for _, trigger := range []string{triggerAI, triggerAU, triggerAD} {
+		if _, err := db.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create embedding job trigger for %s: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+// NextEmbeddingBatch claims up to limit due documents from the table whose
+// job has waited longest. Failed jobs are rescheduled into the future, so a
+// document that keeps failing never blocks the ones behind it. Claimed jobs
+// are not due again until lease has passed, so concurrent workers never get
+// the same document; a worker that dies mid-batch only delays its jobs.
+func (s *DocumentStore) NextEmbeddingBatch(dbId string, limit int, lease time.Duration) (string, []*Document, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	now := time.Now().Unix()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tableName string
+	err = tx.QueryRow(`
+		SELECT table_name FROM _embedding_jobs
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, created_at
+		LIMIT 1
+	`, JobStatusPending, now).Scan(&tableName)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query embedding jobs: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT d.id, d.content
+		FROM _embedding_jobs j
+		JOIN "%s" d ON d.id = j.doc_id
+		WHERE j.table_name = ? AND j.status = ? AND j.next_attempt_at <= ?
+		ORDER BY j.next_attempt_at, j.created_at
+		LIMIT ?
+	`, tableName)
+
+	rows, err := tx.Query(query, tableName, JobStatusPending, now, limit)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query embedding jobs: %w", err)
+	}
+
+	var docs []*Document
+	for rows.Next() {
+		doc := &Document{DB: dbId, Table: tableName}
+		if err := rows.Scan(&doc.ID, &doc.Content); err != nil {
+			rows.Close()
+			return "", nil, fmt.Errorf("failed to scan embedding job: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("failed to query embedding jobs: %w", err)
+	}
+
+	leaseUntil := time.Now().Add(lease).Unix()
+	for _, doc := range docs {
+		if _, err := tx.Exec(`UPDATE _embedding_jobs SET next_attempt_at = ?, updated_at = ? WHERE table_name = ? AND doc_id = ?`,
+			leaseUntil, now, tableName, doc.ID); err != nil {
+			return "", nil, fmt.Errorf("failed to claim embedding job: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to claim embedding jobs: %w", err)
+	}
+
+	return tableName, docs, nil
+}
+
+// CompleteEmbeddingJob stores the vector computed for a claimed job, which
+// completes it. The vector is only stored while the document still has the
+// content it was computed from; if the document changed or was deleted in
+// the meantime nothing is written and false is returned, leaving the job
+// queued for the new content.
+func (s *DocumentStore) CompleteEmbeddingJob(dbId, tableName, docID, content string, vector []float32) (bool, error) {
+	return s.updateDocumentVector(dbId, tableName, docID, &content, vector)
+}
+
+// FailEmbeddingJob records a failed attempt and schedules the next one with
+// exponential backoff, moving the job to the dead state after too many attempts
+func (s *DocumentStore) FailEmbeddingJob(dbId, tableName, docID string, jobErr error) error {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var attempts int
+	err = db.QueryRow(`SELECT attempts FROM _embedding_jobs WHERE table_name = ? AND doc_id = ?`,
+		tableName, docID).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		// Document was deleted or embedded in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	attempts++
+	status := JobStatusPending
+	if attempts >= maxEmbeddingAttempts {
+		status = JobStatusDead
+	}
+	now := time.Now()
+
+	_, err = db.Exec(`
+		UPDATE _embedding_jobs
+		SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+		WHERE table_name = ? AND doc_id = ?
+	`, status, attempts, jobErr.Error(), now.Add(embeddingRetryDelay(attempts)).Unix(), now.Unix(), tableName, docID)
+	if err != nil {
+		return fmt.Errorf("failed to update embedding job: %w", err)
+	}
+	return nil
+}
+
+// embeddingRetryDelay is the wait before the next attempt after attempts failures
+func embeddingRetryDelay(attempts int) time.Duration {
+	delay := embeddingRetryBase
+	for i := 1; i < attempts && delay < embeddingRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, embeddingRetryMax)
+}
+
+// ListEmbeddingJobs returns the jobs of a database, optionally filtered by status
+func (s *DocumentStore) ListEmbeddingJobs(dbId, status string, limit, offset int) ([]EmbeddingJob, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query := `
+		SELECT table_name, doc_id, status, attempts, last_error, next_attempt_at, created_at, updated_at
+		FROM _embedding_jobs
+		WHERE ? = '' OR status = ?
+		ORDER BY updated_at DESC, table_name, doc_id
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.Query(query, status, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []EmbeddingJob{}
+	for rows.Next() {
+		job := EmbeddingJob{DB: dbId}
+		var lastError sql.NullString
+		var nextAttempt, created, updated int64
+		if err := rows.Scan(&job.Table, &job.DocID, &job.Status, &job.Attempts, &lastError,
+			&nextAttempt, &created, &updated); err != nil {
+			return nil, err
+		}
+		job.LastError = lastError.String
+		job.NextAttemptAt = time.Unix(nextAttempt, 0).UTC()
+		job.CreatedAt = time.Unix(created, 0).UTC()
+		job.UpdatedAt = time.Unix(updated, 0).UTC()
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// RetryEmbeddingJobs re-queues dead jobs for an immediate attempt with a fresh
+// attempt count. An empty table matches every table, empty ids every document.
+func (s *DocumentStore) RetryEmbeddingJobs(dbId, tableName string, ids []string) (int64, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	query := `
+		UPDATE _embedding_jobs
+		SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE status = ?`
+	now := time.Now().Unix()
+	args := []interface{}{JobStatusPending, now, now, JobStatusDead}
+
+	if tableName != "" {
+		query += ` AND table_name = ?`
+		args = append(args, tableName)
+	}
+	if len(ids) > 0 {
+		query += ` AND doc_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retry embedding jobs: %w", err)
+	}
+
+	requeued, err := result.RowsAffected()
+	if requeued > 0 {
+		s.notifyEmbeddingJobs(dbId)
+	}
+	return requeued, err
+}
+
+// notifyEmbeddingJobs marks a database as having queued embedding jobs and
+// wakes an idle embedding worker without blocking. A signal that is already
+// pending covers this one as well.
+func (s *DocumentStore) notifyEmbeddingJobs(dbId string) {
+	s.setPendingEmbeddingJobs(dbId, true)
+	select {
+	case s.jobsReady <- struct{}{}:
+	default:
+	}
+}
+
+// setPendingEmbeddingJobs records whether a database may have queued
+// embedding jobs, so workers only look for jobs in those databases
+func (s *DocumentStore) setPendingEmbeddingJobs(dbId string, pending bool) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	if pending {
+		s.pendingJobs[dbId] = true
+	} else {
+		delete(s.pendingJobs, dbId)
+	}
+}
+
+// pendingEmbeddingJobDatabases lists the databases that may have queued
+// embedding jobs, sorted by name
+func (s *DocumentStore) pendingEmbeddingJobDatabases() []string {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	names := make([]string, 0, len(s.pendingJobs))
+	for name := range s.pendingJobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// seedPendingEmbeddingJobs marks every database as possibly having queued
+// jobs, so jobs left over from a previous run are found once at startup
+func (s *DocumentStore) seedPendingEmbeddingJobs() error {
+	databases, err := s.databaseNames()
+	if err != nil {
+		return err
+	}
+	for _, dbName := range databases {
+		s.setPendingEmbeddingJobs(dbName, true)
+	}
+	return nil
+}
+
+// hasEmbeddingJobs reports whether a database has pending jobs, including
+// jobs that are leased or backing off
+func (s *DocumentStore) hasEmbeddingJobs(dbId string) (bool, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	var pending bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM _embedding_jobs WHERE status = ?)`, JobStatusPending).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("failed to query embedding jobs: %w", err)
+	}
+	return pending, nil
+}
+
+// EmbeddingJobsReady is signalled whenever new embedding jobs may be due
+func (s *DocumentStore) EmbeddingJobsReady() <-chan struct{} {
+	return s.jobsReady
+}
+
+// ListEmbeddingJobs lists the embedding jobs of a database
+// GET /db/{dbName}/_jobs?status=dead|pending&limit=100&offset=0
+func (a *API) ListEmbeddingJobs(w http.ResponseWriter, r *http.Request) {
+	dbName := mux.Vars(r)["dbName"]
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != JobStatusPending && status != JobStatusDead {
+		a.errorResponse(w, http.StatusBadRequest, "status must be pending or dead")
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+
+	jobs, err := a.store.ListEmbeddingJobs(dbName, status, limit, offset)
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to list embedding jobs: %v", err))
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"db":    dbName,
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
+}
+
+// RetryEmbeddingJobs re-queues dead embedding jobs
+// POST /db/{dbName}/_jobs/retry
+// Body (optional): {"table": "articles", "ids": ["doc1", "doc2"]}
+func (a *API) RetryEmbeddingJobs(w http.ResponseWriter, r *http.Request) {
+	dbName := mux.Vars(r)["dbName"]
+
+	var req RetryJobsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	requeued, err := a.store.RetryEmbeddingJobs(dbName, req.Table, req.IDs)
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"requeued": requeued,
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func jobsByID(t *testing.T, store *DocumentStore, dbName string) map[string]EmbeddingJob {
+	t.Helper()
+
+	jobs, err := store.ListEmbeddingJobs(dbName, "", 100, 0)
+	if err != nil {
+		t.Fatalf("ListEmbeddingJobs failed: %v", err)
+	}
+	byID := make(map[string]EmbeddingJob)
+	for _, job := range jobs {
+		byID[job.DocID] = job
+	}
+	return byID
+}
+
+func TestEmbeddingJobQueue(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "articles"
+
+	docs := []*Document{
+		{ID: "first", Content: "please fail, too long"},
+		{ID: "second", Content: "second"},
+		{ID: "third", Content: "third"},
+		{ID: "embedded", Content: "embedded", Vector: []float32{1, 0, 0}},
+	}
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	t.Run("Unembedded documents are queued", func(t *testing.T) {
+		jobs := jobsByID(t, store, dbName)
+		if len(jobs) != 3 {
+			t.Fatalf("got %d jobs, want 3: %v", len(jobs), jobs)
+		}
+		if _, ok := jobs["embedded"]; ok {
+			t.Error("embedded document should not be queued")
+		}
+
+		tables, _ := store.ListTables(dbName)
+		if len(tables) != 1 || tables[0] != tableName {
+			t.Errorf("ListTables = %v, internal tables should be hidden", tables)
+		}
+	})
+
+	t.Run("Worker records failures without blocking the queue", func(t *testing.T) {
+		pool := NewEmbeddingWorkerPool(store, &batchEmbedder{}, 1, 10)
+		for pool.ProcessBatch(context.Background()) {
+		}
+
+		jobs := jobsByID(t, store, dbName)
+		if len(jobs) != 1 {
+			t.Fatalf("got jobs %v, want only the failing one", jobs)
+		}
+		job := jobs["first"]
+		if job.Attempts != 1 || job.LastError == "" || job.Status != JobStatusPending {
+			t.Errorf("failed job = %+v", job)
+		}
+		if !job.NextAttemptAt.After(time.Now()) {
+			t.Errorf("next attempt %v should be in the future", job.NextAttemptAt)
+		}
+
+		doc, err := store.GetDocument(dbName, tableName, "second")
+		if err != nil || !doc.IsEmbedded {
+			t.Errorf("second should be embedded: %+v %v", doc, err)
+		}
+
+		// The failed job is not due yet
+		_, due, err := store.NextEmbeddingBatch(dbName, 10, time.Minute)
+		if err != nil || len(due) != 0 {
+			t.Errorf("got due documents %v (%v), want none", due, err)
+		}
+	})
+
+	t.Run("Dead letter and retry", func(t *testing.T) {
+		for i := 1; i < maxEmbeddingAttempts; i++ {
+			if err := store.FailEmbeddingJob(dbName, tableName, "first", errors.New("context length exceeded")); err != nil {
+				t.Fatalf("FailEmbeddingJob failed: %v", err)
+			}
+		}
+
+		dead, err := store.ListEmbeddingJobs(dbName, JobStatusDead, 10, 0)
+		if err != nil || len(dead) != 1 || dead[0].Attempts != maxEmbeddingAttempts {
+			t.Fatalf("dead jobs = %+v (%v)", dead, err)
+		}
+
+		requeued, err := store.RetryEmbeddingJobs(dbName, tableName, []string{"first"})
+		if err != nil || requeued != 1 {
+			t.Fatalf("RetryEmbeddingJobs = %d, %v", requeued, err)
+		}
+
+		_, due, err := store.NextEmbeddingBatch(dbName, 10, time.Minute)
+		if err != nil || len(due) != 1 || due[0].ID != "first" {
+			t.Errorf("got due documents %v (%v), want first", due, err)
+		}
+	})
+
+	t.Run("Writes keep the queue in sync", func(t *testing.T) {
+		// Changing the content of an embedded document queues it again
+		if err := store.StoreDocument(dbName, tableName, &Document{ID: "embedded", Content: "changed"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if _, ok := jobsByID(t, store, dbName)["embedded"]; !ok {
+			t.Error("changed document should be queued")
+		}
+
+		// A vector computed for the old content does not complete the new job
+		completed, err := store.CompleteEmbeddingJob(dbName, tableName, "embedded", "embedded", []float32{1, 0, 0})
+		if err != nil || completed {
+			t.Fatalf("CompleteEmbeddingJob = %v, %v, want false", completed, err)
+		}
+		if _, ok := jobsByID(t, store, dbName)["embedded"]; !ok {
+			t.Error("stale vector should leave the job queued")
+		}
+		if doc, _ := store.GetDocument(dbName, tableName, "embedded"); doc.IsEmbedded {
+			t.Error("stale vector should not be stored")
+		}
+
+		if err := store.UpdateDocumentVector(dbName, tableName, "embedded", []float32{0, 1, 0}); err != nil {
+			t.Fatalf("UpdateDocumentVector failed: %v", err)
+		}
+		if err := store.DeleteDocument(dbName, tableName, "first"); err != nil {
+			t.Fatalf("DeleteDocument failed: %v", err)
+		}
+		if jobs := jobsByID(t, store, dbName); len(jobs) != 0 {
+			t.Errorf("got jobs %v, want none", jobs)
+		}
+	})
+
+	t.Run("Existing documents are backfilled", func(t *testing.T) {
+		db, release, _ := store.getDB(dbName)
+		defer release()
+		if err := store.StoreDocument(dbName, tableName, &Document{ID: "old", Content: "old"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if _, err := db.Exec(`DELETE FROM _embedding_jobs`); err != nil {
+			t.Fatalf("failed to clear jobs: %v", err)
+		}
+
+		// Databases already migrated are not scanned again
+		reopened, err := NewDocumentStore(tmpDir)
+		if err != nil {
+			t.Fatalf("NewDocumentStore failed: %v", err)
+		}
+		if _, ok := jobsByID(t, reopened, dbName)["old"]; ok {
+			t.Error("up to date database should not be migrated again")
+		}
+		reopened.Close()
+
+		// Pretend the file predates the queue
+		if _, err := db.Exec(`PRAGMA user_version = 0`); err != nil {
+			t.Fatalf("failed to reset schema version: %v", err)
+		}
+
+		reopened, err = NewDocumentStore(tmpDir)
+		if err != nil {
+			t.Fatalf("NewDocumentStore failed: %v", err)
+		}
+		defer reopened.Close()
+
+		if _, ok := jobsByID(t, reopened, dbName)["old"]; !ok {
+			t.Error("unembedded document should be queued when the database is opened")
+		}
+	})
+}
+
+func TestEmbeddingRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := embeddingRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("embeddingRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestReservedTableNames(t *testing.T) {
+	for _, name := range []string{"_embedding_jobs", "_table_settings", "_jobs", "_search"} {
+		if isValidTableName(name) {
+			t.Errorf("isValidTableName(%q) = true, want false", name)
+		}
+	}
+
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	// Other names starting with an underscore are ordinary tables
+	if err := store.StoreDocument("test_db", "_drafts", &Document{ID: "d1", Content: "draft"}); err != nil {
+		t.Fatalf("StoreDocument failed: %v", err)
+	}
+	if tables, err := store.ListTables("test_db"); err != nil || len(tables) != 1 || tables[0] != "_drafts" {
+		t.Errorf("ListTables = %v, %v", tables, err)
+	}
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,23 +23,47 @@ var Version = "dev"
 
 // Config holds application configuration
 type Config struct {
-	EmbeddingURL        string          `json:"embedding_url"`
-	EmbeddingDimensions int             `json:"embedding_dimensions"`
-	DataDir             string          `json:"data_dir"`
-	Port                string          `json:"port"`
-	InsecureSkipVerify  bool            `json:"insecure_skip_verify"` // Skip TLS certificate verification
-	CACertPath          string          `json:"ca_cert_path"`         // Path to custom CA certificate
-	Features            map[string]bool `json:"features"`             // Enabled features (true/false)
+	EmbeddingProvider   string                    `json:"embedding_provider"` // "llamacpp", "openai" or "stub" (inferred from embedding_url when empty)
+	EmbeddingURL        string                    `json:"embedding_url"`
+	EmbeddingModel      string                    `json:"embedding_model"`      // Model name sent to OpenAI-compatible services
+	EmbeddingAPIKey     string                    `json:"embedding_api_key"`    // Bearer token for OpenAI-compatible services
+	EmbeddingDimensions int                       `json:"embedding_dimensions"` // Vector length; llama.cpp defaults to 2560, OpenAI-compatible services to the model's own
+	EmbeddingBatchSize  int                       `json:"embedding_batch_size"` // Texts per embedding request (default 32)
+	EmbeddingWorkers    int                       `json:"embedding_workers"`    // Concurrent background embedding workers (default 2)
+	Embedders           map[string]EmbedderConfig `json:"embedders"`            // Additional embedders tables can select by name
+	RerankerURL         string                    `json:"reranker_url"`         // TEI or llama.cpp server with a /rerank endpoint, empty to disable reranking
+	RerankerModel       string                    `json:"reranker_model"`       // Model name sent to the rerank service
+	RerankerAPIKey      string                    `json:"reranker_api_key"`     // Bearer token for the rerank service
+	RerankerBatchSize   int                       `json:"reranker_batch_size"`  // Texts per rerank request (default 32)
+	DataDir             string                    `json:"data_dir"`
+	MaxOpenDatabases    int                       `json:"max_open_databases"` // Databases kept open at once, idle ones are closed LRU (default 256)
+	Port                string                    `json:"port"`
+	InsecureSkipVerify  bool                      `json:"insecure_skip_verify"` // Skip TLS certificate verification
+	CACertPath          string                    `json:"ca_cert_path"`         // Path to custom CA certificate
+	Features            map[string]bool           `json:"features"`             // Enabled features (true/false)
+	AuthEnabled         bool                      `json:"auth_enabled"`         // Require a bearer API key on every route but /health
+	AdminAPIKey         string                    `json:"admin_api_key"`        // Key with admin scope on all databases, for minting the first keys
+	CORSOrigins         []string                  `json:"cors_origins"`         // Origins browsers may call the API from (default any)
+}
+
+// EmbedderConfig configures a named embedder, like the embedding_* options
+// do for the default one
+type EmbedderConfig struct {
+	Provider   string `json:"provider"` // "llamacpp", "openai" or "stub"
+	URL        string `json:"url"`
+	Model      string `json:"model"`
+	APIKey     string `json:"api_key"`
+	Dimensions int    `json:"dimensions"`
+	BatchSize  int    `json:"batch_size"`
 }
 
 // loadConfig loads configuration from file with environment variable overrides
 func loadConfig(configPath string) (*Config, error) {
 	// Default config
 	config := &Config{
-		EmbeddingURL:        "stub",
-		EmbeddingDimensions: 2560,
-		DataDir:             "./data",
-		Port:                "8080",
+		EmbeddingURL: "stub",
+		DataDir:      "./data",
+		Port:         "8080",
 	}
 
 	// Try to load from file
@@ -50,17 +77,43 @@ func loadConfig(configPath string) (*Config, error) {
 	}
 
 	// Environment variables override file config
+	if provider := os.Getenv("EMBEDDING_PROVIDER"); provider != "" {
+		config.EmbeddingProvider = provider
+	}
 	if url := os.Getenv("EMBEDDING_URL"); url != "" {
 		config.EmbeddingURL = url
 	}
+	if model := os.Getenv("EMBEDDING_MODEL"); model != "" {
+		config.EmbeddingModel = model
+	}
+	if key := os.Getenv("EMBEDDING_API_KEY"); key != "" {
+		config.EmbeddingAPIKey = key
+	} else if key := os.Getenv("OPENAI_API_KEY"); key != "" && config.EmbeddingAPIKey == "" {
+		config.EmbeddingAPIKey = key
+	}
 	if dim := os.Getenv("EMBEDDING_DIMENSIONS"); dim != "" {
 		if d, err := strconv.Atoi(dim); err == nil {
 			config.EmbeddingDimensions = d
 		}
 	}
+	if size := os.Getenv("EMBEDDING_BATCH_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			config.EmbeddingBatchSize = n
+		}
+	}
+	if workers := os.Getenv("EMBEDDING_WORKERS"); workers != "" {
+		if n, err := strconv.Atoi(workers); err == nil {
+			config.EmbeddingWorkers = n
+		}
+	}
 	if dir := os.Getenv("DATA_DIR"); dir != "" {
 		config.DataDir = dir
 	}
+	if open := os.Getenv("MAX_OPEN_DATABASES"); open != "" {
+		if n, err := strconv.Atoi(open); err == nil {
+			config.MaxOpenDatabases = n
+		}
+	}
 	if port := os.Getenv("PORT"); port != "" {
 		config.Port = port
 	}
@@ -70,105 +123,296 @@ func loadConfig(configPath string) (*Config, error) {
 	if cert := os.Getenv("CA_CERT_PATH"); cert != "" {
 		config.CACertPath = cert
 	}
+	if auth := os.Getenv("AUTH_ENABLED"); auth != "" {
+		config.AuthEnabled = auth == "true"
+	}
+	if key := os.Getenv("ADMIN_API_KEY"); key != "" {
+		config.AdminAPIKey = key
+	}
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		config.CORSOrigins = strings.Split(origins, ",")
+	}
 
 	return config, nil
 }
 
+// HTTP server timeouts. Handlers that stream whole tables lift the read and
+// write deadlines with clearDeadlines.
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = time.Minute
+	serverWriteTimeout      = 5 * time.Minute // Covers synchronous embedding and reranking
+	serverIdleTimeout       = 2 * time.Minute
+	shutdownTimeout         = 30 * time.Second // Drain deadline for in-flight requests
+)
+
 // main is the entry point for the Context Pipeline API service
 func main() {
+	os.Exit(run())
+}
+
+// run serves until the process is signalled and returns the exit code:
+// 0 when every request, embedding batch and database was shut down cleanly
+func run() (code int) {
+	// stdout carries MCP messages in stdio mode; logs go to stderr
+	mcpStdio := flag.Bool("mcp-stdio", false, "Serve MCP tools over stdin/stdout instead of HTTP")
+	flag.Parse()
+
 	// Print version information
 	log.Printf("LLMDB version %s", Version)
 
 	// Load configuration
 	config, err := loadConfig("config.json")
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		log.Printf("Failed to load configuration: %v", err)
+		return 1
 	}
 
 	// Initialize document store
 	store, err := NewDocumentStore(config.DataDir)
 	if err != nil {
-		log.Fatalf("Failed to create document store: %v", err)
+		log.Printf("Failed to create document store: %v", err)
+		return 1
 	}
-	defer store.Close()
-
-	// Initialize embedder
-	var embedder Embedder
-	if config.EmbeddingURL != "" && config.EmbeddingURL != "stub" {
-		embedder, err = NewLlamaCppEmbedder(config.EmbeddingURL, config.EmbeddingDimensions, config.InsecureSkipVerify, config.CACertPath)
-		if err != nil {
-			log.Fatalf("Failed to create embedder: %v", err)
+	defer func() {
+		// Runs after the server and workers stopped, so no handler still uses the store
+		if err := store.Close(); err != nil {
+			log.Printf("Failed to close document store: %v", err)
+			code = 1
 		}
-		log.Printf("Using llama.cpp embedder at %s (dimension: %d)", config.EmbeddingURL, config.EmbeddingDimensions)
-		if config.InsecureSkipVerify {
-			log.Printf("WARNING: TLS certificate verification is disabled")
-		}
-	} else {
-		embedder = NewStubEmbedder()
+	}()
+	// Vector indexes are loaded on first use of their table
+	store.SetMaxOpenDatabases(config.MaxOpenDatabases)
+
+	// Initialize embedders
+	embedders, err := NewEmbedders(config)
+	if err != nil {
+		log.Printf("Failed to create embedder: %v", err)
+		return 1
+	}
+	embedder := embedders[DefaultEmbedderName]
+	switch e := embedder.(type) {
+	case *LlamaCppEmbedder:
+		log.Printf("Using llama.cpp embedder at %s (dimension: %d)", config.EmbeddingURL, e.dimensions)
+	case *OpenAIEmbedder:
+		log.Printf("Using OpenAI-compatible embedder at %s (model: %s, dimension: %d)", e.endpoint, e.model, e.dimensions)
+	default:
 		log.Printf("Using stub embedder (no actual embedding)")
 	}
+	for name, ec := range config.Embedders {
+		log.Printf("Embedder %q available (provider: %s, dimension: %d)", name, ec.Provider, ec.Dimensions)
+	}
+	if _, isStub := embedder.(*StubEmbedder); !isStub && config.InsecureSkipVerify {
+		log.Printf("WARNING: TLS certificate verification is disabled")
+	}
+
+	// Initialize reranker
+	reranker, err := NewReranker(config)
+	if err != nil {
+		log.Printf("Failed to create reranker: %v", err)
+		return 1
+	}
+	if reranker != nil {
+		log.Printf("Using reranker at %s", config.RerankerURL)
+	}
+
+	// Record embedder latency and errors for /metrics
+	metrics := NewMetrics()
+	embedders = metrics.InstrumentEmbedders(embedders)
+	embedder = embedders[DefaultEmbedderName]
 
 	// Create API
 	api := NewAPI(store, embedder, config)
+	api.SetEmbedders(embedders)
+	api.SetMetrics(metrics)
+	api.SetReranker(reranker)
+
+	// Start background embedding workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Start background embedding worker
-	stopWorker := make(chan struct{})
+	var pool *EmbeddingWorkerPool
 	if enabled, ok := config.Features["embedding_job"]; ok && enabled {
-		go startEmbeddingWorker(store, embedder, stopWorker)
+		pool = NewEmbeddingWorkerPool(store, embedder, config.EmbeddingWorkers, config.EmbeddingBatchSize)
+		pool.SetEmbedders(embedders)
+		pool.Start(ctx)
 	} else {
 		log.Println("Background embedding worker is disabled by configuration")
 	}
 
-	// Setup router
-	r := mux.NewRouter()
+	// Both transports stop on an interrupt signal, so the store is closed on the way out
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Routes
-	r.HandleFunc("/health", api.Health).Methods("GET")
-	r.HandleFunc("/db", api.ListDatabases).Methods("GET")
-	r.HandleFunc("/db/{dbName}", api.ListTables).Methods("GET")
-	r.HandleFunc("/db/{dbName}", api.DeleteDatabase).Methods("DELETE")
-	r.HandleFunc("/db/{dbName}/{tableName}", api.ListDocuments).Methods("GET")
-	r.HandleFunc("/db/{dbName}/{tableName}", api.StoreDocument).Methods("POST")
-	r.HandleFunc("/db/{dbName}/{tableName}/search", api.SearchDocuments).Methods("POST")
-	r.HandleFunc("/db/{dbName}/{tableName}/{docId}", api.GetDocument).Methods("GET")
-	r.HandleFunc("/db/{dbName}/{tableName}/{docId}", api.DeleteDocument).Methods("DELETE")
+	if *mcpStdio {
+		log.Printf("Serving MCP over stdio")
 
-	// Middleware
-	r.Use(loggingMiddleware)
-	r.Use(corsMiddleware)
+		// Serve in the background so a shutdown can stop waiting for it
+		served := make(chan error, 1)
+		go func() {
+			served <- api.ServeMCP(signals, os.Stdin, os.Stdout)
+		}()
+
+		select {
+		case err := <-served:
+			if err != nil {
+				log.Printf("MCP stdio transport failed: %v", err)
+				code = 1
+			}
+		case <-signals.Done():
+			stop() // A second signal kills the process right away
+			log.Printf("Shutting down MCP stdio transport...")
+
+			// Let the message in flight finish before the store is closed
+			select {
+			case <-served:
+			case <-time.After(shutdownTimeout):
+				log.Printf("MCP message did not finish within %s", shutdownTimeout)
+				code = 1
+			}
+		}
+		cancel()
+		if pool != nil {
+			pool.Wait()
+		}
+		return code
+	}
+
+	if config.AuthEnabled {
+		log.Printf("API key authentication is enabled")
+		if config.AdminAPIKey == "" {
+			log.Printf("WARNING: no admin_api_key is configured, keys can only be minted with stored admin keys")
+		}
+	} else {
+		log.Printf("WARNING: API key authentication is disabled, every route is open")
+	}
+
+	// Setup router
+	r := newRouter(api, config)
 
 	// Start server
 	addr := fmt.Sprintf(":%s", config.Port)
 	fmt.Printf("Context Pipeline API starting on %s\n", addr)
 	fmt.Printf("Data directory: %s\n", config.DataDir)
 	fmt.Printf("Embedding service: %s\n", config.EmbeddingURL)
-	fmt.Printf("Embedding dimensions: %d\n", config.EmbeddingDimensions)
+	fmt.Printf("Embedding dimensions: %d\n", embedder.Dimensions())
 	fmt.Printf("\nAvailable endpoints:\n")
 	fmt.Printf("  GET    /health\n")
+	fmt.Printf("  GET    /metrics (Prometheus)\n")
 	fmt.Printf("  GET    /db\n")
+	fmt.Printf("  POST   /_search\n")
+	fmt.Printf("  GET    /_keys\n")
+	fmt.Printf("  POST   /_keys\n")
+	fmt.Printf("  DELETE /_keys/{keyId}\n")
+	fmt.Printf("  POST   /mcp (Model Context Protocol, or run with -mcp-stdio)\n")
 	fmt.Printf("  GET    /db/{dbName}\n")
 	fmt.Printf("  DELETE /db/{dbName}\n")
+	fmt.Printf("  GET    /db/{dbName}/_jobs\n")
+	fmt.Printf("  POST   /db/{dbName}/_jobs/retry\n")
+	fmt.Printf("  POST   /db/{dbName}/_search\n")
 	fmt.Printf("  GET    /db/{dbName}/{tableName}\n")
 	fmt.Printf("  POST   /db/{dbName}/{tableName}\n")
 	fmt.Printf("  POST   /db/{dbName}/{tableName}/search\n")
-	fmt.Printf("  GET    /db/{dbName}/{tableName}/{docId}\n")
+	fmt.Printf("  POST   /db/{dbName}/{tableName}/_context\n")
+	fmt.Printf("  POST   /db/{dbName}/{tableName}/_bulk\n")
+	fmt.Printf("  GET    /db/{dbName}/{tableName}/_export\n")
+	fmt.Printf("  POST   /db/{dbName}/{tableName}/_import\n")
+	fmt.Printf("  GET    /db/{dbName}/{tableName}/_settings\n")
+	fmt.Printf("  PUT    /db/{dbName}/{tableName}/_settings\n")
+	fmt.Printf("  GET    /db/{dbName}/{tableName}/{docId}[?version=N]\n")
 	fmt.Printf("  DELETE /db/{dbName}/{tableName}/{docId}\n")
+	fmt.Printf("  GET    /db/{dbName}/{tableName}/{docId}/_history\n")
+	fmt.Printf("  POST   /db/{dbName}/{tableName}/{docId}/_restore\n")
 	fmt.Printf("\nUse X-Client-Features: embed=sync header to trigger immediate embedding\n")
 
-	// Graceful shutdown
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+
+	serverErr := make(chan error, 1)
 	go func() {
-		if err := http.ListenAndServe(addr, r); err != nil {
-			log.Fatalf("Server failed: %v", err)
-		}
+		serverErr <- server.ListenAndServe()
 	}()
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	// Wait for an interrupt signal or a listener failure
+	select {
+	case err := <-serverErr:
+		log.Printf("Server failed: %v", err)
+		code = 1
+	case <-signals.Done():
+		stop() // A second signal kills the process right away
+		fmt.Println("\nShutting down gracefully...")
+
+		// Stop accepting connections and let in-flight requests finish
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Requests did not finish within %s: %v", shutdownTimeout, err)
+			server.Close()
+			code = 1
+		}
+	}
+
+	cancel() // Stop the background workers
+	if pool != nil {
+		pool.Wait() // Let in-flight embedding batches finish
+	}
+	return code
+}
+
+// clearDeadlines lifts the server's read and write timeouts for a request
+// that streams a whole table, which can legitimately take longer
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// newRouter registers the API routes and middleware
+func newRouter(api *API, config *Config) *mux.Router {
+	r := mux.NewRouter()
+
+	// Routes
+	r.HandleFunc("/health", api.Health).Methods("GET")
+	r.HandleFunc("/metrics", api.Metrics).Methods("GET")
+	r.HandleFunc("/db", api.ListDatabases).Methods("GET")
+	r.HandleFunc("/_search", api.GlobalSearch).Methods("POST")
+	r.HandleFunc("/_keys", api.ListAPIKeys).Methods("GET")
+	r.HandleFunc("/_keys", api.CreateAPIKey).Methods("POST")
+	r.HandleFunc("/_keys/{keyId}", api.RevokeAPIKey).Methods("DELETE")
+	r.HandleFunc("/mcp", api.MCPHandler).Methods("GET", "POST", "DELETE")
+	r.HandleFunc("/db/{dbName}", api.ListTables).Methods("GET")
+	r.HandleFunc("/db/{dbName}", api.DeleteDatabase).Methods("DELETE")
+	r.HandleFunc("/db/{dbName}/_jobs", api.ListEmbeddingJobs).Methods("GET")
+	r.HandleFunc("/db/{dbName}/_jobs/retry", api.RetryEmbeddingJobs).Methods("POST")
+	r.HandleFunc("/db/{dbName}/_search", api.FederatedSearch).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}", api.ListDocuments).Methods("GET")
+	r.HandleFunc("/db/{dbName}/{tableName}", api.StoreDocument).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}/search", api.SearchDocuments).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}/_context", api.BuildContext).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}/_bulk", api.BulkStoreDocuments).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}/_export", api.ExportTable).Methods("GET")
+	r.HandleFunc("/db/{dbName}/{tableName}/_import", api.ImportTable).Methods("POST")
+	r.HandleFunc("/db/{dbName}/{tableName}/_settings", api.GetTableSettings).Methods("GET")
+	r.HandleFunc("/db/{dbName}/{tableName}/_settings", api.UpdateTableSettings).Methods("PUT")
+	r.HandleFunc("/db/{dbName}/{tableName}/{docId}", api.GetDocument).Methods("GET")
+	r.HandleFunc("/db/{dbName}/{tableName}/{docId}", api.DeleteDocument).Methods("DELETE")
+	r.HandleFunc("/db/{dbName}/{tableName}/{docId}/_history", api.GetDocumentHistory).Methods("GET")
+	r.HandleFunc("/db/{dbName}/{tableName}/{docId}/_restore", api.RestoreDocument).Methods("POST")
+
+	// Middleware; metrics and CORS run before auth so rejections are
+	// counted and carry CORS headers
+	r.Use(loggingMiddleware)
+	r.Use(api.metrics.Middleware)
+	r.Use(corsMiddleware(config.CORSOrigins))
+	r.Use(api.authMiddleware)
 
-	fmt.Println("\nShutting down gracefully...")
-	close(stopWorker) // Stop the background worker
+	return r
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -178,19 +422,33 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Client-Features")
+// corsMiddleware allows browsers on the given origins to call the API
+// An empty list or "*" allows any origin.
+func corsMiddleware(origins []string) mux.MiddlewareFunc {
+	anyOrigin := len(origins) == 0 || slices.Contains(origins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin != "" && slices.Contains(origins, origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Client-Features")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // Helpers
@@ -201,106 +459,3 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
-
-// startEmbeddingWorker polls for non-embedded documents and processes them
-func startEmbeddingWorker(store *DocumentStore, embedder Embedder, stop chan struct{}) {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	log.Println("Background embedding worker started")
-
-	for {
-		select {
-		case <-stop:
-			log.Println("Background embedding worker stopped")
-			return
-		case <-ticker.C:
-			processNonEmbeddedDocuments(store, embedder)
-		}
-	}
-}
-
-// processNonEmbeddedDocuments finds and embeds documents across all databases and tables
-func processNonEmbeddedDocuments(store *DocumentStore, embedder Embedder) {
-	log.Println("Embedding worker: checking for non-embedded documents...")
-
-	// Get all databases
-	databases, err := store.ListDatabases()
-	if err != nil {
-		log.Printf("Error listing databases for embedding: %v", err)
-		return
-	}
-
-	log.Printf("Embedding worker: found %d databases to check", len(databases))
-	totalProcessed := 0
-	maxDocuments := 15 // Process up to 15 documents total per cycle
-
-	for _, db := range databases {
-		if totalProcessed >= maxDocuments {
-			break
-		}
-
-		// Get all tables in this database
-		tables, err := store.ListTables(db.Name)
-		if err != nil {
-			log.Printf("Error listing tables in database %s: %v", db.Name, err)
-			continue
-		}
-
-		log.Printf("Embedding worker: found %d tables in database '%s'", len(tables), db.Name)
-
-		// Process each table
-		for _, tableName := range tables {
-			if totalProcessed >= maxDocuments {
-				break
-			}
-
-			// Calculate remaining capacity
-			remaining := maxDocuments - totalProcessed
-
-			log.Printf("Embedding worker: checking table '%s.%s' for up to %d documents", db.Name, tableName, remaining)
-
-			// Get non-embedded documents from this table
-			docs, err := store.GetNonEmbeddedDocuments(db.Name, tableName, remaining)
-			if err != nil {
-				log.Printf("Error getting non-embedded documents from %s.%s: %v", db.Name, tableName, err)
-				continue
-			}
-
-			log.Printf("Embedding worker: found %d non-embedded documents in table '%s.%s'", len(docs), db.Name, tableName)
-
-			if len(docs) == 0 {
-				continue
-			}
-
-			log.Printf("Processing %d non-embedded documents from table '%s.%s'", len(docs), db.Name, tableName)
-
-			for _, doc := range docs {
-				// Create context with timeout for each document
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-				vector, err := embedder.Embed(ctx, doc.Content)
-				cancel() // Clean up context immediately
-
-				if err != nil {
-					log.Printf("Failed to embed document %s in table %s.%s: %v", doc.ID, db.Name, tableName, err)
-					continue
-				}
-
-				// Update the document with the vector
-				if err := store.UpdateDocumentVector(db.Name, tableName, doc.ID, vector); err != nil {
-					log.Printf("Failed to update document %s vector in table %s.%s: %v", doc.ID, db.Name, tableName, err)
-					continue
-				}
-
-				totalProcessed++
-			}
-		}
-	}
-
-	if totalProcessed > 0 {
-		log.Printf("Background embedding: processed %d documents", totalProcessed)
-	} else {
-		log.Println("Embedding worker: no documents to process")
-	}
-}
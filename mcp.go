@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// Model Context Protocol (MCP) server exposing llmdb to LLM agents as tools.
+// Messages are JSON-RPC 2.0, carried one per line over stdio or one per
+// request over streamable HTTP (POST /mcp).
+
+// mcpProtocolVersions lists the supported protocol revisions, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// maxMCPMessageSize bounds one message, which may carry a whole document
+const maxMCPMessageSize = 32 << 20
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// jsonRPCMessage is a request or notification; notifications have no ID
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// jsonRPCResponse answers a request with either a result or an error
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool is a tool offered to agents
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+
+	call func(ctx context.Context, args json.RawMessage) (interface{}, error)
+}
+
+// mcpToolContent is a block of a tool result; llmdb only returns text
+type mcpToolContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of tools/call. Failures of the tool itself are
+// reported here with IsError so the agent can see them, not as JSON-RPC errors.
+type mcpToolResult struct {
+	Content           []mcpToolContent `json:"content"`
+	StructuredContent interface{}      `json:"structuredContent,omitempty"`
+	IsError           bool             `json:"isError,omitempty"`
+}
+
+// Schemas of tool arguments shared by several tools
+var (
+	mcpDBSchema    = map[string]interface{}{"type": "string", "description": "Database name"}
+	mcpTableSchema = map[string]interface{}{"type": "string", "description": "Table name"}
+	mcpIDSchema    = map[string]interface{}{"type": "string", "description": "Document ID"}
+
+	mcpFiltersSchema = map[string]interface{}{
+		"type": "object",
+		"description": `Metadata filter. Keys are metadata fields (dotted paths such as "author.name" reach into nested objects) ` +
+			`mapped to a value for equality or to an operator object: $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $contains. ` +
+			`Combine conditions with $and, $or and $not. The "tags" key accepts $in, $nin and $all. ` +
+			`Example: {"year": {"$gte": 2022}, "$or": [{"lang": "en"}, {"tags": {"$in": ["ml"]}}]}`,
+		"additionalProperties": true,
+	}
+)
+
+// mcpArgs decodes tool arguments, rejecting unknown fields
+func mcpArgs(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		raw = []byte("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// mcpTools returns the tools offered to agents, each backed by the same
+// store and search code as the REST routes
+func (a *API) mcpTools() []mcpTool {
+	return []mcpTool{
+		{
+			Name:        "search",
+			Description: "Search documents of a table by full-text, vector similarity or both (hybrid). Returns ranked documents with scores.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"db":    mcpDBSchema,
+					"table": mcpTableSchema,
+					"query": map[string]interface{}{"type": "string", "description": "Search text, FTS5 syntax for full-text search"},
+					"type": map[string]interface{}{
+						"type": "string", "enum": []string{"fulltext", "vector", "hybrid"},
+						"description": "Search type (default fulltext)",
+					},
+					"limit":    map[string]interface{}{"type": "integer", "minimum": 1, "description": "Maximum results (default 10)"},
+					"filters":  mcpFiltersSchema,
+					"collapse": map[string]interface{}{"type": "boolean", "description": "Return chunked documents once, with their best chunk"},
+				},
+				"required": []string{"db", "table", "query"},
+			},
+			call: a.mcpSearch,
+		},
+		{
+			Name:        "store_document",
+			Description: "Store a markdown document in a table, replacing any document with the same ID. Tables are created on first write.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"db":       mcpDBSchema,
+					"table":    mcpTableSchema,
+					"id":       map[string]interface{}{"type": "string", "description": "Document ID, generated when omitted"},
+					"content":  map[string]interface{}{"type": "string", "description": "Markdown text"},
+					"metadata": map[string]interface{}{"type": "object", "description": "Arbitrary JSON metadata, searchable with filters", "additionalProperties": true},
+					"tags":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				},
+				"required": []string{"db", "table", "content"},
+			},
+			call: a.mcpStoreDocument,
+		},
+		{
+			Name:        "get_document",
+			Description: "Get a document by ID.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"db":    mcpDBSchema,
+					"table": mcpTableSchema,
+					"id":    mcpIDSchema,
+				},
+				"required": []string{"db", "table", "id"},
+			},
+			call: a.mcpGetDocument,
+		},
+		{
+			Name:        "list_tables",
+			Description: "List the tables of a database, or of every database when db is omitted.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"db": mcpDBSchema,
+				},
+			},
+			call: a.mcpListTables,
+		},
+		{
+			Name:        "delete_document",
+			Description: "Delete a document, and its chunks, by ID.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"db":    mcpDBSchema,
+					"table": mcpTableSchema,
+					"id":    mcpIDSchema,
+				},
+				"required": []string{"db", "table", "id"},
+			},
+			call: a.mcpDeleteDocument,
+		},
+	}
+}
+
+// mcpDocumentRef names a document in tool arguments
+type mcpDocumentRef struct {
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	ID    string `json:"id"`
+}
+
+func (a *API) mcpSearch(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		DB    string `json:"db"`
+		Table string `json:"table"`
+		SearchRequest
+	}
+	if err := mcpArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.DB == "" || args.Table == "" {
+		return nil, fmt.Errorf("db and table are required")
+	}
+	if err := authorize(ctx, args.DB, ScopeRead); err != nil {
+		return nil, err
+	}
+
+	req := args.SearchRequest
+	if err := validateSearchRequest(&req); err != nil {
+		return nil, err
+	}
+
+	results, err := a.searchTable(ctx, args.DB, args.Table, req)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+
+	return SearchResponse{
+		Results: results,
+		Query:   req.Query,
+		Type:    req.Type,
+		DB:      args.DB,
+		Total:   len(results),
+	}, nil
+}
+
+func (a *API) mcpStoreDocument(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		DB    string `json:"db"`
+		Table string `json:"table"`
+		StoreDocumentRequest
+	}
+	if err := mcpArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.DB == "" || args.Table == "" {
+		return nil, fmt.Errorf("db and table are required")
+	}
+	if err := authorize(ctx, args.DB, ScopeWrite); err != nil {
+		return nil, err
+	}
+	if err := validateStoreDocumentRequest(&args.StoreDocumentRequest); err != nil {
+		return nil, err
+	}
+
+	doc, err := a.storeDocument(ctx, args.DB, args.Table, &args.StoreDocumentRequest, a.config.Features["embedding"])
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":          doc.ID,
+		"db":          args.DB,
+		"table":       args.Table,
+		"is_embedded": doc.IsEmbedded,
+		"chunk_count": doc.ChunkCount,
+	}, nil
+}
+
+func (a *API) mcpGetDocument(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args mcpDocumentRef
+	if err := mcpArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.DB == "" || args.Table == "" || args.ID == "" {
+		return nil, fmt.Errorf("db, table and id are required")
+	}
+	if err := authorize(ctx, args.DB, ScopeRead); err != nil {
+		return nil, err
+	}
+
+	return a.store.GetDocument(args.DB, args.Table, args.ID)
+}
+
+func (a *API) mcpListTables(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		DB string `json:"db"`
+	}
+	if err := mcpArgs(raw, &args); err != nil {
+		return nil, err
+	}
+
+	databases := []string{args.DB}
+	if args.DB == "" {
+		names, err := a.store.databaseNames()
+		if err != nil {
+			return nil, err
+		}
+		databases = slices.DeleteFunc(names, func(db string) bool {
+			return authorize(ctx, db, ScopeRead) != nil
+		})
+	} else if err := authorize(ctx, args.DB, ScopeRead); err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string][]string, len(databases))
+	for _, db := range databases {
+		names, err := a.store.ListTables(db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables of %s: %w", db, err)
+		}
+		tables[db] = names
+	}
+
+	return map[string]interface{}{"databases": tables}, nil
+}
+
+func (a *API) mcpDeleteDocument(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args mcpDocumentRef
+	if err := mcpArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.DB == "" || args.Table == "" || args.ID == "" {
+		return nil, fmt.Errorf("db, table and id are required")
+	}
+	if err := authorize(ctx, args.DB, ScopeWrite); err != nil {
+		return nil, err
+	}
+
+	if err := a.store.DeleteDocument(args.DB, args.Table, args.ID); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": args.ID, "deleted": true}, nil
+}
+
+// handleMCPMessage answers one JSON-RPC message. It returns nil for
+// notifications, which get no response.
+func (a *API) handleMCPMessage(ctx context.Context, data []byte) *jsonRPCResponse {
+	var msg jsonRPCMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return mcpError(nil, rpcParseError, "parse error")
+	}
+	if len(msg.ID) == 0 {
+		return nil // Notifications such as notifications/initialized need no action
+	}
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		return mcpError(msg.ID, rpcInvalidRequest, "invalid request")
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+
+		// Answer in the client's revision when supported, else in the newest
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+
+		return mcpResult(msg.ID, map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities": map[string]interface{}{
+				"tools": map[string]interface{}{"listChanged": false},
+			},
+			"serverInfo": map[string]interface{}{
+				"name":    "llmdb",
+				"version": Version,
+			},
+			"instructions": "llmdb stores markdown documents in tables grouped into databases. " +
+				"Use list_tables to discover them and search to retrieve documents.",
+		})
+
+	case "ping":
+		return mcpResult(msg.ID, map[string]interface{}{})
+
+	case "tools/list":
+		return mcpResult(msg.ID, map[string]interface{}{"tools": a.mcpTools()})
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return mcpError(msg.ID, rpcInvalidParams, "invalid params")
+		}
+
+		tools := a.mcpTools()
+		index := slices.IndexFunc(tools, func(t mcpTool) bool { return t.Name == params.Name })
+		if index < 0 {
+			return mcpError(msg.ID, rpcInvalidParams, fmt.Sprintf("unknown tool: %s", params.Name))
+		}
+
+		output, err := tools[index].call(ctx, params.Arguments)
+		if err != nil {
+			return mcpResult(msg.ID, mcpToolResult{
+				Content: []mcpToolContent{{Type: "text", Text: err.Error()}},
+				IsError: true,
+			})
+		}
+
+		text, err := json.Marshal(output)
+		if err != nil {
+			return mcpResult(msg.ID, mcpToolResult{
+				Content: []mcpToolContent{{Type: "text", Text: fmt.Sprintf("failed to encode result: %v", err)}},
+				IsError: true,
+			})
+		}
+		return mcpResult(msg.ID, mcpToolResult{
+			Content:           []mcpToolContent{{Type: "text", Text: string(text)}},
+			StructuredContent: output,
+		})
+	}
+
+	return mcpError(msg.ID, rpcMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method))
+}
+
+func mcpResult(id json.RawMessage, result interface{}) *jsonRPCResponse {
+	return &jsonRPCResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func mcpError(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", ID: id, Error: &jsonRPCError{Code: code, Message: message}}
+}
+
+// stdioLine is one line read by the stdio transport
+type stdioLine struct {
+	data []byte
+	err  error
+}
+
+// ServeMCP runs the stdio transport: one JSON-RPC message per line on r,
+// responses written one per line to w. It returns when r is exhausted, or
+// when ctx is done once the message in flight has been answered; like HTTP
+// requests during a shutdown, messages are not cancelled halfway.
+func (a *API) ServeMCP(ctx context.Context, r io.Reader, w io.Writer) error {
+	encoder := json.NewEncoder(w) // Encode terminates each message with a newline
+
+	// A read cannot be interrupted, so r is read in the background and a
+	// read still blocked on return is abandoned
+	lines := make(chan stdioLine)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		reader := bufio.NewReaderSize(r, 64*1024)
+		for {
+			data, err := reader.ReadBytes('\n')
+			select {
+			case lines <- stdioLine{data: data, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	handlerCtx := context.WithoutCancel(ctx)
+	for {
+		var next stdioLine
+		select {
+		case next = <-lines:
+		case <-ctx.Done():
+			return nil
+		}
+
+		line, err := next.data, next.err
+		if len(line) > maxMCPMessageSize {
+			return fmt.Errorf("message exceeds %d bytes", maxMCPMessageSize)
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if resp := a.handleMCPMessage(handlerCtx, line); resp != nil {
+				if err := encoder.Encode(resp); err != nil {
+					return err
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// MCPHandler runs the streamable HTTP transport. Each POST carries one
+// message and gets its response as JSON; the server never opens an event
+// stream of its own, so GET is not allowed.
+// POST /mcp
+func (a *API) MCPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		a.errorResponse(w, http.StatusMethodNotAllowed, "the MCP endpoint only accepts POST")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxMCPMessageSize+1))
+	if err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if len(data) > maxMCPMessageSize {
+		a.errorResponse(w, http.StatusRequestEntityTooLarge, "message too large")
+		return
+	}
+
+	resp := a.handleMCPMessage(r.Context(), data)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mcpTestResponse is a JSON-RPC response with the result left raw
+type mcpTestResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *jsonRPCError   `json:"error"`
+}
+
+func TestMCPStdio(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	call := func(id int, tool string, args string) string {
+		return fmt.Sprintf(`{"jsonrpc": "2.0", "id": %d, "method": "tools/call", "params": {"name": %q, "arguments": %s}}`, id, tool, args)
+	}
+	input := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26", "capabilities": {}, "clientInfo": {"name": "test", "version": "1"}}}`,
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/list"}`,
+		call(3, "store_document", `{"db": "kb", "table": "notes", "id": "n1", "content": "Rotate the API keys monthly", "metadata": {"team": "ops"}}`),
+		call(4, "search", `{"db": "kb", "table": "notes", "query": "keys", "filters": {"team": "ops"}}`),
+		call(5, "get_document", `{"db": "kb", "table": "notes", "id": "n1"}`),
+		call(6, "list_tables", `{}`),
+		call(7, "delete_document", `{"db": "kb", "table": "notes", "id": "n1"}`),
+		call(8, "get_document", `{"db": "kb", "table": "notes", "id": "n1"}`),
+		call(9, "search", `{"db": "kb", "table": "notes", "query": "keys", "bogus": 1}`),
+		call(10, "drop_database", `{}`),
+		`{"jsonrpc": "2.0", "id": 11, "method": "resources/list"}`,
+		`not json`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := api.ServeMCP(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatalf("ServeMCP failed: %v", err)
+	}
+
+	responses := make(map[int]mcpTestResponse)
+	var parseErrors int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp mcpTestResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("invalid response line %q: %v", line, err)
+		}
+		if resp.ID == 0 {
+			parseErrors++
+			continue
+		}
+		responses[resp.ID] = resp
+	}
+	if len(responses) != 11 || parseErrors != 1 {
+		t.Fatalf("got %d responses and %d parse errors, want 11 and 1 (the notification gets none):\n%s", len(responses), parseErrors, out.String())
+	}
+
+	toolResult := func(t *testing.T, id int) mcpToolResult {
+		t.Helper()
+		var result mcpToolResult
+		if err := json.Unmarshal(responses[id].Result, &result); err != nil || len(result.Content) != 1 {
+			t.Fatalf("response %d: %s", id, responses[id].Result)
+		}
+		return result
+	}
+
+	t.Run("Initialize negotiates the protocol version", func(t *testing.T) {
+		var result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			Capabilities    struct {
+				Tools map[string]interface{} `json:"tools"`
+			} `json:"capabilities"`
+		}
+		json.Unmarshal(responses[1].Result, &result)
+		if result.ProtocolVersion != "2025-03-26" || result.Capabilities.Tools == nil {
+			t.Errorf("initialize result = %s", responses[1].Result)
+		}
+	})
+
+	t.Run("Tools are listed with schemas", func(t *testing.T) {
+		var result struct {
+			Tools []mcpTool `json:"tools"`
+		}
+		json.Unmarshal(responses[2].Result, &result)
+
+		var names []string
+		for _, tool := range result.Tools {
+			names = append(names, tool.Name)
+			if tool.InputSchema["type"] != "object" {
+				t.Errorf("tool %s has no object schema", tool.Name)
+			}
+		}
+		if strings.Join(names, ",") != "search,store_document,get_document,list_tables,delete_document" {
+			t.Errorf("tools = %v", names)
+		}
+		if !strings.Contains(string(responses[2].Result), `"filters":{"additionalProperties":true`) {
+			t.Errorf("search schema should describe filters: %s", responses[2].Result)
+		}
+	})
+
+	t.Run("Tools map onto the store", func(t *testing.T) {
+		if result := toolResult(t, 3); result.IsError || !strings.Contains(result.Content[0].Text, `"id":"n1"`) {
+			t.Errorf("store_document = %+v", result)
+		}
+
+		var search SearchResponse
+		json.Unmarshal([]byte(toolResult(t, 4).Content[0].Text), &search)
+		if search.Total != 1 || search.Results[0].Document.ID != "n1" || search.Results[0].Rank != 1 {
+			t.Errorf("search = %+v", search)
+		}
+
+		var doc Document
+		json.Unmarshal([]byte(toolResult(t, 5).Content[0].Text), &doc)
+		if doc.Content != "Rotate the API keys monthly" || doc.Metadata["team"] != "ops" {
+			t.Errorf("get_document = %+v", doc)
+		}
+
+		if text := toolResult(t, 6).Content[0].Text; text != `{"databases":{"kb":["notes"]}}` {
+			t.Errorf("list_tables = %s", text)
+		}
+		if result := toolResult(t, 7); result.IsError {
+			t.Errorf("delete_document = %+v", result)
+		}
+	})
+
+	t.Run("Failures are reported", func(t *testing.T) {
+		if result := toolResult(t, 8); !result.IsError || !strings.Contains(result.Content[0].Text, "not found") {
+			t.Errorf("get_document after delete = %+v", result)
+		}
+		if result := toolResult(t, 9); !result.IsError || !strings.Contains(result.Content[0].Text, "bogus") {
+			t.Errorf("unknown argument = %+v", result)
+		}
+		if err := responses[10].Error; err == nil || err.Code != rpcInvalidParams {
+			t.Errorf("unknown tool error = %+v", err)
+		}
+		if err := responses[11].Error; err == nil || err.Code != rpcMethodNotFound {
+			t.Errorf("unknown method error = %+v", err)
+		}
+	})
+}
+
+func TestMCPStdioCancel(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	// The pipe is never closed, like stdin of a client that stays connected
+	input, client := io.Pipe()
+	defer client.Close()
+	output, server := io.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- api.ServeMCP(ctx, input, server)
+	}()
+
+	go client.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}` + "\n"))
+	var resp mcpTestResponse
+	if err := json.NewDecoder(output).Decode(&resp); err != nil || resp.ID != 1 || resp.Error != nil {
+		t.Fatalf("tools/list = %+v, %v", resp, err)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeMCP = %v, want nil after cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeMCP did not return after cancellation while waiting for input")
+	}
+}
+
+func TestMCPHandler(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		api.MCPHandler(rec, req)
+		return rec
+	}
+
+	rec := post(`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "1999-01-01"}}`)
+	var resp mcpTestResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", rec.Code, err)
+	}
+	if !strings.Contains(string(resp.Result), `"protocolVersion":"`+mcpProtocolVersions[0]+`"`) {
+		t.Errorf("unsupported versions should get the newest: %s", resp.Result)
+	}
+
+	if rec := post(`{"jsonrpc": "2.0", "method": "notifications/initialized"}`); rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("notification: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.MCPHandler(rec, httptest.NewRequest(http.MethodGet, "/mcp", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+}
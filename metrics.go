@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Metrics are exposed in the Prometheus text format without a client
+// library. Counters and histograms are recorded as requests happen; queue
+// depths, document counts and open connections are read from the store when
+// /metrics is scraped. Only open databases are counted, so scrapes never open
+// database files, and their counts are reused for databaseStatsTTL.
+
+// databaseStatsTTL is how long the queue depths and document counts of a
+// database are reused between scrapes, since counting large tables is slow
+const databaseStatsTTL = 30 * time.Second
+
+// defaultLatencyBuckets are the upper bounds in seconds of latency histograms
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricFamily is a counter or histogram with one series per label set
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string // "counter" or "histogram"
+	labels  []string
+	buckets []float64 // Histogram bucket upper bounds
+
+	mu     sync.Mutex
+	series map[string]*metricSeries // Joined label values -> series
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // Counter value or histogram sum
+	count       uint64   // Histogram observations
+	buckets     []uint64 // Observations per bucket, not cumulative
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*metricSeries)}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+}
+
+// get returns the series for the label values, creating it on first use
+// Must be called with f.mu held.
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues, buckets: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Inc adds one to a counter
+func (f *metricFamily) Inc(labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value++
+}
+
+// Observe records a histogram observation
+func (f *metricFamily) Observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.get(labelValues)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(f.buckets, v); i < len(f.buckets) {
+		s.buckets[i]++
+	}
+}
+
+// ObserveSince records the seconds elapsed since start
+func (f *metricFamily) ObserveSince(start time.Time, labelValues ...string) {
+	f.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// write prints the family in the text format, series sorted by labels
+func (f *metricFamily) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+	for _, key := range slices.Sorted(maps.Keys(f.series)) {
+		s := f.series[key]
+		labels := formatLabels(f.labels, s.labelValues)
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatMetricValue(s.value))
+			continue
+		}
+
+		bucketLabels := slices.Concat(f.labels, []string{"le"})
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.buckets[i]
+			le := formatLabels(bucketLabels, slices.Concat(s.labelValues, []string{formatMetricValue(bound)}))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, le, cumulative)
+		}
+		le := formatLabels(bucketLabels, slices.Concat(s.labelValues, []string{"+Inf"}))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, le, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatMetricValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, s.count)
+	}
+}
+
+// writeGauge prints a gauge family computed at scrape time
+func writeGauge(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.labelValues), formatMetricValue(s.value))
+	}
+}
+
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+// formatLabels renders {name="value",...}, escaping values as the format requires
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Metrics holds the counters and histograms recorded while serving
+type Metrics struct {
+	httpRequests     *metricFamily
+	httpDuration     *metricFamily
+	searchDuration   *metricFamily
+	embedderDuration *metricFamily
+	embedderErrors   *metricFamily
+
+	statsMu sync.Mutex
+	stats   map[string]cachedDatabaseStats // Database -> stats of an earlier scrape
+}
+
+type cachedDatabaseStats struct {
+	stats       DatabaseStats
+	collectedAt time.Time
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		httpRequests: newCounter("llmdb_http_requests_total",
+			"HTTP requests by route, method and status.", "route", "method", "status"),
+		httpDuration: newHistogram("llmdb_http_request_duration_seconds",
+			"HTTP request latency by route, method and status.", defaultLatencyBuckets, "route", "method", "status"),
+		searchDuration: newHistogram("llmdb_search_duration_seconds",
+			"Latency of searches against one table by search type.", defaultLatencyBuckets, "type"),
+		embedderDuration: newHistogram("llmdb_embedder_request_duration_seconds",
+			"Latency of embedder calls by embedder and operation.", defaultLatencyBuckets, "embedder", "operation"),
+		embedderErrors: newCounter("llmdb_embedder_errors_total",
+			"Failed embedder calls by embedder and operation.", "embedder", "operation"),
+	}
+}
+
+// Middleware counts and times requests by route template, so document IDs
+// and database names do not create a series each
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		status := strconv.Itoa(rec.status)
+		m.httpRequests.Inc(route, r.Method, status)
+		m.httpDuration.ObserveSince(start, route, r.Method, status)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush keeps streaming responses such as exports flowing
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// InstrumentEmbedders wraps every embedder so its calls are timed and its
+// failures counted under the embedder's name
+func (m *Metrics) InstrumentEmbedders(embedders Embedders) Embedders {
+	instrumented := make(Embedders, len(embedders))
+	for name, embedder := range embedders {
+		instrumented[name] = &instrumentedEmbedder{Embedder: embedder, name: name, metrics: m}
+	}
+	return instrumented
+}
+
+// instrumentedEmbedder records metrics around another embedder's calls
+type instrumentedEmbedder struct {
+	Embedder
+	name    string
+	metrics *Metrics
+}
+
+func (e *instrumentedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	defer e.metrics.embedderDuration.ObserveSince(time.Now(), e.name, "embed")
+	vector, err := e.Embedder.Embed(ctx, text)
+	if err != nil {
+		e.metrics.embedderErrors.Inc(e.name, "embed")
+	}
+	return vector, err
+}
+
+func (e *instrumentedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	defer e.metrics.embedderDuration.ObserveSince(time.Now(), e.name, "embed_batch")
+	vectors, err := e.Embedder.EmbedBatch(ctx, texts)
+	if err != nil {
+		e.metrics.embedderErrors.Inc(e.name, "embed_batch")
+	}
+	return vectors, err
+}
+
+// DatabaseStats is a snapshot of one database for the metrics endpoint
+type DatabaseStats struct {
+	QueueDepth map[string]int64 // Embedding job status -> jobs
+	Documents  map[string]int64 // Table -> documents, not counting chunks
+}
+
+// DatabaseStats counts the queued embedding jobs and documents of a database
+// ok is false when the database is not open; it is not opened to count it.
+func (s *DocumentStore) DatabaseStats(dbId string) (stats DatabaseStats, ok bool, err error) {
+	db, release := s.getOpenDB(dbId)
+	if db == nil {
+		return DatabaseStats{}, false, nil
+	}
+	defer release()
+
+	stats = DatabaseStats{
+		QueueDepth: map[string]int64{JobStatusPending: 0, JobStatusDead: 0},
+		Documents:  make(map[string]int64),
+	}
+
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM _embedding_jobs GROUP BY status`)
+	if err != nil {
+		return stats, true, fmt.Errorf("failed to count embedding jobs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return stats, true, err
+		}
+		stats.QueueDepth[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return stats, true, err
+	}
+
+	tables, err := documentTables(db)
+	if err != nil {
+		return stats, true, err
+	}
+	for _, tableName := range tables {
+		var n int64
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE parent_id IS NULL`, tableName)
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			return stats, true, fmt.Errorf("failed to count documents in %s: %w", tableName, err)
+		}
+		stats.Documents[tableName] = n
+	}
+
+	return stats, true, nil
+}
+
+// openDatabaseNames lists the open databases other than the system database
+func (s *DocumentStore) openDatabaseNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.dbs))
+	for name, h := range s.dbs {
+		if name != systemDatabase && !h.deleting {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// collectDatabaseStats returns the stats of the open databases by name.
+// Stats younger than databaseStatsTTL are reused instead of counted again,
+// and databases closed since the last scrape are dropped from the cache.
+func (m *Metrics) collectDatabaseStats(store *DocumentStore) map[string]DatabaseStats {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	now := time.Now()
+	cached := make(map[string]cachedDatabaseStats)
+	for _, dbName := range store.openDatabaseNames() {
+		c, ok := m.stats[dbName]
+		if !ok || now.Sub(c.collectedAt) >= databaseStatsTTL {
+			stats, open, err := store.DatabaseStats(dbName)
+			if err != nil {
+				// One broken database should not hide the others
+				log.Printf("Error collecting metrics for database %s: %v", dbName, err)
+				continue
+			}
+			if !open {
+				continue // Closed or still opening
+			}
+			c = cachedDatabaseStats{stats: stats, collectedAt: now}
+		}
+		cached[dbName] = c
+	}
+	m.stats = cached
+
+	stats := make(map[string]DatabaseStats, len(cached))
+	for dbName, c := range cached {
+		stats[dbName] = c.stats
+	}
+	return stats
+}
+
+// OpenConnections returns how many databases are open and the SQLite
+// connections their pools hold
+func (s *DocumentStore) OpenConnections() (databases, connections int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range s.dbs {
+		select {
+		case <-h.ready:
+		default:
+			continue // Still opening
+		}
+		if h.db != nil {
+			databases++
+			connections += h.db.Stats().OpenConnections
+		}
+	}
+	return databases, connections
+}
+
+// Metrics serves the metrics in the Prometheus text format
+// GET /metrics
+func (a *API) Metrics(w http.ResponseWriter, r *http.Request) {
+	dbStats := a.metrics.collectDatabaseStats(a.store)
+
+	var queueDepth, documents []gaugeSample
+	for _, dbName := range slices.Sorted(maps.Keys(dbStats)) {
+		stats := dbStats[dbName]
+		for _, status := range slices.Sorted(maps.Keys(stats.QueueDepth)) {
+			queueDepth = append(queueDepth, gaugeSample{[]string{dbName, status}, float64(stats.QueueDepth[status])})
+		}
+		for _, tableName := range slices.Sorted(maps.Keys(stats.Documents)) {
+			documents = append(documents, gaugeSample{[]string{dbName, tableName}, float64(stats.Documents[tableName])})
+		}
+	}
+	databases, connections := a.store.OpenConnections()
+
+	w.Header().Set("Content-Type", metricsContentType)
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	a.metrics.httpRequests.write(out)
+	a.metrics.httpDuration.write(out)
+	a.metrics.searchDuration.write(out)
+	a.metrics.embedderDuration.write(out)
+	a.metrics.embedderErrors.write(out)
+	writeGauge(out, "llmdb_embedding_queue_depth", "Embedding jobs of open databases by database and status.",
+		[]string{"db", "status"}, queueDepth)
+	writeGauge(out, "llmdb_documents", "Documents of open databases by database and table, not counting chunks.",
+		[]string{"db", "table"}, documents)
+	writeGauge(out, "llmdb_open_databases", "Database files currently open.",
+		nil, []gaugeSample{{nil, float64(databases)}})
+	writeGauge(out, "llmdb_db_open_connections", "SQLite connections held open across all databases.",
+		nil, []gaugeSample{{nil, float64(connections)}})
+}
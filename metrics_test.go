@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricFamilyWrite(t *testing.T) {
+	counter := newCounter("test_total", "A counter.", "route")
+	counter.Inc(`/a"b`)
+	counter.Inc(`/a"b`)
+	counter.Inc("/c")
+
+	histogram := newHistogram("test_seconds", "A histogram.", []float64{0.1, 1}, "type")
+	histogram.Observe(0.05, "vector")
+	histogram.Observe(0.5, "vector")
+	histogram.Observe(2, "vector")
+
+	var out bytes.Buffer
+	counter.write(&out)
+	histogram.write(&out)
+
+	want := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{route="/a\"b"} 2
+test_total{route="/c"} 1
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{type="vector",le="0.1"} 1
+test_seconds_bucket{type="vector",le="1"} 2
+test_seconds_bucket{type="vector",le="+Inf"} 3
+test_seconds_sum{type="vector"} 2.55
+test_seconds_count{type="vector"} 3
+`
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	// Only one database stays open, so writing to kb closes cold
+	store.SetMaxOpenDatabases(1)
+	if err := store.StoreDocument("cold", "notes", &Document{ID: "c1", Content: "archived"}); err != nil {
+		t.Fatalf("StoreDocument failed: %v", err)
+	}
+
+	config := &Config{Features: map[string]bool{}}
+	metrics := NewMetrics()
+	embedders := metrics.InstrumentEmbedders(Embedders{DefaultEmbedderName: &batchEmbedder{}})
+	api := NewAPI(store, embedders[DefaultEmbedderName], config)
+	api.SetMetrics(metrics)
+	router := newRouter(api, config)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	for _, id := range []string{"n1", "n2"} {
+		if rec := do(http.MethodPost, "/db/kb/notes", `{"id": "`+id+`", "content": "release notes"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	do(http.MethodPost, "/db/kb/notes/search", `{"query": "release"}`)
+	do(http.MethodPost, "/db/kb/notes/search", `{"query": "release", "type": "vector"}`)
+	if rec := do(http.MethodPost, "/db/kb/notes/search", `{"query": "fail", "type": "vector"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failing embedder: status = %d", rec.Code)
+	}
+	do(http.MethodGet, "/db/kb/notes/missing", "")
+
+	rec := do(http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != metricsContentType {
+		t.Fatalf("status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		`llmdb_http_requests_total{route="/db/{dbName}/{tableName}",method="POST",status="201"} 2`,
+		`llmdb_http_requests_total{route="/db/{dbName}/{tableName}/{docId}",method="GET",status="404"} 1`,
+		`llmdb_http_request_duration_seconds_count{route="/db/{dbName}/{tableName}/search",method="POST",status="200"} 2`,
+		`llmdb_search_duration_seconds_count{type="fulltext"} 1`,
+		`llmdb_search_duration_seconds_count{type="vector"} 2`,
+		`llmdb_embedder_request_duration_seconds_count{embedder="default",operation="embed"} 2`,
+		`llmdb_embedder_errors_total{embedder="default",operation="embed"} 1`,
+		`llmdb_embedding_queue_depth{db="kb",status="pending"} 2`,
+		`llmdb_embedding_queue_depth{db="kb",status="dead"} 0`,
+		`llmdb_documents{db="kb",table="notes"} 2`,
+		`llmdb_open_databases 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics are missing %s", line)
+		}
+	}
+	if !strings.Contains(body, "# TYPE llmdb_db_open_connections gauge\nllmdb_db_open_connections ") {
+		t.Errorf("metrics are missing the connection count:\n%s", body)
+	}
+	if strings.Contains(body, `db="cold"`) {
+		t.Errorf("closed databases should not be counted:\n%s", body)
+	}
+
+	// Counts are reused between scrapes
+	do(http.MethodPost, "/db/kb/notes", `{"id": "n3", "content": "release notes"}`)
+	if body := do(http.MethodGet, "/metrics", "").Body.String(); !strings.Contains(body, `llmdb_documents{db="kb",table="notes"} 2`+"\n") {
+		t.Errorf("document counts should be cached:\n%s", body)
+	}
+}
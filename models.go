@@ -14,6 +14,19 @@ type Document struct {
 	CreatedAt  time.Time              `json:"created_at"`
 	UpdatedAt  time.Time              `json:"updated_at"`
 	IsEmbedded bool                   `json:"is_embedded"`
+	Version    int                    `json:"version,omitempty"` // Set when a specific version was requested
+
+	Chunk      *ChunkRef   `json:"chunk,omitempty"`       // Set on chunks of a longer document
+	ChunkCount int         `json:"chunk_count,omitempty"` // Number of chunks the document was split into
+	Chunks     []*Document `json:"-"`                     // Chunks to store along with the document
+
+	removedChunks []string // Chunk IDs deleted by the last write, for the vector index
+}
+
+// ChunkRef places a chunk within the document it was split from
+type ChunkRef struct {
+	ParentID string `json:"parent_id"`
+	Index    int    `json:"index"` // 0-based position within the parent
 }
 
 // StoreDocumentRequest represents the request to store a document
@@ -22,6 +35,114 @@ type StoreDocumentRequest struct {
 	Content  string                 `json:"content"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 	Tags     []string               `json:"tags,omitempty"`
+	Chunking *ChunkingOptions       `json:"chunking,omitempty"` // Overrides the table's chunking setting
+}
+
+// DocumentVersion is a superseded version of a document, kept while its
+// table has history enabled
+type DocumentVersion struct {
+	Version    int                    `json:"version"`
+	Content    string                 `json:"content"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Tags       []string               `json:"tags,omitempty"`
+	IsEmbedded bool                   `json:"is_embedded"` // The version's vector was kept too
+	UpdatedAt  time.Time              `json:"updated_at"`  // When the version was written
+	ArchivedAt time.Time              `json:"archived_at"` // When it was overwritten or deleted
+	Deleted    bool                   `json:"deleted"`     // Archived by deleting the document
+}
+
+// DocumentHistory lists the superseded versions of a document
+type DocumentHistory struct {
+	ID             string            `json:"id"`
+	CurrentVersion int               `json:"current_version"` // 0 when the document was deleted
+	Versions       []DocumentVersion `json:"versions"`        // Newest first
+	Count          int               `json:"count"`
+}
+
+// RestoreDocumentRequest selects the version to make current again
+type RestoreDocumentRequest struct {
+	Version int `json:"version"`
+}
+
+// TableSettings holds the per-table configuration
+// A table gets the default settings on its first write.
+type TableSettings struct {
+	Dimensions int              `json:"dimensions,omitempty"` // Vector length, recorded from the first vector stored when unset
+	Metric     string           `json:"metric"`               // "cosine", "euclidean" or "dot" (default cosine)
+	Embedder   string           `json:"embedder"`             // Name of a configured embedder (default "default")
+	Tokenizer  string           `json:"tokenizer"`            // FTS5 tokenizer: "porter unicode61" stems, "trigram" matches substrings (default unicode61)
+	Weights    *FieldWeights    `json:"weights"`              // bm25 weight of each indexed field in full-text ranking
+	Chunking   *ChunkingOptions `json:"chunking,omitempty"`   // Split stored documents into chunks (default none)
+	History    bool             `json:"history,omitempty"`    // Keep every superseded version of a document (default off)
+}
+
+// FieldWeights weighs matches in each field indexed for full-text search
+// The title comes from the "title" metadata key. Unset weights fall back to
+// 1.0, a weight of 0 leaves the field out of the ranking.
+type FieldWeights struct {
+	Title   *float64 `json:"title"`
+	Content *float64 `json:"content"`
+	Tags    *float64 `json:"tags"`
+}
+
+// BulkItemResult reports the outcome of one line of a bulk upload
+type BulkItemResult struct {
+	Line       int    `json:"line"` // 1-based line number in the request body
+	ID         string `json:"id,omitempty"`
+	Status     int    `json:"status"` // HTTP status for this document (201, 400 or 500)
+	IsEmbedded bool   `json:"is_embedded,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BulkResponse summarizes a bulk upload
+type BulkResponse struct {
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+// ExportedDocument is one line of a table export or import
+// Vector is the base64-encoded little-endian float32 array, omitted when not exported
+type ExportedDocument struct {
+	ID         string                 `json:"id"`
+	Content    string                 `json:"content"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	Vector     string                 `json:"vector,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	IsEmbedded bool                   `json:"is_embedded"`
+	ParentID   string                 `json:"parent_id,omitempty"` // Set on chunks
+	ChunkIndex int                    `json:"chunk_index,omitempty"`
+	ChunkCount int                    `json:"chunk_count,omitempty"` // Set on chunked documents
+}
+
+// ImportSummary counts what an import did with each document
+type ImportSummary struct {
+	Imported    int `json:"imported"`    // New documents
+	Overwritten int `json:"overwritten"` // Existing documents replaced (on_conflict=overwrite)
+	Skipped     int `json:"skipped"`     // Existing documents left untouched (on_conflict=skip)
+}
+
+// EmbeddingJob tracks a document waiting for its embedding
+type EmbeddingJob struct {
+	DB            string    `json:"db"`
+	Table         string    `json:"table"`
+	DocID         string    `json:"doc_id"`
+	Status        string    `json:"status"` // "pending" or "dead"
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RetryJobsRequest selects dead embedding jobs to re-queue
+// Empty fields match everything
+type RetryJobsRequest struct {
+	Table string   `json:"table,omitempty"`
+	IDs   []string `json:"ids,omitempty"`
 }
 
 // SearchRequest represents a search query
@@ -30,6 +151,69 @@ type SearchRequest struct {
 	Type    SearchType             `json:"type"` // "vector", "fulltext", or "hybrid"
 	Limit   int                    `json:"limit,omitempty"`
 	Filters map[string]interface{} `json:"filters,omitempty"`
+	Hybrid  *HybridOptions         `json:"hybrid,omitempty"` // Only used when type is "hybrid"
+
+	// Vector search tuning (vector and hybrid types)
+	EfSearch int  `json:"ef_search,omitempty"` // HNSW beam width, higher trades speed for recall (default 64)
+	Exact    bool `json:"exact,omitempty"`     // Bypass the HNSW index and compare every stored vector
+
+	Collapse bool `json:"collapse,omitempty"` // Return chunked documents once, with their best chunk
+
+	Highlight *HighlightOptions `json:"highlight,omitempty"` // Add highlight and snippet fields to the results
+
+	Diversify *DiversifyOptions `json:"diversify,omitempty"` // Re-rank vector results to avoid near-duplicates
+
+	Rerank *RerankOptions `json:"rerank,omitempty"` // Reorder the results with the configured reranker
+}
+
+// VectorOptions returns the vector search options requested by the client
+func (r *SearchRequest) VectorOptions() VectorSearchOptions {
+	return VectorSearchOptions{
+		EfSearch: r.EfSearch,
+		Exact:    r.Exact,
+	}
+}
+
+// DiversifyOptions re-ranks vector search results so near-duplicates do not
+// crowd out other relevant documents
+// Unset fields fall back to the defaults noted on each field
+type DiversifyOptions struct {
+	Method string   `json:"method,omitempty"`  // Only "mmr" (maximal marginal relevance) is supported
+	Lambda *float64 `json:"lambda,omitempty"`  // Relevance vs. diversity, 1 ranks by relevance only, 0 by diversity only (default 0.5)
+	FetchK int      `json:"fetch_k,omitempty"` // Candidates re-ranked, at most 1000 (default 4x limit)
+}
+
+// RerankOptions controls the rerank stage of a search
+// The reranker's relevance score replaces the retrieval score of each result.
+type RerankOptions struct {
+	TopN int `json:"top_n,omitempty"` // Candidates reranked, at least limit and at most 200 (default 4x limit)
+}
+
+// FederatedSearchRequest searches several tables as one
+// Results carry the database and table they came from in their document.
+type FederatedSearchRequest struct {
+	SearchRequest
+	Databases []string `json:"databases,omitempty"` // Databases to search with POST /_search, empty or "*" for all
+	Tables    []string `json:"tables,omitempty"`    // Tables to search in each database, empty or "*" for all
+}
+
+// HybridOptions tunes how hybrid search fuses full-text and vector results
+// Unset fields fall back to the defaults noted on each field. A weight of 0
+// turns its side off.
+type HybridOptions struct {
+	FullTextWeight *float64 `json:"fulltext_weight,omitempty"` // Weight of the full-text ranking (default 1.0)
+	VectorWeight   *float64 `json:"vector_weight,omitempty"`   // Weight of the vector ranking (default 1.0)
+	Candidates     int      `json:"candidates,omitempty"`      // Candidates fetched from each side (default 4x limit)
+	RRFConstant    int      `json:"rrf_k,omitempty"`           // Reciprocal rank fusion constant (default 60)
+}
+
+// HighlightOptions controls the highlight and snippet of search results
+// Zero values fall back to the defaults noted on each field
+type HighlightOptions struct {
+	PreTag        string `json:"pre_tag,omitempty"`        // Inserted before each match (default "<mark>")
+	PostTag       string `json:"post_tag,omitempty"`       // Inserted after each match (default "</mark>")
+	Ellipsis      string `json:"ellipsis,omitempty"`       // Marks text left out of a snippet (default "…")
+	SnippetTokens int    `json:"snippet_tokens,omitempty"` // Tokens in a full-text snippet, at most 64 (default 16)
 }
 
 // SearchType defines the type of search to perform
@@ -46,6 +230,21 @@ type SearchResult struct {
 	Document Document `json:"document"`
 	Score    float64  `json:"score"`
 	Rank     int      `json:"rank,omitempty"`
+
+	BestChunk *ChunkMatch `json:"best_chunk,omitempty"` // Set when chunks were collapsed into Document
+
+	// Set when highlighting was requested: the content with the matches
+	// marked, and the passage that matched best
+	Highlight string `json:"highlight,omitempty"`
+	Snippet   string `json:"snippet,omitempty"`
+}
+
+// ChunkMatch is the best-scoring chunk of a collapsed search result
+type ChunkMatch struct {
+	ID      string  `json:"id"`
+	Index   int     `json:"index"`
+	Content string  `json:"content"`
+	Score   float64 `json:"score"`
 }
 
 // SearchResponse represents the search results
@@ -57,6 +256,36 @@ type SearchResponse struct {
 	Total   int            `json:"total"`
 }
 
+// ContextRequest asks for a context block assembled from search results
+// Without a limit, 20 results are retrieved; collapse and highlight are ignored.
+type ContextRequest struct {
+	SearchRequest
+	MaxTokens      int      `json:"max_tokens,omitempty"`      // Token budget of the context (default 2048)
+	MetadataFields []string `json:"metadata_fields,omitempty"` // Metadata shown in each source header (default ["title"])
+}
+
+// ContextResponse is a context block ready to paste into a prompt
+type ContextResponse struct {
+	Context   string     `json:"context"`   // Numbered sources, each a header line followed by its passages
+	Citations []Citation `json:"citations"` // The sources, numbered as in the context
+	Query     string     `json:"query"`
+	Tokens    int        `json:"tokens"` // Tokens used by the context
+	MaxTokens int        `json:"max_tokens"`
+	Truncated bool       `json:"truncated"` // Some retrieved text did not fit the budget
+}
+
+// Citation identifies a source of an assembled context
+type Citation struct {
+	Index     int                    `json:"index"` // Number of the source in the context, from 1
+	ID        string                 `json:"id"`    // Document ID, the parent document for chunks
+	DB        string                 `json:"db"`
+	Table     string                 `json:"table"`
+	Chunks    []int                  `json:"chunks,omitempty"` // Chunk indexes included, for chunked documents
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Score     float64                `json:"score"`               // Score of the best passage
+	Truncated bool                   `json:"truncated,omitempty"` // The passages were trimmed to fit the budget
+}
+
 // DBInfo represents information about a database
 type DBInfo struct {
 	Name          string    `json:"name"`
@@ -71,4 +300,5 @@ type DBInfo struct {
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
+	Field   string `json:"field,omitempty"` // Offending filter key for invalid filters
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// OpenAIEmbedder calls a service implementing the OpenAI /v1/embeddings API
+// This covers OpenAI itself as well as LM Studio, vLLM, Ollama and llama.cpp
+// started with --embeddings
+type OpenAIEmbedder struct {
+	endpoint   string // Full URL of the embeddings endpoint
+	model      string
+	apiKey     string
+	dimensions int // Requested from the service only when set, many models reject the parameter
+	batchSize  int // Maximum texts sent in one request by EmbedBatch
+	client     *http.Client
+}
+
+// NewOpenAIEmbedder creates an embedder for an OpenAI-compatible service
+// baseURL may be given with or without the trailing /v1
+func NewOpenAIEmbedder(baseURL, model, apiKey string, dimensions, batchSize int, insecureSkipVerify bool, caCertPath string) (*OpenAIEmbedder, error) {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+	if model == "" {
+		return nil, fmt.Errorf("embedding_model is required for the openai provider")
+	}
+
+	client, err := newHTTPClient(insecureSkipVerify, caCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(endpoint, "/v1") {
+		endpoint += "/v1"
+	}
+
+	return &OpenAIEmbedder{
+		endpoint:   endpoint + "/embeddings",
+		model:      model,
+		apiKey:     apiKey,
+		dimensions: dimensions,
+		batchSize:  batchSize,
+		client:     client,
+	}, nil
+}
+
+// openAIEmbeddingRequest is the /v1/embeddings request body
+type openAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+// openAIEmbeddingResponse is the /v1/embeddings response body
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.request(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (e *OpenAIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, e.batchSize, e.request)
+}
+
+// request embeds texts with a single call to the embeddings endpoint
+func (e *OpenAIEmbedder) request(ctx context.Context, texts []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(openAIEmbeddingRequest{
+		Model:          e.model,
+		Input:          texts,
+		Dimensions:     e.dimensions,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embedding service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var embeddingResp openAIEmbeddingResponse
+	parseErr := json.Unmarshal(body, &embeddingResp)
+
+	if resp.StatusCode != http.StatusOK {
+		if parseErr == nil && embeddingResp.Error != nil {
+			return nil, fmt.Errorf("embedding service returned status %d: %s", resp.StatusCode, embeddingResp.Error.Message)
+		}
+		return nil, fmt.Errorf("embedding service returned status %d: %s", resp.StatusCode, string(body))
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", parseErr)
+	}
+	if len(embeddingResp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddingResp.Data))
+	}
+
+	// The API does not promise that data is ordered like the input
+	sort.Slice(embeddingResp.Data, func(i, j int) bool {
+		return embeddingResp.Data[i].Index < embeddingResp.Data[j].Index
+	})
+
+	vectors := make([][]float32, len(texts))
+	for i, item := range embeddingResp.Data {
+		if item.Index != i {
+			return nil, fmt.Errorf("embedding response is missing index %d", i)
+		}
+		vector, err := e.fitDimensions(item.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+
+	return vectors, nil
+}
+
+// fitDimensions truncates a vector to the configured dimensions for services
+// that ignore the dimensions parameter. Truncated vectors are re-normalized,
+// which is how Matryoshka embeddings are meant to be shortened.
+func (e *OpenAIEmbedder) fitDimensions(vector []float32) ([]float32, error) {
+	if e.dimensions <= 0 || len(vector) == e.dimensions {
+		return vector, nil
+	}
+	if len(vector) < e.dimensions {
+		return nil, fmt.Errorf("expected embedding dimension %d, got %d", e.dimensions, len(vector))
+	}
+
+	truncated := vector[:e.dimensions]
+	var norm float64
+	for _, v := range truncated {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range truncated {
+			truncated[i] *= scale
+		}
+	}
+	return truncated, nil
+}
+
+func (e *OpenAIEmbedder) Dimensions() int {
+	return e.dimensions
+}
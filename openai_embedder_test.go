@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIEmbedder(t *testing.T) {
+	var lastRequest openAIEmbeddingRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"message": "invalid api key"}}`))
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&lastRequest); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Answer in reverse order with 4-dimensional vectors, ignoring the
+		// requested dimensions like many local servers do
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		for i := len(lastRequest.Input) - 1; i >= 0; i-- {
+			data = append(data, item{Index: i, Embedding: []float32{float32(i + 1), 0, 0, 1}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+	}))
+	defer server.Close()
+
+	t.Run("Batch request", func(t *testing.T) {
+		embedder, err := NewOpenAIEmbedder(server.URL, "test-model", "secret", 0, 2, false, "")
+		if err != nil {
+			t.Fatalf("NewOpenAIEmbedder failed: %v", err)
+		}
+
+		vectors, err := embedder.EmbedBatch(context.Background(), []string{"a", "b", "c"})
+		if err != nil {
+			t.Fatalf("EmbedBatch failed: %v", err)
+		}
+
+		// Batch size 2 splits the texts over two requests
+		if lastRequest.Model != "test-model" || strings.Join(lastRequest.Input, ",") != "c" {
+			t.Errorf("unexpected request %+v", lastRequest)
+		}
+		if lastRequest.Dimensions != 0 {
+			t.Errorf("dimensions = %d, want it omitted", lastRequest.Dimensions)
+		}
+		want := []float32{1, 2, 1}
+		for i, vector := range vectors {
+			if vector[0] != want[i] {
+				t.Errorf("vector %d = %v, results are out of order", i, vector)
+			}
+		}
+	})
+
+	t.Run("Dimensions truncation", func(t *testing.T) {
+		// A trailing /v1 in the base URL is accepted as well
+		embedder, err := NewOpenAIEmbedder(server.URL+"/v1/", "test-model", "secret", 2, 0, false, "")
+		if err != nil {
+			t.Fatalf("NewOpenAIEmbedder failed: %v", err)
+		}
+
+		vector, err := embedder.Embed(context.Background(), "a")
+		if err != nil {
+			t.Fatalf("Embed failed: %v", err)
+		}
+
+		if lastRequest.Dimensions != 2 {
+			t.Errorf("dimensions = %d, want 2", lastRequest.Dimensions)
+		}
+		if len(vector) != 2 || math.Abs(float64(vector[0])-1) > 1e-6 {
+			t.Errorf("vector = %v, want the normalized first 2 dimensions", vector)
+		}
+	})
+
+	t.Run("Service error", func(t *testing.T) {
+		embedder, err := NewOpenAIEmbedder(server.URL, "test-model", "wrong", 0, 0, false, "")
+		if err != nil {
+			t.Fatalf("NewOpenAIEmbedder failed: %v", err)
+		}
+
+		_, err = embedder.Embed(context.Background(), "a")
+		if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+			t.Errorf("got error %v, want the service message", err)
+		}
+	})
+
+	t.Run("Selected by configuration", func(t *testing.T) {
+		embedder, err := NewEmbedder(&Config{
+			EmbeddingProvider: EmbeddingProviderOpenAI,
+			EmbeddingURL:      server.URL,
+			EmbeddingModel:    "test-model",
+		})
+		if err != nil {
+			t.Fatalf("NewEmbedder failed: %v", err)
+		}
+		if _, ok := embedder.(*OpenAIEmbedder); !ok {
+			t.Errorf("got %T, want *OpenAIEmbedder", embedder)
+		}
+
+		if _, err := NewEmbedder(&Config{EmbeddingProvider: EmbeddingProviderOpenAI}); err == nil {
+			t.Error("expected an error without embedding_model")
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRerankCandidateRatio = 4   // Candidates reranked per requested result
+	maxRerankTopN               = 200 // Cross-encoders score every candidate against the query
+	defaultRerankBatchSize      = 32  // TEI's default --max-client-batch-size
+)
+
+// ErrRerankerNotConfigured is returned when a search asks for reranking but
+// no reranker_url is configured
+var ErrRerankerNotConfigured = errors.New("reranking is not configured")
+
+// Reranker is the interface for scoring texts by their relevance to a query,
+// typically with a cross-encoder model
+type Reranker interface {
+	// Rerank returns a relevance score for each text, in input order (higher is better)
+	Rerank(ctx context.Context, query string, texts []string) ([]float64, error)
+}
+
+// NewReranker creates the reranker selected by the configuration, or nil
+// when reranking is not configured
+func NewReranker(config *Config) (Reranker, error) {
+	if config.RerankerURL == "" {
+		return nil, nil
+	}
+	return NewHTTPReranker(config.RerankerURL, config.RerankerModel, config.RerankerAPIKey, config.RerankerBatchSize,
+		config.InsecureSkipVerify, config.CACertPath)
+}
+
+// HTTPReranker calls a /rerank endpoint as served by Hugging Face
+// text-embeddings-inference (TEI) and llama.cpp started with --reranking
+type HTTPReranker struct {
+	endpoint  string // Full URL of the rerank endpoint
+	model     string
+	apiKey    string
+	batchSize int // Texts per request, servers reject larger batches
+	client    *http.Client
+}
+
+// NewHTTPReranker creates a reranker for a TEI or llama.cpp server
+// baseURL may be given with or without the trailing /rerank; batchSize <= 0
+// selects the default.
+func NewHTTPReranker(baseURL, model, apiKey string, batchSize int, insecureSkipVerify bool, caCertPath string) (*HTTPReranker, error) {
+	client, err := newHTTPClient(insecureSkipVerify, caCertPath)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultRerankBatchSize
+	}
+
+	endpoint := strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(endpoint, "/rerank") {
+		endpoint += "/rerank"
+	}
+
+	return &HTTPReranker{
+		endpoint:  endpoint,
+		model:     model,
+		apiKey:    apiKey,
+		batchSize: batchSize,
+		client:    client,
+	}, nil
+}
+
+// rerankRequest is the /rerank request body. TEI reads the texts field and
+// llama.cpp the documents field, so both are sent.
+type rerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Texts     []string `json:"texts"`
+	Documents []string `json:"documents"`
+}
+
+// rerankScore is one scored text of a /rerank response
+// TEI names the score "score", llama.cpp "relevance_score".
+type rerankScore struct {
+	Index          int      `json:"index"`
+	Score          *float64 `json:"score"`
+	RelevanceScore *float64 `json:"relevance_score"`
+}
+
+// Rerank scores texts in requests of at most batchSize texts each
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, texts []string) ([]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	scores := make([]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += r.batchSize {
+		end := min(start+r.batchSize, len(texts))
+		batch, err := r.rerankBatch(ctx, query, texts[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to rerank texts %d-%d: %w", start, end-1, err)
+		}
+		// Indexes in a response are relative to its batch
+		scores = append(scores, batch...)
+	}
+
+	return scores, nil
+}
+
+// rerankBatch scores texts with one request
+func (r *HTTPReranker) rerankBatch(ctx context.Context, query string, texts []string) ([]float64, error) {
+	jsonData, err := json.Marshal(rerankRequest{
+		Model:     r.model,
+		Query:     query,
+		Texts:     texts,
+		Documents: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call rerank service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return parseRerankScores(body, len(texts))
+}
+
+// parseRerankScores understands the response shapes of rerank servers:
+//   - [{"index": 0, "score": 0.9}, ...]                          TEI
+//   - {"results": [{"index": 0, "relevance_score": 0.9}, ...]}   llama.cpp
+//
+// Entries may come in any order; every text must be scored exactly once.
+func parseRerankScores(body []byte, n int) ([]float64, error) {
+	var entries []rerankScore
+	if err := json.Unmarshal(body, &entries); err != nil {
+		var wrapped struct {
+			Results []rerankScore `json:"results"`
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			preview := string(body)
+			if len(preview) > 200 {
+				preview = preview[:200] + "..."
+			}
+			return nil, fmt.Errorf("failed to parse rerank response. Preview: %s", preview)
+		}
+		entries = wrapped.Results
+	}
+
+	if len(entries) != n {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", n, len(entries))
+	}
+
+	scores := make([]float64, n)
+	seen := make([]bool, n)
+	for _, entry := range entries {
+		if entry.Index < 0 || entry.Index >= n || seen[entry.Index] {
+			return nil, fmt.Errorf("unexpected rerank index %d", entry.Index)
+		}
+		switch {
+		case entry.Score != nil:
+			scores[entry.Index] = *entry.Score
+		case entry.RelevanceScore != nil:
+			scores[entry.Index] = *entry.RelevanceScore
+		default:
+			return nil, fmt.Errorf("rerank response has no score for index %d", entry.Index)
+		}
+		seen[entry.Index] = true
+	}
+
+	return scores, nil
+}
+
+// normalizeRerankOptions fills in defaults for unset rerank options
+func normalizeRerankOptions(opts RerankOptions, limit int) RerankOptions {
+	if opts.TopN == 0 {
+		opts.TopN = min(limit*defaultRerankCandidateRatio, maxRerankTopN)
+	}
+	opts.TopN = max(opts.TopN, limit)
+	return opts
+}
+
+// Validate checks rerank options
+func (o *RerankOptions) Validate() error {
+	if o.TopN < 0 || o.TopN > maxRerankTopN {
+		return fmt.Errorf("rerank top_n must be between 1 and %d", maxRerankTopN)
+	}
+	return nil
+}
+
+// rerankResults reorders the first topN results by their reranker score,
+// which replaces their retrieval score. Results past topN are dropped, as
+// their scores are not comparable with the reranked ones.
+func (a *API) rerankResults(ctx context.Context, query string, results []SearchResult, topN int) ([]SearchResult, error) {
+	if a.reranker == nil {
+		return nil, ErrRerankerNotConfigured
+	}
+	if len(results) > topN {
+		results = results[:topN]
+	}
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	texts := make([]string, len(results))
+	for i, result := range results {
+		texts[i] = result.Document.Content
+	}
+
+	rerankCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	scores, err := a.reranker.Rerank(rerankCtx, query, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(results) {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", len(results), len(scores))
+	}
+
+	for i := range results {
+		results[i].Score = scores[i]
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results, nil
+}
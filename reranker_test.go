@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newFakeRerankServer scores texts by how often they contain the query and
+// answers in the TEI or llama.cpp response format
+func newFakeRerankServer(t *testing.T, llamaCpp bool) (*httptest.Server, *[]rerankRequest) {
+	t.Helper()
+	var requests []rerankRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rerank" {
+			http.NotFound(w, r)
+			return
+		}
+		var req rerankRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+
+		// Answer out of order, as servers sort by score
+		var scores []map[string]interface{}
+		for i := len(req.Texts) - 1; i >= 0; i-- {
+			score := float64(strings.Count(strings.ToLower(req.Texts[i]), req.Query))
+			if llamaCpp {
+				scores = append(scores, map[string]interface{}{"index": i, "relevance_score": score})
+			} else {
+				scores = append(scores, map[string]interface{}{"index": i, "score": score})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if llamaCpp {
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": scores})
+		} else {
+			json.NewEncoder(w).Encode(scores)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestHTTPReranker(t *testing.T) {
+	for _, llamaCpp := range []bool{false, true} {
+		server, requests := newFakeRerankServer(t, llamaCpp)
+
+		reranker, err := NewHTTPReranker(server.URL+"/", "bge-reranker", "", 2, false, "")
+		if err != nil {
+			t.Fatalf("NewHTTPReranker failed: %v", err)
+		}
+
+		scores, err := reranker.Rerank(context.Background(), "go", []string{"rust", "go go", "go"})
+		if err != nil {
+			t.Fatalf("Rerank (llama.cpp=%v) failed: %v", llamaCpp, err)
+		}
+		if len(scores) != 3 || scores[0] != 0 || scores[1] != 2 || scores[2] != 1 {
+			t.Errorf("scores (llama.cpp=%v) = %v, want [0 2 1]", llamaCpp, scores)
+		}
+
+		// Texts are sent in batches of 2 and scored by their index in the input
+		if len(*requests) != 2 {
+			t.Fatalf("got %d requests, want 2 batches", len(*requests))
+		}
+		req := (*requests)[0]
+		if req.Model != "bge-reranker" || len(req.Documents) != 2 || len(req.Texts) != 2 || len((*requests)[1].Texts) != 1 {
+			t.Errorf("requests = %+v", *requests)
+		}
+	}
+}
+
+func TestParseRerankScores(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"TEI", `[{"index": 1, "score": 0.2}, {"index": 0, "score": 0.9}]`, false},
+		{"llama.cpp", `{"results": [{"index": 0, "relevance_score": 0.9}, {"index": 1, "relevance_score": 0.2}]}`, false},
+		{"Missing text", `[{"index": 0, "score": 0.9}]`, true},
+		{"Duplicate index", `[{"index": 0, "score": 0.9}, {"index": 0, "score": 0.2}]`, true},
+		{"Missing score", `[{"index": 0, "score": 0.9}, {"index": 1}]`, true},
+		{"Not JSON", `upstream error`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores, err := parseRerankScores([]byte(tt.body), 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRerankScores() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (scores[0] != 0.9 || scores[1] != 0.2) {
+				t.Errorf("scores = %v", scores)
+			}
+		})
+	}
+}
+
+func TestSearchRerank(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "notes"
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{}})
+
+	for _, doc := range []*Document{
+		{ID: "once", Content: "Deploy the service today"},
+		{ID: "thrice", Content: "Deploy, deploy and deploy again"},
+		{ID: "twice", Content: "Deploy first, then deploy the workers"},
+	} {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+	}
+
+	search := func(t *testing.T, body string) (*httptest.ResponseRecorder, SearchResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/notes/search", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.SearchDocuments(rec, req)
+
+		var resp SearchResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	if rec, _ := search(t, `{"query": "deploy", "rerank": {}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a reranker: status = %d, want 400", rec.Code)
+	}
+
+	server, requests := newFakeRerankServer(t, false)
+	reranker, err := NewHTTPReranker(server.URL, "", "", 0, false, "")
+	if err != nil {
+		t.Fatalf("NewHTTPReranker failed: %v", err)
+	}
+	api.SetReranker(reranker)
+
+	rec, resp := search(t, `{"query": "deploy", "limit": 2, "rerank": {}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Document.ID != "thrice" || resp.Results[1].Document.ID != "twice" {
+		t.Errorf("results = %+v", resp.Results)
+	}
+	if resp.Results[0].Score != 3 || resp.Results[0].Rank != 1 {
+		t.Errorf("top result should carry the rerank score: %+v", resp.Results[0])
+	}
+	if got := len((*requests)[0].Texts); got != 3 {
+		t.Errorf("reranked %d candidates, want all 3", got)
+	}
+
+	// top_n limits the candidates sent to the reranker
+	search(t, `{"query": "deploy", "limit": 1, "rerank": {"top_n": 2}}`)
+	if got := len((*requests)[1].Texts); got != 2 {
+		t.Errorf("reranked %d candidates, want 2", got)
+	}
+
+	if rec, _ := search(t, `{"query": "deploy", "rerank": {"top_n": 500}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("top_n 500: status = %d, want 400", rec.Code)
+	}
+}
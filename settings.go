@@ -0,0 +1,353 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tableSettingsSchema stores the settings of each document table as JSON
+const tableSettingsSchema = `
+	CREATE TABLE IF NOT EXISTS _table_settings (
+		table_name TEXT PRIMARY KEY,
+		settings TEXT NOT NULL
+	);
+`
+
+// Distance metrics for vector search
+const (
+	MetricCosine    = "cosine"
+	MetricEuclidean = "euclidean"
+	MetricDot       = "dot"
+)
+
+const defaultTokenizer = "unicode61"
+
+// ErrDimensionMismatch is returned when a vector does not have the
+// dimensions recorded for its table
+var ErrDimensionMismatch = errors.New("vector dimension mismatch")
+
+// ErrEmbedderInUse is returned when the embedder of a table holding vectors
+// is changed, its vectors would not be comparable with new ones
+var ErrEmbedderInUse = errors.New("embedder in use")
+
+// ErrInvalidTokenizer is returned when FTS5 rejects the tokenizer options
+var ErrInvalidTokenizer = errors.New("invalid tokenizer")
+
+// fts5Tokenizers are the built-in FTS5 tokenizers; porter wraps another one
+var fts5Tokenizers = map[string]bool{"unicode61": true, "ascii": true, "porter": true, "trigram": true}
+
+// tokenizerPattern keeps tokenizer options safe to embed in the FTS5 table definition
+var tokenizerPattern = regexp.MustCompile(`^[a-z0-9_ ]+$`)
+
+// setDefaults fills in the fields left empty
+func (t *TableSettings) setDefaults() {
+	if t.Metric == "" {
+		t.Metric = MetricCosine
+	}
+	if t.Embedder == "" {
+		t.Embedder = DefaultEmbedderName
+	}
+	if t.Tokenizer == "" {
+		t.Tokenizer = defaultTokenizer
+	}
+
+	if t.Weights == nil {
+		t.Weights = &FieldWeights{}
+	}
+	for _, weight := range []**float64{&t.Weights.Title, &t.Weights.Content, &t.Weights.Tags} {
+		if *weight == nil {
+			defaultWeight := 1.0
+			*weight = &defaultWeight
+		}
+	}
+}
+
+// Validate checks the settings and fills in defaults
+func (t *TableSettings) Validate() error {
+	t.setDefaults()
+
+	if t.Dimensions < 0 {
+		return fmt.Errorf("dimensions must not be negative")
+	}
+
+	switch t.Metric {
+	case MetricCosine, MetricEuclidean, MetricDot:
+	default:
+		return fmt.Errorf("invalid metric %q, must be cosine, euclidean or dot", t.Metric)
+	}
+
+	t.Tokenizer = strings.Join(strings.Fields(t.Tokenizer), " ")
+	if !tokenizerPattern.MatchString(t.Tokenizer) || !fts5Tokenizers[strings.Fields(t.Tokenizer)[0]] {
+		return fmt.Errorf("invalid tokenizer %q, must start with unicode61, ascii, porter or trigram", t.Tokenizer)
+	}
+
+	if *t.Weights.Title < 0 || *t.Weights.Content < 0 || *t.Weights.Tags < 0 {
+		return fmt.Errorf("field weights must not be negative")
+	}
+
+	if t.Chunking != nil {
+		if err := t.Chunking.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultTableSettingsJSON is the settings record of a new table
+func defaultTableSettingsJSON() string {
+	var settings TableSettings
+	settings.setDefaults()
+	settingsJSON, _ := json.Marshal(settings)
+	return string(settingsJSON)
+}
+
+// readTableSettings reads the settings of a table with defaults filled in
+func readTableSettings(db sqlExecer, tableName string) (TableSettings, error) {
+	var settings TableSettings
+	var settingsJSON string
+	err := db.QueryRow(`SELECT settings FROM _table_settings WHERE table_name = ?`, tableName).Scan(&settingsJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, fmt.Errorf("failed to read table settings: %w", err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
+			return settings, fmt.Errorf("failed to unmarshal table settings: %w", err)
+		}
+	}
+	settings.setDefaults()
+	return settings, nil
+}
+
+// checkDimensions verifies that vectors have the dimensions recorded for a
+// table. The first vector stored in a table without recorded dimensions
+// sets them.
+func checkDimensions(db sqlExecer, tableName string, vectors ...[]float32) error {
+	dimensions := 0
+	for _, vector := range vectors {
+		if len(vector) == 0 {
+			continue
+		}
+
+		if dimensions == 0 {
+			// Recording and reading back in one go keeps concurrent first writes consistent
+			err := db.QueryRow(`
+				UPDATE _table_settings
+				SET settings = json_set(settings, '$.dimensions',
+					COALESCE(NULLIF(json_extract(settings, '$.dimensions'), 0), ?))
+				WHERE table_name = ?
+				RETURNING json_extract(settings, '$.dimensions')
+			`, len(vector), tableName).Scan(&dimensions)
+			if err == sql.ErrNoRows {
+				return nil // Table without a settings record
+			}
+			if err != nil {
+				return fmt.Errorf("failed to record vector dimensions: %w", err)
+			}
+		}
+
+		if len(vector) != dimensions {
+			return fmt.Errorf("%w: table %s expects %d dimensions, got %d",
+				ErrDimensionMismatch, tableName, dimensions, len(vector))
+		}
+	}
+	return nil
+}
+
+// GetTableSettings returns the settings of a table, the defaults if none were saved
+func (s *DocumentStore) GetTableSettings(dbId, tableName string) (TableSettings, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return TableSettings{}, err
+	}
+	defer release()
+
+	return readTableSettings(db, tableName)
+}
+
+// PutTableSettings replaces the settings of a table, creating the table if needed
+// Chunking applies to documents written afterwards. Zero dimensions keep the
+// recorded ones; they can only change while no vector has other dimensions.
+// The embedder can only change while the table holds no vectors. A new tokenizer rebuilds the full-text index and a new metric the vector
+// index. Enabling history archives documents overwritten or deleted from then
+// on. On success settings holds what was saved.
+func (s *DocumentStore) PutTableSettings(dbId, tableName string, settings *TableSettings) error {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.ensureTable(db, tableName); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := readTableSettings(tx, tableName)
+	if err != nil {
+		return err
+	}
+
+	if settings.Dimensions == 0 {
+		settings.Dimensions = current.Dimensions
+	}
+	if settings.Dimensions != current.Dimensions && settings.Dimensions != 0 {
+		var mismatched int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE vector IS NOT NULL AND length(vector) != ?`, tableName)
+		if err := tx.QueryRow(query, settings.Dimensions*4).Scan(&mismatched); err != nil {
+			return fmt.Errorf("failed to check stored vectors: %w", err)
+		}
+		if mismatched > 0 {
+			return fmt.Errorf("%w: table %s holds %d vectors with %d dimensions, delete or re-embed them before changing dimensions",
+				ErrDimensionMismatch, tableName, mismatched, current.Dimensions)
+		}
+	}
+
+	if settings.Embedder != current.Embedder {
+		var embedded int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE vector IS NOT NULL`, tableName)
+		if err := tx.QueryRow(query).Scan(&embedded); err != nil {
+			return fmt.Errorf("failed to check stored vectors: %w", err)
+		}
+		if embedded > 0 {
+			return fmt.Errorf("%w: table %s holds %d vectors embedded with %s, delete them before changing the embedder",
+				ErrEmbedderInUse, tableName, embedded, current.Embedder)
+		}
+	}
+
+	if settings.Tokenizer != current.Tokenizer {
+		if err := checkTokenizer(tx, tableName, settings.Tokenizer); err != nil {
+			return err
+		}
+		if err := rebuildFullTextTable(tx, tableName, settings.Tokenizer); err != nil {
+			return err
+		}
+	}
+
+	if settings.History != current.History {
+		if err := setHistoryTriggers(tx, tableName, settings.History); err != nil {
+			return err
+		}
+	}
+
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal table settings: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO _table_settings (table_name, settings) VALUES (?, ?)
+		ON CONFLICT(table_name) DO UPDATE SET settings = excluded.settings
+	`, tableName, string(settingsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save table settings: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit table settings: %w", err)
+	}
+
+	if settings.Metric != current.Metric {
+		s.dropVectorIndex(dbId, tableName)
+	}
+	return nil
+}
+
+// checkTokenizer creates the FTS table of a table with tokenizer inside a
+// savepoint that is always rolled back, so options FTS5 rejects surface as
+// ErrInvalidTokenizer instead of failing the rebuild
+func checkTokenizer(db sqlExecer, tableName, tokenizer string) error {
+	if _, err := db.Exec(`SAVEPOINT tokenizer`); err != nil {
+		return fmt.Errorf("failed to begin savepoint: %w", err)
+	}
+
+	var checkErr error
+	if _, err := db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s_fts"`, tableName)); err != nil {
+		checkErr = fmt.Errorf("failed to check tokenizer: %w", err)
+	} else if _, err := db.Exec(fullTextTableSQL(tableName, tokenizer)); err != nil {
+		checkErr = fmt.Errorf("%w %q: %v", ErrInvalidTokenizer, tokenizer, err)
+	}
+
+	// ROLLBACK TO leaves the savepoint open, RELEASE ends it
+	if _, err := db.Exec(`ROLLBACK TO tokenizer; RELEASE tokenizer`); err != nil {
+		if checkErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", checkErr, err)
+		}
+		return fmt.Errorf("failed to roll back tokenizer check: %w", err)
+	}
+	return checkErr
+}
+
+// GetTableSettings returns the settings of a table
+// GET /db/{dbName}/{tableName}/_settings
+func (a *API) GetTableSettings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+
+	if !isValidTableName(tableName) {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid table name: %s", tableName))
+		return
+	}
+
+	settings, err := a.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		a.errorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, settings)
+}
+
+// UpdateTableSettings replaces the settings of a table
+// PUT /db/{dbName}/{tableName}/_settings
+func (a *API) UpdateTableSettings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dbName := vars["dbName"]
+	tableName := vars["tableName"]
+
+	if !isValidTableName(tableName) {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid table name: %s", tableName))
+		return
+	}
+
+	var settings TableSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := a.embedders.Get(settings.Embedder); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.store.PutTableSettings(dbName, tableName, &settings); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrDimensionMismatch), errors.Is(err, ErrEmbedderInUse):
+			status = http.StatusConflict
+		case errors.Is(err, ErrInvalidTokenizer):
+			status = http.StatusBadRequest
+		}
+		a.errorResponse(w, status, fmt.Sprintf("failed to save table settings: %v", err))
+		return
+	}
+
+	a.jsonResponse(w, http.StatusOK, settings)
+}
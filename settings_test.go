@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTableSettingsValidate(t *testing.T) {
+	var defaults TableSettings
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if defaults.Metric != MetricCosine || defaults.Embedder != DefaultEmbedderName || defaults.Tokenizer != defaultTokenizer {
+		t.Errorf("defaults = %+v", defaults)
+	}
+
+	tests := []struct {
+		name     string
+		settings TableSettings
+		wantErr  bool
+	}{
+		{"Euclidean metric", TableSettings{Metric: MetricEuclidean}, false},
+		{"Tokenizer with options", TableSettings{Tokenizer: "unicode61  remove_diacritics 2"}, false},
+		{"Porter stemming", TableSettings{Tokenizer: "porter unicode61"}, false},
+		{"Unknown metric", TableSettings{Metric: "manhattan"}, true},
+		{"Unknown tokenizer", TableSettings{Tokenizer: "snowball"}, true},
+		{"Quoted tokenizer", TableSettings{Tokenizer: "ascii'); DROP TABLE notes; --"}, true},
+		{"Negative dimensions", TableSettings{Dimensions: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTableSettings(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	api := NewAPI(store, &batchEmbedder{}, &Config{Features: map[string]bool{"embedding": true}})
+	api.SetEmbedders(Embedders{DefaultEmbedderName: &batchEmbedder{}, "other": &batchEmbedder{}})
+
+	putSettings := func(t *testing.T, tableName, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/db/test_db/"+tableName+"/_settings", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": tableName})
+		rec := httptest.NewRecorder()
+		api.UpdateTableSettings(rec, req)
+		return rec
+	}
+
+	t.Run("Dimensions are recorded on first write", func(t *testing.T) {
+		if err := store.StoreDocument(dbName, "notes", &Document{ID: "a", Content: "a", Vector: []float32{1, 0, 0}}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		settings, err := store.GetTableSettings(dbName, "notes")
+		if err != nil || settings.Dimensions != 3 || settings.Metric != MetricCosine {
+			t.Fatalf("GetTableSettings = %+v, %v", settings, err)
+		}
+
+		err = store.StoreDocument(dbName, "notes", &Document{ID: "b", Content: "b", Vector: []float32{1, 0}})
+		if !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("StoreDocument with 2 dimensions = %v, want ErrDimensionMismatch", err)
+		}
+		if err := store.UpdateDocumentVector(dbName, "notes", "a", []float32{1, 0, 0, 0}); !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("UpdateDocumentVector with 4 dimensions = %v, want ErrDimensionMismatch", err)
+		}
+		if _, err := store.SearchVector(dbName, "notes", []float32{1, 0}, 5, nil, VectorSearchOptions{}); !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("SearchVector with 2 dimensions = %v, want ErrDimensionMismatch", err)
+		}
+
+		// Changing the dimensions would orphan the stored vector
+		if rec := putSettings(t, "notes", `{"dimensions": 4}`); rec.Code != http.StatusConflict {
+			t.Errorf("changing dimensions: status = %d, want 409", rec.Code)
+		}
+	})
+
+	t.Run("Embedded documents must match the table", func(t *testing.T) {
+		if rec := putSettings(t, "wide", `{"dimensions": 8}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/wide", strings.NewReader(`{"content": "hello"}`))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": "wide"})
+		rec := httptest.NewRecorder()
+		api.StoreDocument(rec, req)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "expects 8 dimensions, got 3") {
+			t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Search uses the table metric", func(t *testing.T) {
+		for _, doc := range []*Document{
+			{ID: "near", Content: "near", Vector: []float32{1, 0, 0}},
+			{ID: "long", Content: "long", Vector: []float32{3, 3, 0}},
+		} {
+			if err := store.StoreDocument(dbName, "points", doc); err != nil {
+				t.Fatalf("StoreDocument failed: %v", err)
+			}
+		}
+
+		topResult := func(t *testing.T, exact bool) string {
+			t.Helper()
+			results, err := store.SearchVector(dbName, "points", []float32{1, 0, 0}, 1, nil, VectorSearchOptions{Exact: exact})
+			if err != nil || len(results) != 1 {
+				t.Fatalf("SearchVector = %v, %v", results, err)
+			}
+			return results[0].Document.ID
+		}
+
+		if got := topResult(t, false); got != "near" {
+			t.Errorf("cosine top result = %s, want near", got)
+		}
+
+		if rec := putSettings(t, "points", `{"metric": "dot"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		for _, exact := range []bool{true, false} {
+			if got := topResult(t, exact); got != "long" {
+				t.Errorf("dot top result (exact=%v) = %s, want long", exact, got)
+			}
+		}
+		if idx, err := store.vectorIndex(dbName, "points"); err != nil || idx.metric != MetricDot {
+			t.Errorf("vector index should be rebuilt for the dot metric: %v", err)
+		}
+	})
+
+	t.Run("Changing the tokenizer rebuilds the full-text index", func(t *testing.T) {
+		if err := store.StoreDocument(dbName, "prose", &Document{ID: "doc", Content: "The service runs nightly"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if results, _ := store.SearchFullText(dbName, "prose", "run", 10, nil); len(results) != 0 {
+			t.Fatalf("unicode61 should not stem: %v", results)
+		}
+
+		if rec := putSettings(t, "prose", `{"tokenizer": "porter unicode61"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		results, err := store.SearchFullText(dbName, "prose", "run", 10, nil)
+		if err != nil || len(results) != 1 {
+			t.Errorf("porter search = %v, %v, want the stored document", results, err)
+		}
+
+		// Rows written after the rebuild are indexed too
+		if err := store.StoreDocument(dbName, "prose", &Document{ID: "later", Content: "Jobs running late"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if results, _ := store.SearchFullText(dbName, "prose", "run", 10, nil); len(results) != 2 {
+			t.Errorf("got %d results after a new write, want 2", len(results))
+		}
+
+		// Options FTS5 rejects fail the request and keep the index
+		if rec := putSettings(t, "prose", `{"tokenizer": "unicode61 stemmer 1"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid tokenizer option: status = %d, want 400: %s", rec.Code, rec.Body.String())
+		}
+		if settings, _ := store.GetTableSettings(dbName, "prose"); settings.Tokenizer != "porter unicode61" {
+			t.Errorf("tokenizer = %q, want porter unicode61", settings.Tokenizer)
+		}
+		if results, _ := store.SearchFullText(dbName, "prose", "run", 10, nil); len(results) != 2 {
+			t.Errorf("got %d results after a rejected tokenizer, want 2", len(results))
+		}
+	})
+
+	t.Run("Embedder cannot change while vectors are stored", func(t *testing.T) {
+		if err := store.StoreDocument(dbName, "embedded", &Document{ID: "a", Content: "a", Vector: []float32{1, 0, 0}}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if rec := putSettings(t, "embedded", `{"embedder": "other"}`); rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", rec.Code, rec.Body.String())
+		}
+		if settings, _ := store.GetTableSettings(dbName, "embedded"); settings.Embedder != DefaultEmbedderName {
+			t.Errorf("embedder = %q, want %q", settings.Embedder, DefaultEmbedderName)
+		}
+
+		if err := store.DeleteDocument(dbName, "embedded", "a"); err != nil {
+			t.Fatalf("DeleteDocument failed: %v", err)
+		}
+		if rec := putSettings(t, "embedded", `{"embedder": "other"}`); rec.Code != http.StatusOK {
+			t.Errorf("empty table: status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Title and tags are ranked with field weights", func(t *testing.T) {
+		for _, doc := range []*Document{
+			{ID: "titled", Content: "Cluster setup notes", Metadata: map[string]interface{}{"title": "Kubernetes"}},
+			{ID: "body", Content: "Kubernetes runs the cluster, Kubernetes schedules pods"},
+			{ID: "tagged", Content: "Pager rotation", Tags: []string{"oncall", "urgent"}},
+		} {
+			if err := store.StoreDocument(dbName, "wiki", doc); err != nil {
+				t.Fatalf("StoreDocument failed: %v", err)
+			}
+		}
+
+		topResult := func(t *testing.T, query string) string {
+			t.Helper()
+			results, err := store.SearchFullText(dbName, "wiki", query, 10, nil)
+			if err != nil || len(results) == 0 {
+				t.Fatalf("SearchFullText(%q) = %v, %v", query, results, err)
+			}
+			return results[0].Document.ID
+		}
+
+		if got := topResult(t, "kubernetes"); got != "body" {
+			t.Errorf("equal weights: top result = %s, want body", got)
+		}
+		if rec := putSettings(t, "wiki", `{"weights": {"title": 10}}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := topResult(t, "kubernetes"); got != "titled" {
+			t.Errorf("title weight 10: top result = %s, want titled", got)
+		}
+		if got := topResult(t, "tags:urgent"); got != "tagged" {
+			t.Errorf("tag search: top result = %s, want tagged", got)
+		}
+		if rec := putSettings(t, "wiki", `{"weights": {"title": 1, "content": 0}}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := topResult(t, "kubernetes"); got != "titled" {
+			t.Errorf("content weight 0: top result = %s, want titled", got)
+		}
+
+		// Retitling a document reindexes it
+		err := store.StoreDocument(dbName, "wiki", &Document{ID: "titled", Content: "Cluster setup notes", Metadata: map[string]interface{}{"title": "Nomad"}})
+		if err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if results, _ := store.SearchFullText(dbName, "wiki", "title:kubernetes", 10, nil); len(results) != 0 {
+			t.Errorf("old title still matches: %v", results)
+		}
+
+		if rec := putSettings(t, "wiki", `{"weights": {"tags": -1}}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("negative weight: status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("Trigram tokenizer matches substrings", func(t *testing.T) {
+		if err := store.StoreDocument(dbName, "snippets", &Document{ID: "fn", Content: "func parseConfigFile(path string)"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		if rec := putSettings(t, "snippets", `{"tokenizer": "trigram"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		results, err := store.SearchFullText(dbName, "snippets", "ConfigFi", 10, nil)
+		if err != nil || len(results) != 1 {
+			t.Errorf("substring search = %v, %v", results, err)
+		}
+	})
+
+	t.Run("Tables use their own embedder", func(t *testing.T) {
+		named := &batchEmbedder{}
+		api.SetEmbedders(Embedders{DefaultEmbedderName: &batchEmbedder{}, "small": named})
+
+		if rec := putSettings(t, "docs", `{"embedder": "missing"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("unknown embedder: status = %d, want 400", rec.Code)
+		}
+		if rec := putSettings(t, "docs", `{"embedder": "small"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/db/test_db/docs", strings.NewReader(`{"content": "hello"}`))
+		req = mux.SetURLVars(req, map[string]string{"dbName": dbName, "tableName": "docs"})
+		rec := httptest.NewRecorder()
+		api.StoreDocument(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if len(named.batches) != 1 {
+			t.Errorf("named embedder got %d batches, want 1", len(named.batches))
+		}
+	})
+}
@@ -6,10 +6,14 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,11 +23,31 @@ import (
 //go:embed schema.sql
 var schemaSQL string
 
+// defaultMaxOpenDatabases bounds the database files kept open at once
+// Idle databases beyond it are closed, least recently used first
+const defaultMaxOpenDatabases = 256
+
+// sqliteDSNParams are applied to every pooled connection. WAL lets readers
+// run alongside a writer, busy_timeout makes writers wait for each other
+// instead of failing with SQLITE_BUSY, and immediate transactions take the
+// write lock up front so the wait happens where busy_timeout applies.
+const sqliteDSNParams = "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+
 // DocumentStore manages document storage across databases
 // Each database gets its own SQLite database file
 type DocumentStore struct {
 	baseDir string
-	dbs     map[string]*sql.DB // db name -> db connection
+
+	mu      sync.Mutex           // Guards dbs, maxOpen and the handles in dbs
+	dbs     map[string]*dbHandle // db name -> db connection
+	maxOpen int
+
+	indexMu sync.Mutex
+	indexes map[string]*vectorIndexEntry // "db/table" -> HNSW vector index
+
+	jobsReady   chan struct{} // Signalled when embedding jobs are queued
+	jobsMu      sync.Mutex
+	pendingJobs map[string]bool // Databases that may have queued embedding jobs
 }
 
 // NewDocumentStore creates a new document store
@@ -34,43 +58,291 @@ func NewDocumentStore(baseDir string) (*DocumentStore, error) {
 
 	return &DocumentStore{
 		baseDir: baseDir,
-		dbs:     make(map[string]*sql.DB),
+		dbs:     make(map[string]*dbHandle),
+		maxOpen: defaultMaxOpenDatabases,
+		indexes: make(map[string]*vectorIndexEntry),
+
+		jobsReady:   make(chan struct{}, 1),
+		pendingJobs: make(map[string]bool),
 	}, nil
 }
 
+// dbHandle tracks an open database and the callers currently using it
+type dbHandle struct {
+	db       *sql.DB
+	ready    chan struct{} // Closed once opening finished
+	err      error         // Set when opening failed
+	refs     int           // Callers between getDB and release
+	lastUsed time.Time
+	deleting bool
+	drained  chan struct{} // Closed when refs drops to zero while deleting
+}
+
+// SetMaxOpenDatabases sets how many databases may stay open at once
+// Databases in use are never closed, so the limit can be exceeded briefly.
+func (s *DocumentStore) SetMaxOpenDatabases(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n > 0 {
+		s.maxOpen = n
+	}
+}
+
 // getDB returns the database connection for a database, creating it if needed
-func (s *DocumentStore) getDB(dbId string) (*sql.DB, error) {
-	// Check if we already have a connection
-	if db, exists := s.dbs[dbId]; exists {
-		return db, nil
+// The connection stays open until release is called, which callers must do
+// once they are done with it.
+func (s *DocumentStore) getDB(dbId string) (*sql.DB, func(), error) {
+	s.mu.Lock()
+	h, exists := s.dbs[dbId]
+	if exists && h.deleting {
+		s.mu.Unlock()
+		return nil, nil, fmt.Errorf("database %s is being deleted", dbId)
+	}
+
+	opening := !exists
+	if opening {
+		h = &dbHandle{ready: make(chan struct{})}
+		s.dbs[dbId] = h
+	}
+	h.refs++
+	var evicted map[string]*sql.DB
+	if opening {
+		evicted = s.evictIdleDatabases()
+	}
+	s.mu.Unlock()
+
+	for name, db := range evicted {
+		s.unloadVectorIndexes(name, db)
+		db.Close()
 	}
 
-	// Create new database file for this database
-	dbPath := filepath.Join(s.baseDir, fmt.Sprintf("%s.db", dbId))
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+	if opening {
+		h.db, h.err = s.openDB(dbId)
+		if h.err != nil {
+			s.mu.Lock()
+			if s.dbs[dbId] == h {
+				delete(s.dbs, dbId)
+			}
+			s.mu.Unlock()
+		}
+		close(h.ready)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	<-h.ready
+	if h.err != nil {
+		s.releaseDB(h)
+		return nil, nil, h.err
 	}
 
+	return h.db, func() { s.releaseDB(h) }, nil
+}
+
+// getOpenDB is getDB for databases that are already open: it returns a nil
+// connection instead of opening the database, or waiting for one still
+// opening, and callers skip it
+func (s *DocumentStore) getOpenDB(dbId string) (*sql.DB, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, exists := s.dbs[dbId]
+	if !exists || h.deleting {
+		return nil, nil
+	}
+	select {
+	case <-h.ready:
+	default:
+		return nil, nil
+	}
+	if h.db == nil {
+		return nil, nil // Opening failed
+	}
+
+	h.refs++
+	return h.db, func() { s.releaseDB(h) }
+}
+
+// releaseDB gives back a handle obtained from getDB
+func (s *DocumentStore) releaseDB(h *dbHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.refs--
+	h.lastUsed = time.Now()
+	if h.refs == 0 && h.drained != nil {
+		close(h.drained)
+	}
+}
+
+// evictIdleDatabases removes the least recently used idle databases while
+// more than maxOpen are open, returning their connections by name for the
+// caller to close outside the lock. Must be called with s.mu held.
+func (s *DocumentStore) evictIdleDatabases() map[string]*sql.DB {
+	evicted := make(map[string]*sql.DB)
+	for len(s.dbs) > s.maxOpen {
+		var oldestName string
+		var oldest *dbHandle
+		for name, h := range s.dbs {
+			if h.refs > 0 || h.deleting {
+				continue
+			}
+			if oldest == nil || h.lastUsed.Before(oldest.lastUsed) {
+				oldestName, oldest = name, h
+			}
+		}
+		if oldest == nil {
+			break // Everything is in use
+		}
+
+		delete(s.dbs, oldestName)
+		evicted[oldestName] = oldest.db
+	}
+	return evicted
+}
+
+// openDB opens the database file of a database and prepares its schema
+func (s *DocumentStore) openDB(dbId string) (*sql.DB, error) {
+	// Create new database file for this database
+	dbPath := filepath.Join(s.baseDir, fmt.Sprintf("%s.db", dbId))
+	// The connector makes vec_search available on every connection
+	db := sql.OpenDB(newVectorConnector(dbPath+sqliteDSNParams, s, dbId))
+
 	// Initialize schema
 	if err := s.initSchema(db); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
-	s.dbs[dbId] = db
 	return db, nil
 }
 
-// initSchema creates the necessary tables and indexes
+// schemaVersion is recorded in PRAGMA user_version once a database file has
+// been brought up to date. Bump it when the migrations below change.
+const schemaVersion = 1
+
+// initSchema creates the necessary tables and indexes and migrates tables
+// created by earlier versions, once per database file
 func (s *DocumentStore) initSchema(db *sql.DB) error {
-	// No default schema anymore - tables are created dynamically
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	// No default document schema anymore - tables are created dynamically
+	if _, err := db.Exec(tableSettingsSchema); err != nil {
+		return fmt.Errorf("failed to create table settings: %w", err)
+	}
+	if err := migrateDocumentTables(db); err != nil {
+		return err
+	}
+	if err := initEmbeddingJobs(db); err != nil {
+		return err
+	}
+	if err := initDocumentHistory(db); err != nil {
+		return err
+	}
+	if err := dropPersistedVectorTable(db); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
+
+// titleColumnSQL defines the title column, taken from the "title" metadata key
+const titleColumnSQL = `title TEXT GENERATED ALWAYS AS (json_extract(CASE WHEN json_valid(metadata) THEN metadata END, '$.title')) VIRTUAL`
+
+// addedColumns are the columns added to document tables after their first release
+var addedColumns = []struct{ name, definition string }{
+	{"parent_id", "parent_id TEXT"},
+	{"chunk_index", "chunk_index INTEGER NOT NULL DEFAULT 0"},
+	{"chunk_count", "chunk_count INTEGER NOT NULL DEFAULT 0"},
+	{"title", titleColumnSQL},
+}
+
+// migrateDocumentTables brings tables created by earlier versions up to date
+func migrateDocumentTables(db *sql.DB) error {
+	tables, err := documentTables(db)
+	if err != nil {
+		return err
+	}
+
+	for _, tableName := range tables {
+		existing := make(map[string]bool)
+		// table_xinfo also lists generated columns
+		rows, err := db.Query(fmt.Sprintf(`SELECT name FROM pragma_table_xinfo('%s')`, tableName))
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", tableName, err)
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			existing[name] = true
+		}
+		rows.Close()
+
+		for _, column := range addedColumns {
+			if existing[column.name] {
+				continue
+			}
+			query := fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN %s`, tableName, column.definition)
+			if _, err := db.Exec(query); err != nil {
+				return fmt.Errorf("failed to add column %s to %s: %w", column.name, tableName, err)
+			}
+		}
+
+		// Tables created before settings existed get the defaults, with the
+		// dimensions of the vectors they already hold
+		_, err = db.Exec(`INSERT OR IGNORE INTO _table_settings (table_name, settings) VALUES (?, ?)`,
+			tableName, defaultTableSettingsJSON())
+		if err != nil {
+			return fmt.Errorf("failed to create settings for %s: %w", tableName, err)
+		}
+		query := fmt.Sprintf(`
+			UPDATE _table_settings
+			SET settings = json_set(settings, '$.dimensions', (SELECT length(vector) / 4 FROM "%s" WHERE vector IS NOT NULL LIMIT 1))
+			WHERE table_name = ? AND COALESCE(json_extract(settings, '$.dimensions'), 0) = 0
+				AND EXISTS (SELECT 1 FROM "%s" WHERE vector IS NOT NULL)
+		`, tableName, tableName)
+		if _, err := db.Exec(query, tableName); err != nil {
+			return fmt.Errorf("failed to record dimensions of %s: %w", tableName, err)
+		}
+
+		// Older FTS tables only index the content
+		var hasTitle int
+		query = fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s_fts') WHERE name = 'title'`, tableName)
+		if err := db.QueryRow(query).Scan(&hasTitle); err != nil {
+			return fmt.Errorf("failed to inspect FTS table of %s: %w", tableName, err)
+		}
+		if hasTitle == 0 {
+			settings, err := readTableSettings(db, tableName)
+			if err != nil {
+				return err
+			}
+			if err := rebuildFullTextTable(db, tableName, settings.Tokenizer); err != nil {
+				return err
+			}
+		}
+
+		// Recreate the triggers so tables created by older versions pick up changes
+		for _, suffix := range []string{"ai", "au", "ad"} {
+			if _, err := db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_%s"`, tableName, suffix)); err != nil {
+				return fmt.Errorf("failed to drop FTS trigger for %s: %w", tableName, err)
+			}
+		}
+		if err := createFullTextTriggers(db, tableName); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -91,47 +363,108 @@ func (s *DocumentStore) ensureTable(db *sql.DB, tableName string) error {
 			vector BLOB,
 			created_at DATETIME NOT NULL,
 			updated_at DATETIME NOT NULL,
-			is_embedded INTEGER DEFAULT 0
+			is_embedded INTEGER DEFAULT 0,
+			parent_id TEXT,
+			chunk_index INTEGER NOT NULL DEFAULT 0,
+			chunk_count INTEGER NOT NULL DEFAULT 0,
+			%s
 		);
-	`, tableName)
+	`, tableName, titleColumnSQL)
 
 	if _, err := db.Exec(docTableSQL); err != nil {
 		return fmt.Errorf("failed to create table %s: %w", tableName, err)
 	}
 
+	// Record the default settings on first write
+	_, err := db.Exec(`INSERT OR IGNORE INTO _table_settings (table_name, settings) VALUES (?, ?)`,
+		tableName, defaultTableSettingsJSON())
+	if err != nil {
+		return fmt.Errorf("failed to create settings for %s: %w", tableName, err)
+	}
+	settings, err := readTableSettings(db, tableName)
+	if err != nil {
+		return err
+	}
+
 	// Create FTS5 virtual table
-	ftsTableSQL := fmt.Sprintf(`
+	if _, err := db.Exec(fullTextTableSQL(tableName, settings.Tokenizer)); err != nil {
+		return fmt.Errorf("failed to create FTS table for %s: %w", tableName, err)
+	}
+
+	// Create triggers to keep FTS table in sync
+	if err := createFullTextTriggers(db, tableName); err != nil {
+		return err
+	}
+
+	if err := createEmbeddingJobTriggers(db, tableName); err != nil {
+		return err
+	}
+
+	// Create indexes
+	idxCreatedAt := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_created_at" ON "%s"(created_at)`, tableName, tableName)
+	idxUpdatedAt := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_updated_at" ON "%s"(updated_at)`, tableName, tableName)
+	idxEmbedded := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_embedded" ON "%s"(is_embedded)`, tableName, tableName)
+	idxTags := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_tags" ON "%s"(tags)`, tableName, tableName)
+	idxParent := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_parent" ON "%s"(parent_id, chunk_index)`, tableName, tableName)
+
+	for _, idx := range []string{idxCreatedAt, idxUpdatedAt, idxEmbedded, idxTags, idxParent} {
+		if _, err := db.Exec(idx); err != nil {
+			return fmt.Errorf("failed to create index for %s: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+// fullTextTableSQL creates the FTS5 table indexing a document table
+// The column order is relied on by the bm25 weights in SearchFullText.
+func fullTextTableSQL(tableName, tokenizer string) string {
+	return fmt.Sprintf(`
 		CREATE VIRTUAL TABLE IF NOT EXISTS "%s_fts" USING fts5(
 			id UNINDEXED,
+			title,
 			content,
+			tags,
 			content='%s',
-			content_rowid='rowid'
+			content_rowid='rowid',
+			tokenize='%s'
 		);
+	`, tableName, tableName, tokenizer)
+}
+
+// createFullTextTriggers keeps the FTS table of a document table in sync
+// The FTS table only stores the index, so the old values are passed to
+// its delete command to remove a row's terms.
+func createFullTextTriggers(db sqlExecer, tableName string) error {
+	remove := fmt.Sprintf(`
+		INSERT INTO "%s_fts"("%s_fts", rowid, id, title, content, tags)
+		VALUES ('delete', old.rowid, old.id, old.title, old.content, old.tags);
 	`, tableName, tableName)
 
-	if _, err := db.Exec(ftsTableSQL); err != nil {
-		return fmt.Errorf("failed to create FTS table for %s: %w", tableName, err)
-	}
+	add := fmt.Sprintf(`
+		INSERT INTO "%s_fts"(rowid, id, title, content, tags)
+		VALUES (new.rowid, new.id, new.title, new.content, new.tags);
+	`, tableName)
 
-	// Create triggers to keep FTS table in sync
 	triggerAI := fmt.Sprintf(`
 		CREATE TRIGGER IF NOT EXISTS "%s_ai" AFTER INSERT ON "%s" BEGIN
-			INSERT INTO "%s_fts"(rowid, id, content)
-			VALUES (new.rowid, new.id, new.content);
+			%s
 		END;
-	`, tableName, tableName, tableName)
+	`, tableName, tableName, add)
 
 	triggerAD := fmt.Sprintf(`
 		CREATE TRIGGER IF NOT EXISTS "%s_ad" AFTER DELETE ON "%s" BEGIN
-			DELETE FROM "%s_fts" WHERE rowid = old.rowid;
+			%s
 		END;
-	`, tableName, tableName, tableName)
+	`, tableName, tableName, remove)
 
+	// Vector updates leave the indexed fields alone
 	triggerAU := fmt.Sprintf(`
-		CREATE TRIGGER IF NOT EXISTS "%s_au" AFTER UPDATE ON "%s" BEGIN
-			UPDATE "%s_fts" SET content = new.content WHERE rowid = old.rowid;
+		CREATE TRIGGER IF NOT EXISTS "%s_au" AFTER UPDATE OF content, metadata, tags ON "%s" BEGIN
+			%s
+			%s
 		END;
-	`, tableName, tableName, tableName)
+	`, tableName, tableName, remove, add)
 
 	for _, trigger := range []string{triggerAI, triggerAD, triggerAU} {
 		if _, err := db.Exec(trigger); err != nil {
@@ -139,24 +472,38 @@ func (s *DocumentStore) ensureTable(db *sql.DB, tableName string) error {
 		}
 	}
 
-	// Create indexes
-	idxCreatedAt := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_created_at" ON "%s"(created_at)`, tableName, tableName)
-	idxUpdatedAt := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_updated_at" ON "%s"(updated_at)`, tableName, tableName)
-	idxEmbedded := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_embedded" ON "%s"(is_embedded)`, tableName, tableName)
-	idxTags := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%s_tags" ON "%s"(tags)`, tableName, tableName)
+	return nil
+}
 
-	for _, idx := range []string{idxCreatedAt, idxUpdatedAt, idxEmbedded, idxTags} {
-		if _, err := db.Exec(idx); err != nil {
-			return fmt.Errorf("failed to create index for %s: %w", tableName, err)
+// rebuildFullTextTable recreates the FTS5 table of a document table with
+// another tokenizer and reindexes every row. The sync triggers refer to the
+// FTS table by name, so they keep working.
+func rebuildFullTextTable(db sqlExecer, tableName, tokenizer string) error {
+	statements := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS "%s_fts"`, tableName),
+		fullTextTableSQL(tableName, tokenizer),
+		fmt.Sprintf(`INSERT INTO "%s_fts"("%s_fts") VALUES('rebuild')`, tableName, tableName),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to rebuild FTS table for %s: %w", tableName, err)
 		}
 	}
-
 	return nil
 }
 
-// isValidTableName checks if table name contains only safe characters
+// internalTables are kept by the server next to the document tables of a
+// database, their names cannot be used for document tables
+var internalTables = []string{"_embedding_jobs", "_table_settings", "_document_history", "_api_keys"}
+
+// databaseRoutes are the database level endpoints a table of the same name
+// would be shadowed by
+var databaseRoutes = []string{"_jobs", "_search"}
+
+// isValidTableName checks if table name contains only safe characters and
+// is not reserved for an internal table or endpoint
 func isValidTableName(name string) bool {
-	if len(name) == 0 || len(name) > 64 {
+	if len(name) == 0 || len(name) > 64 || slices.Contains(internalTables, name) || slices.Contains(databaseRoutes, name) {
 		return false
 	}
 	for _, c := range name {
@@ -167,22 +514,89 @@ func isValidTableName(name string) bool {
 	return true
 }
 
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // StoreDocument stores a document in the specified database and table
 func (s *DocumentStore) StoreDocument(dbId, tableName string, doc *Document) error {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return err
 	}
+	defer release()
 
 	// Ensure table exists
 	if err := s.ensureTable(db, tableName); err != nil {
 		return err
 	}
 
+	// Fetch the vector index before writing so it stays in sync with the table
+	idx := s.mutableVectorIndex(dbId, tableName)
+
+	// A document and its chunks are written together
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := upsertDocument(tx, dbId, tableName, doc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit document: %w", err)
+	}
+
+	if idx != nil {
+		syncVectorIndex(idx, doc)
+	}
+	if !doc.IsEmbedded {
+		s.notifyEmbeddingJobs(dbId)
+	}
+
+	return nil
+}
+
+// upsertDocument fills in the generated fields of doc and writes it to the
+// table. It must run in a transaction: the document, its chunks and the
+// removal of its old chunks are written under a savepoint, so a document
+// that fails halfway leaves nothing behind while the transaction stays
+// usable for the other documents of a batch.
+func upsertDocument(db sqlExecer, dbId, tableName string, doc *Document) error {
+	if doc.Chunk != nil {
+		return writeDocument(db, dbId, tableName, doc)
+	}
+
+	if _, err := db.Exec(`SAVEPOINT doc`); err != nil {
+		return fmt.Errorf("failed to begin savepoint: %w", err)
+	}
+	if err := writeDocument(db, dbId, tableName, doc); err != nil {
+		// ROLLBACK TO leaves the savepoint open, RELEASE ends it
+		if _, rbErr := db.Exec(`ROLLBACK TO doc; RELEASE doc`); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if _, err := db.Exec(`RELEASE doc`); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// writeDocument writes a document and its chunks, see upsertDocument
+func writeDocument(db sqlExecer, dbId, tableName string, doc *Document) error {
 	// Generate ID if not provided
 	if doc.ID == "" {
 		doc.ID = uuid.New().String()
 	}
+	if doc.Chunk == nil {
+		if err := checkDocumentID(doc.ID); err != nil {
+			return err
+		}
+	}
 
 	// Set timestamps
 	now := time.Now()
@@ -202,6 +616,15 @@ func (s *DocumentStore) StoreDocument(dbId, tableName string, doc *Document) err
 	// Serialize tags as comma-separated string
 	tagsStr := strings.Join(doc.Tags, ",")
 
+	// Vectors must match the table's dimensions, check them all before writing
+	if doc.Chunk == nil {
+		for _, target := range embeddingTargets([]*Document{doc}) {
+			if err := checkDimensions(db, tableName, target.Vector); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Serialize vector if present
 	var vectorBytes []byte
 	if len(doc.Vector) > 0 {
@@ -209,33 +632,108 @@ func (s *DocumentStore) StoreDocument(dbId, tableName string, doc *Document) err
 		doc.IsEmbedded = true
 	}
 
+	// A chunked document carries no vector itself, it counts as embedded
+	// once all of its chunks are
+	doc.ChunkCount = len(doc.Chunks)
+	if doc.ChunkCount > 0 {
+		vectorBytes = nil
+		doc.Vector = nil
+		doc.IsEmbedded = true
+		for _, chunk := range doc.Chunks {
+			doc.IsEmbedded = doc.IsEmbedded && len(chunk.Vector) > 0
+		}
+	}
+
+	var parentID interface{}
+	chunkIndex := 0
+	if doc.Chunk != nil {
+		parentID = doc.Chunk.ParentID
+		chunkIndex = doc.Chunk.Index
+	}
+
 	query := fmt.Sprintf(`
-		INSERT INTO "%s" (id, content, metadata, tags, vector, created_at, updated_at, is_embedded)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO "%s" (id, content, metadata, tags, vector, created_at, updated_at, is_embedded, parent_id, chunk_index, chunk_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			content = excluded.content,
 			metadata = excluded.metadata,
 			tags = excluded.tags,
 			vector = excluded.vector,
 			updated_at = excluded.updated_at,
-			is_embedded = excluded.is_embedded
+			is_embedded = excluded.is_embedded,
+			parent_id = excluded.parent_id,
+			chunk_index = excluded.chunk_index,
+			chunk_count = excluded.chunk_count
 	`, tableName)
 
 	_, err = db.Exec(query, doc.ID, doc.Content, string(metadataJSON),
-		tagsStr, vectorBytes, doc.CreatedAt, doc.UpdatedAt, boolToInt(doc.IsEmbedded))
+		tagsStr, vectorBytes, doc.CreatedAt, doc.UpdatedAt, boolToInt(doc.IsEmbedded),
+		parentID, chunkIndex, doc.ChunkCount)
+	if err != nil || doc.Chunk != nil {
+		return err
+	}
+
+	for i, chunk := range doc.Chunks {
+		chunk.ID = chunkID(doc.ID, i)
+		chunk.Chunk = &ChunkRef{ParentID: doc.ID, Index: i}
+		chunk.CreatedAt = doc.CreatedAt
+		if err := writeDocument(db, dbId, tableName, chunk); err != nil {
+			return fmt.Errorf("failed to store chunk %d: %w", i, err)
+		}
+	}
 
+	// Drop chunks left over from a longer previous version of the document
+	doc.removedChunks, err = deleteChunks(db, tableName, doc.ID, len(doc.Chunks))
 	return err
 }
 
+// deleteChunks deletes the chunks of a document from index from on,
+// returning the IDs of the deleted rows
+func deleteChunks(db sqlExecer, tableName, parentID string, from int) ([]string, error) {
+	query := fmt.Sprintf(`DELETE FROM "%s" WHERE parent_id = ? AND chunk_index >= ? RETURNING id`, tableName)
+	rows, err := db.Query(query, parentID, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// syncVectorIndex applies a stored document to the table's vector index
+func syncVectorIndex(idx *hnswIndex, doc *Document) {
+	if len(doc.Vector) > 0 {
+		idx.Add(doc.ID, doc.Vector)
+	} else {
+		idx.Remove(doc.ID)
+	}
+	for _, chunk := range doc.Chunks {
+		syncVectorIndex(idx, chunk)
+	}
+	for _, id := range doc.removedChunks {
+		idx.Remove(id)
+	}
+}
+
 // GetDocument retrieves a document by ID from the specified database and table
 func (s *DocumentStore) GetDocument(dbId, tableName, id string) (*Document, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	query := fmt.Sprintf(`
-		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded
+		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded,
+		       parent_id, chunk_index, chunk_count
 		FROM "%s"
 		WHERE id = ?
 	`, tableName)
@@ -245,10 +743,13 @@ func (s *DocumentStore) GetDocument(dbId, tableName, id string) (*Document, erro
 	var tagsStr string
 	var vectorBytes []byte
 	var isEmbedded int
+	var parentID sql.NullString
+	var chunkIndex, chunkCount int
 
 	err = db.QueryRow(query, id).Scan(
 		&doc.ID, &doc.Content, &metadataJSON, &tagsStr, &vectorBytes,
 		&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded,
+		&parentID, &chunkIndex, &chunkCount,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("document not found")
@@ -260,6 +761,7 @@ func (s *DocumentStore) GetDocument(dbId, tableName, id string) (*Document, erro
 	doc.DB = dbId
 	doc.Table = tableName
 	doc.IsEmbedded = isEmbedded == 1
+	doc.setChunkInfo(parentID, chunkIndex, chunkCount)
 
 	// Deserialize metadata
 	if metadataJSON != "" {
@@ -283,13 +785,25 @@ func (s *DocumentStore) GetDocument(dbId, tableName, id string) (*Document, erro
 
 // DeleteDocument deletes a document by ID from the specified database and table
 func (s *DocumentStore) DeleteDocument(dbId, tableName, id string) error {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	query := fmt.Sprintf(`DELETE FROM "%s" WHERE id = ?`, tableName)
-	result, err := db.Exec(query, id)
+	idx := s.mutableVectorIndex(dbId, tableName)
+
+	// A document and its chunks are deleted together
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Chunks are deleted with their document only, deleting one on its own
+	// would leave the document's chunk_count behind
+	query := fmt.Sprintf(`DELETE FROM "%s" WHERE id = ? AND parent_id IS NULL`, tableName)
+	result, err := tx.Exec(query, id)
 	if err != nil {
 		return err
 	}
@@ -299,39 +813,70 @@ func (s *DocumentStore) DeleteDocument(dbId, tableName, id string) error {
 		return err
 	}
 	if rows == 0 {
+		var parentID sql.NullString
+		query := fmt.Sprintf(`SELECT parent_id FROM "%s" WHERE id = ?`, tableName)
+		if err := tx.QueryRow(query, id).Scan(&parentID); err == nil && parentID.Valid {
+			return fmt.Errorf("%w: %s is a chunk of %s, change the document instead", ErrChunkID, id, parentID.String)
+		}
 		return fmt.Errorf("document not found")
 	}
 
+	// Chunks go with their document
+	removed, err := deleteChunks(tx, tableName, id, 0)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delete: %w", err)
+	}
+
+	if idx != nil {
+		idx.Remove(id)
+		for _, chunkID := range removed {
+			idx.Remove(chunkID)
+		}
+	}
+
 	return nil
 }
 
 // SearchFullText performs full-text search on documents
 func (s *DocumentStore) SearchFullText(dbId, tableName, query string, limit int, filters map[string]interface{}) ([]SearchResult, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	if limit <= 0 {
 		limit = 10
 	}
 
 	// Build filter clause
-	filterClause, filterArgs := buildFilterClause(filters, "d")
+	filterClause, filterArgs, err := buildFilterClause(filters, "d")
+	if err != nil {
+		return nil, err
+	}
 
-	// FTS5 query with ranking
+	settings, err := readTableSettings(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// FTS5 query ranked with the table's field weights, the id column is not indexed
 	sqlQuery := fmt.Sprintf(`
-		SELECT d.id, d.content, d.metadata, d.tags, d.vector, d.created_at, d.updated_at, 
-		       d.is_embedded, bm25("%s_fts") as score
+		SELECT d.id, d.content, d.metadata, d.tags, d.vector, d.created_at, d.updated_at,
+		       d.is_embedded, d.parent_id, d.chunk_index, d.chunk_count, bm25("%s_fts", 0, ?, ?, ?) as score
 		FROM "%s_fts"
 		JOIN "%s" d ON "%s_fts".rowid = d.rowid
-		WHERE "%s_fts" MATCH ?%s
+		WHERE "%s_fts" MATCH ? AND d.chunk_count = 0%s
 		ORDER BY score
 		LIMIT ?
 	`, tableName, tableName, tableName, tableName, tableName, filterClause)
 
-	// Prepare arguments: query, filter args, limit
-	queryArgs := []interface{}{query}
+	// Prepare arguments: weights, query, filter args, limit
+	weights := settings.Weights
+	queryArgs := []interface{}{*weights.Title, *weights.Content, *weights.Tags, query}
 	queryArgs = append(queryArgs, filterArgs...)
 	queryArgs = append(queryArgs, limit)
 
@@ -348,10 +893,12 @@ func (s *DocumentStore) SearchFullText(dbId, tableName, query string, limit int,
 		var tagsStr string
 		var vectorBytes []byte
 		var isEmbedded int
+		var parentID sql.NullString
+		var chunkIndex, chunkCount int
 		var score float64
 
 		err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &tagsStr, &vectorBytes,
-			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &score)
+			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &parentID, &chunkIndex, &chunkCount, &score)
 		if err != nil {
 			return nil, err
 		}
@@ -359,6 +906,7 @@ func (s *DocumentStore) SearchFullText(dbId, tableName, query string, limit int,
 		doc.DB = dbId
 		doc.Table = tableName
 		doc.IsEmbedded = isEmbedded != 0
+		doc.setChunkInfo(parentID, chunkIndex, chunkCount)
 
 		if metadataJSON != "" {
 			json.Unmarshal([]byte(metadataJSON), &doc.Metadata)
@@ -379,9 +927,18 @@ func (s *DocumentStore) SearchFullText(dbId, tableName, query string, limit int,
 	return results, rows.Err()
 }
 
-// SearchVector performs vector similarity search
-func (s *DocumentStore) SearchVector(dbId, tableName string, queryVector []float32, limit int, filters map[string]interface{}) ([]SearchResult, error) {
-	return s.searchVectorSimilarity(dbId, tableName, queryVector, limit, "cosine", filters)
+// SearchVector performs vector similarity search with the table's metric
+func (s *DocumentStore) SearchVector(dbId, tableName string, queryVector []float32, limit int, filters map[string]interface{}, opts VectorSearchOptions) ([]SearchResult, error) {
+	settings, err := s.GetTableSettings(dbId, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Dimensions > 0 && len(queryVector) != settings.Dimensions {
+		return nil, fmt.Errorf("%w: table %s expects %d dimensions, the query has %d",
+			ErrDimensionMismatch, tableName, settings.Dimensions, len(queryVector))
+	}
+
+	return s.searchVectorSimilarity(dbId, tableName, queryVector, limit, settings.Metric, filters, opts)
 }
 
 // ListPartitions returns information about all databases (deprecated, use ListDatabases)
@@ -391,13 +948,36 @@ func (s *DocumentStore) ListPartitions() ([]DBInfo, error) {
 
 // ListDatabases returns information about all databases
 func (s *DocumentStore) ListDatabases() ([]DBInfo, error) {
-	// Build a map of all database names (from both disk files and open connections)
+	dbNames, err := s.databaseNames()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info for all databases
+	var databases []DBInfo
+	for _, dbName := range dbNames {
+		info, err := s.getDBInfo(dbName)
+		if err != nil {
+			// Log the error but don't fail completely
+			fmt.Printf("Warning: failed to get info for database %s: %v\n", dbName, err)
+			continue
+		}
+		databases = append(databases, info)
+	}
+
+	return databases, nil
+}
+
+// databaseNames returns the names of all databases, from both disk files and open connections
+func (s *DocumentStore) databaseNames() ([]string, error) {
 	dbNames := make(map[string]bool)
 
 	// Add databases from open connections
+	s.mu.Lock()
 	for name := range s.dbs {
 		dbNames[name] = true
 	}
+	s.mu.Unlock()
 
 	// Add databases from disk files
 	files, err := os.ReadDir(s.baseDir)
@@ -413,31 +993,28 @@ func (s *DocumentStore) ListDatabases() ([]DBInfo, error) {
 		dbNames[dbName] = true
 	}
 
-	// Get info for all databases
-	var databases []DBInfo
-	for dbName := range dbNames {
-		info, err := s.getDBInfo(dbName)
-		if err != nil {
-			// Log the error but don't fail completely
-			fmt.Printf("Warning: failed to get info for database %s: %v\n", dbName, err)
-			continue
-		}
-		databases = append(databases, info)
-	}
+	delete(dbNames, systemDatabase)
 
-	return databases, nil
+	names := make([]string, 0, len(dbNames))
+	for name := range dbNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 // ListDocuments returns a list of documents in a database table with pagination
 func (s *DocumentStore) ListDocuments(dbId, tableName string, limit, offset int) ([]Document, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	query := fmt.Sprintf(`
-		SELECT id, content, metadata, vector, created_at, updated_at, is_embedded
+		SELECT id, content, metadata, vector, created_at, updated_at, is_embedded, chunk_count
 		FROM "%s"
+		WHERE parent_id IS NULL
 		ORDER BY created_at DESC
 		LIMIT ? OFFSET ?
 	`, tableName)
@@ -456,7 +1033,7 @@ func (s *DocumentStore) ListDocuments(dbId, tableName string, limit, offset int)
 		var isEmbedded int
 
 		err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &vectorBytes,
-			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded)
+			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &doc.ChunkCount)
 		if err != nil {
 			return nil, err
 		}
@@ -479,12 +1056,45 @@ func (s *DocumentStore) ListDocuments(dbId, tableName string, limit, offset int)
 }
 
 // DeleteDatabase deletes an entire database
+// New requests for the database fail until its files are removed, while it
+// waits for in-flight ones to finish.
 func (s *DocumentStore) DeleteDatabase(dbId string) error {
-	// Close connection if open
-	if db, exists := s.dbs[dbId]; exists {
-		db.Close()
-		delete(s.dbs, dbId)
+	s.mu.Lock()
+	h, exists := s.dbs[dbId]
+	if exists && h.deleting {
+		s.mu.Unlock()
+		return fmt.Errorf("database %s is being deleted", dbId)
+	}
+	if !exists {
+		// Hold the name even if the database is not open, so getDB cannot
+		// open it again while its files are removed
+		h = &dbHandle{ready: make(chan struct{})}
+		close(h.ready)
+		s.dbs[dbId] = h
+	}
+	h.deleting = true
+	if h.refs > 0 {
+		h.drained = make(chan struct{})
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.dbs[dbId] == h {
+			delete(s.dbs, dbId)
+		}
+		s.mu.Unlock()
+	}()
+
+	// Close connection if open, once nobody uses it anymore
+	if h.drained != nil {
+		<-h.drained
+	}
+	if h.db != nil {
+		h.db.Close()
 	}
+	s.dropDatabaseVectorIndexes(dbId)
+	s.setPendingEmbeddingJobs(dbId, false)
 
 	// Delete database file
 	dbPath := filepath.Join(s.baseDir, fmt.Sprintf("%s.db", dbId))
@@ -494,16 +1104,19 @@ func (s *DocumentStore) DeleteDatabase(dbId string) error {
 		}
 		return fmt.Errorf("failed to delete database file: %w", err)
 	}
+	os.Remove(dbPath + "-wal")
+	os.Remove(dbPath + "-shm")
 
 	return nil
 }
 
 // getDBInfo retrieves information about a specific database
 func (s *DocumentStore) getDBInfo(dbId string) (DBInfo, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return DBInfo{}, err
 	}
+	defer release()
 
 	var info DBInfo
 	info.Name = dbId
@@ -547,15 +1160,16 @@ func (s *DocumentStore) getDBInfo(dbId string) (DBInfo, error) {
 
 // GetNonEmbeddedDocuments returns documents that need embedding from a database table
 func (s *DocumentStore) GetNonEmbeddedDocuments(dbId, tableName string, limit int) ([]*Document, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	query := fmt.Sprintf(`
 		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded
 		FROM "%s"
-		WHERE is_embedded = 0
+		WHERE is_embedded = 0 AND chunk_count = 0
 		ORDER BY created_at ASC
 		LIMIT ?
 	`, tableName)
@@ -611,51 +1225,97 @@ func (s *DocumentStore) GetNonEmbeddedDocuments(dbId, tableName string, limit in
 
 // UpdateDocumentVector updates only the vector field of a document
 func (s *DocumentStore) UpdateDocumentVector(dbId, tableName, docID string, vector []float32) error {
-	db, err := s.getDB(dbId)
+	updated, err := s.updateDocumentVector(dbId, tableName, docID, nil, vector)
 	if err != nil {
 		return err
 	}
+	if !updated {
+		return fmt.Errorf("document not found")
+	}
+	return nil
+}
+
+// updateDocumentVector stores a document's vector, only while its content
+// still matches when content is set. Returns false when no row was updated.
+func (s *DocumentStore) updateDocumentVector(dbId, tableName, docID string, content *string, vector []float32) (bool, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	if err := checkDimensions(db, tableName, vector); err != nil {
+		return false, err
+	}
 
 	vectorBytes := serializeVector(vector)
+	idx := s.mutableVectorIndex(dbId, tableName)
 
 	query := fmt.Sprintf(`
 		UPDATE "%s"
 		SET vector = ?, is_embedded = 1, updated_at = ?
-		WHERE id = ?
+		WHERE id = ? AND (? IS NULL OR content = ?)
 	`, tableName)
 
-	result, err := db.Exec(query, vectorBytes, time.Now(), docID)
+	result, err := db.Exec(query, vectorBytes, time.Now(), docID, content, content)
 	if err != nil {
-		return fmt.Errorf("failed to update document vector: %w", err)
+		return false, fmt.Errorf("failed to update document vector: %w", err)
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get affected rows: %w", err)
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
 	}
 	if rows == 0 {
-		return fmt.Errorf("document not found")
+		return false, nil
 	}
 
-	return nil
+	// Mark a chunked document embedded once its last chunk is
+	parentQuery := fmt.Sprintf(`
+		UPDATE "%s" AS p
+		SET is_embedded = NOT EXISTS (SELECT 1 FROM "%s" c WHERE c.parent_id = p.id AND c.is_embedded = 0)
+		WHERE p.id = (SELECT parent_id FROM "%s" WHERE id = ?)
+	`, tableName, tableName, tableName)
+	if _, err := db.Exec(parentQuery, docID); err != nil {
+		return false, fmt.Errorf("failed to update parent document: %w", err)
+	}
+
+	if idx != nil {
+		idx.Add(docID, vector)
+	}
+
+	return true, nil
 }
 
 // ListTables returns all table names in a database
 func (s *DocumentStore) ListTables(dbId string) ([]string, error) {
-	db, err := s.getDB(dbId)
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	return documentTables(db)
+}
 
+// documentTables lists the document tables of a database, leaving out FTS
+// shadow tables, vec_search and internal tables
+func documentTables(db *sql.DB) ([]string, error) {
+	args := make([]interface{}, len(internalTables))
+	for i, name := range internalTables {
+		args[i] = name
+	}
 	query := `
 		SELECT name FROM sqlite_master 
 		WHERE type='table' 
-		AND name NOT LIKE '%_fts%' 
+		AND name NOT LIKE '%\_fts%' ESCAPE '\'
 		AND name NOT LIKE 'sqlite_%'
+		AND name NOT IN (?` + strings.Repeat(", ?", len(internalTables)-1) + `)
+		AND name != 'vec_search'
 		ORDER BY name
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -673,111 +1333,54 @@ func (s *DocumentStore) ListTables(dbId string) ([]string, error) {
 	return tables, rows.Err()
 }
 
-// Close closes all database connections
+// Close saves vector indexes, checkpoints the write-ahead logs and closes
+// all database connections. The first failure is returned after every
+// database was attempted.
 func (s *DocumentStore) Close() error {
-	for _, db := range s.dbs {
-		if err := db.Close(); err != nil {
-			return err
-		}
+	var firstErr error
+	if err := s.SaveVectorIndexes(); err != nil {
+		log.Printf("Failed to save vector indexes: %v", err)
+		firstErr = err
 	}
-	return nil
-}
-
-// Helper functions
-
-// buildFilterClause builds a WHERE clause from filters
-// Supports:
-// - "tags": []string or string - filters by tags (AND logic for multiple tags)
-// - "tag": string - filters by single tag
-// - metadata fields: filters JSON metadata fields
-// tableAlias: optional table alias prefix (e.g., "d" for "d.tags")
-// Returns the WHERE clause SQL and the arguments for the query
-func buildFilterClause(filters map[string]interface{}, tableAlias string) (string, []interface{}) {
-	if len(filters) == 0 {
-		return "", nil
-	}
-
-	var conditions []string
-	var args []interface{}
-
-	// Add table alias prefix if provided
-	prefix := ""
-	if tableAlias != "" {
-		prefix = tableAlias + "."
-	}
-
-	for key, value := range filters {
-		switch key {
-		case "tags":
-			// Handle tags filter - value can be string or []string
-			var tagList []string
-			switch v := value.(type) {
-			case string:
-				tagList = []string{v}
-			case []interface{}:
-				for _, t := range v {
-					if str, ok := t.(string); ok {
-						tagList = append(tagList, str)
-					}
-				}
-			case []string:
-				tagList = v
-			}
 
-			// For each tag, check if it's present in the comma-separated tags field
-			for _, tag := range tagList {
-				// Match: exact tag, or tag at start, or tag in middle/end
-				conditions = append(conditions, fmt.Sprintf("(%stags = ? OR %stags LIKE ? OR %stags LIKE ? OR %stags LIKE ?)", prefix, prefix, prefix, prefix))
-				args = append(args, tag, tag+",%", "%,"+tag+",%", "%,"+tag)
-			}
-
-		case "tag":
-			// Single tag filter
-			if tagStr, ok := value.(string); ok {
-				conditions = append(conditions, fmt.Sprintf("(%stags = ? OR %stags LIKE ? OR %stags LIKE ? OR %stags LIKE ?)", prefix, prefix, prefix, prefix))
-				args = append(args, tagStr, tagStr+",%", "%,"+tagStr+",%", "%,"+tagStr)
-			}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	for name, h := range s.dbs {
+		delete(s.dbs, name)
+		select {
+		case <-h.ready:
 		default:
-			// Metadata field filter using JSON extraction
-			// SQLite JSON syntax: json_extract(metadata, '$.field')
-			conditions = append(conditions, fmt.Sprintf("json_extract(%smetadata, '$.%s') = ?", prefix, key))
-
-			// SQLite json_extract returns values in their JSON types
-			// For comparison, we need to use the actual value, not stringified
-			switch v := value.(type) {
-			case string:
-				// For strings, json_extract returns them without quotes
-				args = append(args, v)
-			case int, int64, float64, float32:
-				// For numbers, json_extract returns numeric values
-				args = append(args, v)
-			case bool:
-				// For booleans, json_extract returns 0 or 1
-				if v {
-					args = append(args, 1)
-				} else {
-					args = append(args, 0)
-				}
-			default:
-				// For other types, marshal to JSON and remove outer quotes if string
-				jsonValue, _ := json.Marshal(value)
-				valueStr := string(jsonValue)
-				if len(valueStr) > 2 && valueStr[0] == '"' && valueStr[len(valueStr)-1] == '"' {
-					valueStr = valueStr[1 : len(valueStr)-1]
-				}
-				args = append(args, valueStr)
-			}
+			continue // Still opening
+		}
+		if h.db == nil {
+			continue
+		}
+		if err := checkpointWAL(h.db); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("database %s: %w", name, err)
+		}
+		if err := h.db.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("database %s: %w", name, err)
 		}
 	}
+	return firstErr
+}
 
-	if len(conditions) == 0 {
-		return "", nil
+// checkpointWAL copies the write-ahead log into the database file and
+// truncates it, so the file is complete on its own after shutdown
+func checkpointWAL(db *sql.DB) error {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return fmt.Errorf("failed to checkpoint WAL: %w", err)
 	}
-
-	return " AND " + strings.Join(conditions, " AND "), args
+	if busy != 0 {
+		return fmt.Errorf("failed to checkpoint WAL: database is busy")
+	}
+	return nil
 }
 
+// Helper functions
+
 func serializeVector(vector []float32) []byte {
 	bytes := make([]byte, len(vector)*4)
 	for i, v := range vector {
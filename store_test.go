@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentStoreAccess hammers the store from many goroutines while
+// databases are evicted and deleted. Run with go test -race to check the
+// connection management for data races.
+func TestConcurrentStoreAccess(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	// Fewer open slots than databases forces constant eviction
+	store.SetMaxOpenDatabases(2)
+
+	const (
+		databases  = 5
+		goroutines = 16
+		iterations = 25
+	)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				dbName := fmt.Sprintf("tenant_%d", (g+i)%databases)
+				docID := fmt.Sprintf("doc_%d_%d", g, i)
+
+				// Deletions race with everything else, so errors are
+				// expected; the test is about crashes and data races
+				switch i % 5 {
+				case 0, 1:
+					store.StoreDocument(dbName, "notes", &Document{ID: docID, Content: "note", Vector: []float32{1, 0, 0}})
+				case 2:
+					store.GetDocument(dbName, "notes", docID)
+					store.SearchFullText(dbName, "notes", "note", 5, nil)
+				case 3:
+					store.ListDatabases()
+					store.ListTables(dbName)
+				case 4:
+					if g%4 == 0 {
+						store.DeleteDatabase(dbName)
+					} else {
+						store.SearchVector(dbName, "notes", []float32{1, 0, 0}, 5, nil, VectorSearchOptions{})
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// The store keeps working after the storm
+	if err := store.StoreDocument("tenant_0", "notes", &Document{ID: "after", Content: "after"}); err != nil {
+		t.Fatalf("StoreDocument failed: %v", err)
+	}
+	if _, err := store.GetDocument("tenant_0", "notes", "after"); err != nil {
+		t.Errorf("GetDocument failed: %v", err)
+	}
+}
+
+func TestDatabaseConnections(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	store.SetMaxOpenDatabases(2)
+
+	t.Run("Idle databases are evicted LRU", func(t *testing.T) {
+		for _, name := range []string{"a", "b", "c"} {
+			if err := store.StoreDocument(name, "notes", &Document{ID: "1", Content: name, Vector: []float32{1, 0, 0}}); err != nil {
+				t.Fatalf("StoreDocument failed: %v", err)
+			}
+		}
+
+		store.mu.Lock()
+		_, hasA := store.dbs["a"]
+		open := len(store.dbs)
+		store.mu.Unlock()
+		if hasA || open != 2 {
+			t.Errorf("open databases = %d (a open: %v), want b and c", open, hasA)
+		}
+
+		// The vector index of an evicted database is saved and unloaded
+		store.indexMu.Lock()
+		_, indexLoaded := store.indexes[vectorIndexKey("a", "notes")]
+		store.indexMu.Unlock()
+		if indexLoaded {
+			t.Errorf("vector index of evicted database a still loaded")
+		}
+		if _, err := os.Stat(store.vectorIndexPath("a", "notes")); err != nil {
+			t.Errorf("vector index of evicted database a not saved: %v", err)
+		}
+
+		// An evicted database is reopened transparently
+		doc, err := store.GetDocument("a", "notes", "1")
+		if err != nil || doc.Content != "a" {
+			t.Errorf("GetDocument after eviction = %+v, %v", doc, err)
+		}
+	})
+
+	t.Run("Databases in use are not evicted", func(t *testing.T) {
+		db, release, err := store.getDB("b")
+		if err != nil {
+			t.Fatalf("getDB failed: %v", err)
+		}
+		defer release()
+
+		for _, name := range []string{"d", "e", "f"} {
+			if _, err := store.ListTables(name); err != nil {
+				t.Fatalf("ListTables failed: %v", err)
+			}
+		}
+
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count); err != nil || count != 1 {
+			t.Errorf("held connection unusable: count=%d err=%v", count, err)
+		}
+	})
+
+	t.Run("WAL mode and busy timeout", func(t *testing.T) {
+		db, release, err := store.getDB("a")
+		if err != nil {
+			t.Fatalf("getDB failed: %v", err)
+		}
+		defer release()
+
+		var mode string
+		var timeout int
+		if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+			t.Errorf("journal_mode = %q (%v), want wal", mode, err)
+		}
+		if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil || timeout <= 0 {
+			t.Errorf("busy_timeout = %d (%v), want it set", timeout, err)
+		}
+	})
+
+	t.Run("Delete waits for in-flight queries", func(t *testing.T) {
+		db, release, err := store.getDB("c")
+		if err != nil {
+			t.Fatalf("getDB failed: %v", err)
+		}
+
+		deleted := make(chan error)
+		go func() { deleted <- store.DeleteDatabase("c") }()
+
+		// New requests are turned away while the delete is pending
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, rel, err := store.getDB("c")
+			if err != nil {
+				break
+			}
+			rel()
+			if time.Now().After(deadline) {
+				t.Fatal("getDB kept succeeding during delete")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		select {
+		case err := <-deleted:
+			t.Fatalf("DeleteDatabase returned %v before the query finished", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count); err != nil {
+			t.Errorf("in-flight query failed: %v", err)
+		}
+		release()
+
+		if err := <-deleted; err != nil {
+			t.Fatalf("DeleteDatabase failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "c.db")); !os.IsNotExist(err) {
+			t.Errorf("database file still exists: %v", err)
+		}
+	})
+}
+
+func TestCloseCheckpointsWAL(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer os.RemoveAll(tmpDir)
+
+	if err := store.StoreDocument("kb", "notes", &Document{ID: "1", Content: "kept"}); err != nil {
+		t.Fatalf("StoreDocument failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(tmpDir, "kb.db-wal")); err != nil || info.Size() == 0 {
+		t.Fatalf("expected writes in the WAL before closing: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(tmpDir, "kb.db-wal")); err == nil && info.Size() > 0 {
+		t.Errorf("WAL still holds %d bytes after Close", info.Size())
+	}
+
+	reopened, err := NewDocumentStore(tmpDir)
+	if err != nil {
+		t.Fatalf("NewDocumentStore failed: %v", err)
+	}
+	defer reopened.Close()
+	if doc, err := reopened.GetDocument("kb", "notes", "1"); err != nil || doc.Content != "kept" {
+		t.Errorf("GetDocument after reopen = %+v, %v", doc, err)
+	}
+}
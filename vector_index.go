@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hnswFilterOversample widens the HNSW candidate set when filters are applied,
+// since filtered-out candidates would otherwise shrink the result set
+const hnswFilterOversample = 10
+
+// VectorSearchOptions controls how a vector search is executed
+type VectorSearchOptions struct {
+	EfSearch int  // HNSW beam width (default hnswDefaultEfSearch)
+	Exact    bool // Scan every vector instead of using the HNSW index
+}
+
+// vectorIndexKey identifies the index for a database table
+func vectorIndexKey(dbId, tableName string) string {
+	return dbId + "/" + tableName
+}
+
+// vectorIndexPath returns the on-disk location of a table's HNSW index,
+// stored next to the database file
+func (s *DocumentStore) vectorIndexPath(dbId, tableName string) string {
+	return filepath.Join(s.baseDir, fmt.Sprintf("%s.%s.hnsw", dbId, tableName))
+}
+
+// vectorIndexEntry holds a table's index once the first caller loaded it
+type vectorIndexEntry struct {
+	idx   *hnswIndex
+	err   error
+	ready chan struct{} // Closed once loading finished
+}
+
+// vectorIndex returns the HNSW index for a table, loading it on first use.
+// Callers asking for an index that is being loaded wait for it, while other
+// tables' indexes stay available.
+func (s *DocumentStore) vectorIndex(dbId, tableName string) (*hnswIndex, error) {
+	key := vectorIndexKey(dbId, tableName)
+
+	s.indexMu.Lock()
+	e, loaded := s.indexes[key]
+	if !loaded {
+		e = &vectorIndexEntry{ready: make(chan struct{})}
+		s.indexes[key] = e
+	}
+	s.indexMu.Unlock()
+
+	if !loaded {
+		e.idx, e.err = s.loadVectorIndex(dbId, tableName)
+		if e.err != nil {
+			s.indexMu.Lock()
+			if s.indexes[key] == e {
+				delete(s.indexes, key)
+			}
+			s.indexMu.Unlock()
+		}
+		close(e.ready)
+	}
+
+	<-e.ready
+	return e.idx, e.err
+}
+
+// loadVectorIndex reads a table's index from disk, rebuilding it from the
+// stored vectors when the file is missing, stale or built for another metric
+func (s *DocumentStore) loadVectorIndex(dbId, tableName string) (*hnswIndex, error) {
+	db, release, err := s.getDB(dbId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fingerprint, err := vectorFingerprint(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := readTableSettings(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.vectorIndexPath(dbId, tableName)
+	idx, err := loadHNSWIndex(path)
+	if err == nil && idx.fingerprint == fingerprint && idx.metric == settings.Metric {
+		return idx, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Discarding unreadable vector index for %s.%s: %v", dbId, tableName, err)
+	}
+
+	idx, err = buildVectorIndex(db, tableName, settings.Metric)
+	if err != nil {
+		return nil, err
+	}
+	idx.fingerprint = fingerprint
+
+	return idx, nil
+}
+
+// buildVectorIndex creates an index for metric from every embedded row in a table
+func buildVectorIndex(db *sql.DB, tableName, metric string) (*hnswIndex, error) {
+	query := fmt.Sprintf(`
+		SELECT id, vector
+		FROM "%s"
+		WHERE is_embedded = 1 AND vector IS NOT NULL
+	`, tableName)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vectors: %w", err)
+	}
+	defer rows.Close()
+
+	idx := newHNSWIndex(metric)
+	for rows.Next() {
+		var id string
+		var vectorBytes []byte
+		if err := rows.Scan(&id, &vectorBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan vector: %w", err)
+		}
+		if len(vectorBytes) > 0 {
+			idx.Add(id, deserializeVector(vectorBytes))
+		}
+	}
+
+	return idx, rows.Err()
+}
+
+// vectorFingerprint summarizes the embedded rows of a table so a saved index
+// can be checked against the data it was built from
+func vectorFingerprint(db *sql.DB, tableName string) (string, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(MAX(updated_at), '')
+		FROM "%s"
+		WHERE is_embedded = 1 AND vector IS NOT NULL
+	`, tableName)
+
+	var count int
+	var lastUpdated string
+	if err := db.QueryRow(query).Scan(&count, &lastUpdated); err != nil {
+		return "", fmt.Errorf("failed to fingerprint vectors: %w", err)
+	}
+
+	return fmt.Sprintf("%d:%s", count, lastUpdated), nil
+}
+
+// mutableVectorIndex returns the index a write must keep in sync. It is
+// fetched before the write so a rebuild never misses the change. On failure
+// the index is dropped so it gets rebuilt on next use.
+func (s *DocumentStore) mutableVectorIndex(dbId, tableName string) *hnswIndex {
+	idx, err := s.vectorIndex(dbId, tableName)
+	if err != nil {
+		log.Printf("Vector index for %s.%s unavailable, it will be rebuilt: %v", dbId, tableName, err)
+		s.dropVectorIndex(dbId, tableName)
+		return nil
+	}
+	return idx
+}
+
+// dropVectorIndex forgets a table's index in memory and on disk
+func (s *DocumentStore) dropVectorIndex(dbId, tableName string) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	delete(s.indexes, vectorIndexKey(dbId, tableName))
+	os.Remove(s.vectorIndexPath(dbId, tableName))
+}
+
+// dropDatabaseVectorIndexes forgets every index belonging to a database
+func (s *DocumentStore) dropDatabaseVectorIndexes(dbId string) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	prefix := dbId + "/"
+	for key := range s.indexes {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.indexes, key)
+		}
+	}
+
+	files, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return
+	}
+	filePrefix := dbId + "."
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, ".hnsw") {
+			os.Remove(filepath.Join(s.baseDir, name))
+		}
+	}
+}
+
+// loadedVectorIndexes returns the indexes loaded so far whose key starts
+// with prefix, leaving out those still loading or that failed to load
+func (s *DocumentStore) loadedVectorIndexes(prefix string) map[string]*hnswIndex {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	loaded := make(map[string]*hnswIndex)
+	for key, e := range s.indexes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		select {
+		case <-e.ready:
+			if e.idx != nil {
+				loaded[key] = e.idx
+			}
+		default:
+		}
+	}
+	return loaded
+}
+
+// saveVectorIndex writes a modified index to disk with the fingerprint of
+// the table it mirrors
+func (s *DocumentStore) saveVectorIndex(db *sql.DB, dbId, tableName string, idx *hnswIndex) error {
+	idx.mu.RLock()
+	dirty := idx.dirty
+	idx.mu.RUnlock()
+	if !dirty {
+		return nil
+	}
+
+	fingerprint, err := vectorFingerprint(db, tableName)
+	if err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	idx.fingerprint = fingerprint
+	idx.mu.Unlock()
+
+	return idx.Save(s.vectorIndexPath(dbId, tableName))
+}
+
+// SaveVectorIndexes writes every modified index to disk
+func (s *DocumentStore) SaveVectorIndexes() error {
+	var firstErr error
+	for key, idx := range s.loadedVectorIndexes("") {
+		dbId, tableName, _ := strings.Cut(key, "/")
+		db, release, err := s.getDB(dbId)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		err = s.saveVectorIndex(db, dbId, tableName, idx)
+		release()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// unloadVectorIndexes forgets and saves the indexes of a database that is
+// being closed, so idle databases do not keep their vectors in memory
+func (s *DocumentStore) unloadVectorIndexes(dbId string, db *sql.DB) {
+	prefix := dbId + "/"
+	loaded := s.loadedVectorIndexes(prefix)
+
+	// Indexes loaded again in the meantime belong to a newer connection
+	s.indexMu.Lock()
+	for key, idx := range loaded {
+		if e, ok := s.indexes[key]; ok && e.idx == idx {
+			delete(s.indexes, key)
+		}
+	}
+	s.indexMu.Unlock()
+
+	for key, idx := range loaded {
+		tableName := strings.TrimPrefix(key, prefix)
+		if err := s.saveVectorIndex(db, dbId, tableName, idx); err != nil {
+			log.Printf("Failed to save vector index for %s.%s: %v", dbId, tableName, err)
+		}
+	}
+}
+
+// searchVectorIndex answers a vector query from the HNSW index. It returns
+// ok=false when the index cannot produce enough filtered results and the
+// caller should fall back to an exact scan.
+func (s *DocumentStore) searchVectorIndex(db *sql.DB, dbId, tableName string, idx *hnswIndex, queryVector []float32, limit int, metric string, filters map[string]interface{}, efSearch int) ([]SearchResult, bool, error) {
+	k := limit
+	if len(filters) > 0 {
+		k = limit * hnswFilterOversample
+	}
+	if efSearch <= 0 {
+		efSearch = hnswDefaultEfSearch
+	}
+
+	hits := idx.Search(queryVector, k, efSearch)
+	if len(hits) == 0 {
+		return nil, idx.Len() == 0, nil
+	}
+
+	filterClause, filterArgs, err := buildFilterClause(filters, "")
+	if err != nil {
+		return nil, false, err
+	}
+
+	placeholders := make([]string, len(hits))
+	args := make([]interface{}, 0, len(hits)+len(filterArgs))
+	for i, hit := range hits {
+		placeholders[i] = "?"
+		args = append(args, hit.ID)
+	}
+	args = append(args, filterArgs...)
+
+	query := fmt.Sprintf(`
+		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded,
+		       parent_id, chunk_index, chunk_count
+		FROM "%s"
+		WHERE id IN (%s) AND is_embedded = 1 AND vector IS NOT NULL%s
+	`, tableName, strings.Join(placeholders, ", "), filterClause)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var doc Document
+		var metadataJSON string
+		var tagsStr string
+		var vectorBytes []byte
+		var isEmbedded int
+		var parentID sql.NullString
+		var chunkIndex, chunkCount int
+
+		if err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &tagsStr, &vectorBytes,
+			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &parentID, &chunkIndex, &chunkCount); err != nil {
+			return nil, false, err
+		}
+
+		doc.DB = dbId
+		doc.Table = tableName
+		doc.IsEmbedded = isEmbedded == 1
+		doc.setChunkInfo(parentID, chunkIndex, chunkCount)
+
+		if metadataJSON != "" {
+			_ = json.Unmarshal([]byte(metadataJSON), &doc.Metadata)
+		}
+		if tagsStr != "" {
+			doc.Tags = strings.Split(tagsStr, ",")
+		}
+		doc.Vector = deserializeVector(vectorBytes)
+
+		results = append(results, SearchResult{
+			Document: doc,
+			Score:    vectorScore(metric, queryVector, doc.Vector),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// Filters removed too many candidates while the index still holds more
+	if len(filters) > 0 && len(results) < limit && len(hits) == k && idx.Len() > k {
+		return nil, false, nil
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+
+	return results, true, nil
+}
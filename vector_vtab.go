@@ -1,31 +1,58 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"modernc.org/sqlite"
 	"modernc.org/sqlite/vtab"
 )
 
+// Column positions in the vec_search schema
+const (
+	vecColDocID = iota
+	vecColDistance
+	vecColRank
+	vecColDBID        // HIDDEN
+	vecColQueryVector // HIDDEN
+	vecColTableName   // HIDDEN
+	vecColK           // HIDDEN
+)
+
+// defaultVectorTableLimit is the number of rows vec_search returns without a k constraint
+const defaultVectorTableLimit = 10
+
+// vectorModuleName is the module behind the vec_search table
+const vectorModuleName = "vec_search"
+
 // VectorModule implements a virtual table for vector similarity search
-type VectorModule struct {
-	dimensions int
-	metric     string // "cosine", "euclidean", or "dot"
-	store      *DocumentStore
-}
+// It is registered once for the whole process; the connector argument of
+// each table tells which store and database it searches.
+//
+// Example, using the vec_search table available on every connection:
+//
+//	SELECT v.doc_id, v.distance, d.content
+//	FROM vec_search v JOIN notes d ON d.id = v.doc_id
+//	WHERE v.table_name = 'notes' AND v.query_vector = ? AND v.k = 5
+//	ORDER BY v.rank
+type VectorModule struct{}
 
 // VectorTable represents a vector search table instance
 type VectorTable struct {
 	module     *VectorModule
 	dbId       string
+	tableName  string
 	store      *DocumentStore
-	dimensions int
-	metric     string
+	dimensions int    // Expected query dimensions, 0 for the table's
+	metric     string // "cosine", "euclidean", or "dot", "" for the table's
 }
 
 // VectorCursor scans through search results
@@ -34,17 +61,112 @@ type VectorCursor struct {
 	results     []SearchResult
 	currentRow  int
 	queryVector []float32
+	dbId        string
+	tableName   string
+	metric      string
+	limit       int
+}
+
+var (
+	registerVectorModuleOnce sync.Once
+	registerVectorModuleErr  error
+
+	// vectorConnectors resolves the connector argument of vec_search tables
+	vectorConnectorsMu sync.Mutex
+	vectorConnectors   = make(map[int]*vectorConnector)
+	vectorConnectorSeq int
+)
+
+// vectorConnector opens the connections of a database and creates a TEMP
+// vec_search table on each of them, so nothing is persisted in the database
+// file and every connection searches the store and database it belongs to.
+// It stays registered in vectorConnectors until its sql.DB is closed.
+type vectorConnector struct {
+	id    int
+	dsn   string
+	store *DocumentStore
+	dbId  string
 }
 
-// RegisterVectorModule registers the vector search virtual table module
-func RegisterVectorModule(db *sql.DB, store *DocumentStore) error {
-	module := &VectorModule{
-		dimensions: 768, // 384 - default
-		metric:     "cosine",
-		store:      store,
+// newVectorConnector creates the connector of a database
+func newVectorConnector(dsn string, store *DocumentStore, dbId string) *vectorConnector {
+	vectorConnectorsMu.Lock()
+	defer vectorConnectorsMu.Unlock()
+
+	vectorConnectorSeq++
+	c := &vectorConnector{id: vectorConnectorSeq, dsn: dsn, store: store, dbId: dbId}
+	vectorConnectors[c.id] = c
+	return c
+}
+
+// sqliteDriver opens the connections of vectorConnector
+var sqliteDriver = &sqlite.Driver{}
+
+// Connect opens a connection with vec_search available
+func (c *vectorConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	// The driver installs registered modules on connections opened afterwards
+	registerVectorModuleOnce.Do(func() {
+		registerVectorModuleErr = vtab.RegisterModule(nil, vectorModuleName, &VectorModule{})
+	})
+	if registerVectorModuleErr != nil {
+		return nil, fmt.Errorf("failed to register vector module: %w", registerVectorModuleErr)
+	}
+
+	conn, err := sqliteDriver.Open(c.dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	return vtab.RegisterModule(db, "vec_search", module)
+	query := fmt.Sprintf(`CREATE VIRTUAL TABLE temp.vec_search USING %s(connector=%d)`, vectorModuleName, c.id)
+	if _, err := conn.(driver.ExecerContext).ExecContext(ctx, query, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create vec_search table: %w", err)
+	}
+	return conn, nil
+}
+
+// Driver returns the sqlite driver
+func (c *vectorConnector) Driver() driver.Driver {
+	return sqliteDriver
+}
+
+// Close is called by sql.DB.Close and forgets the connector
+func (c *vectorConnector) Close() error {
+	vectorConnectorsMu.Lock()
+	defer vectorConnectorsMu.Unlock()
+
+	delete(vectorConnectors, c.id)
+	return nil
+}
+
+// dropPersistedVectorTable removes the vec_search table earlier versions
+// created in the database file. It has no connector argument, so it cannot
+// be connected to drop it with DROP TABLE; a virtual table has no storage of
+// its own, so removing its schema entry is enough.
+func dropPersistedVectorTable(db *sql.DB) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = 'vec_search'`).Scan(&count)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	// writable_schema applies to a single connection
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, stmt := range []string{
+		`PRAGMA writable_schema = ON`,
+		`DELETE FROM main.sqlite_master WHERE type = 'table' AND name = 'vec_search'`,
+		`PRAGMA writable_schema = RESET`,
+	} {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("failed to drop persisted vec_search table: %w", err)
+		}
+	}
+	return nil
 }
 
 // Create is called when CREATE VIRTUAL TABLE is executed
@@ -57,12 +179,7 @@ func (m *VectorModule) Connect(ctx vtab.Context, args []string) (vtab.Table, err
 	// args[0] = module name, args[1] = database name, args[2] = table name
 	// args[3+] = module arguments from USING vec_search(...)
 
-	table := &VectorTable{
-		module:     m,
-		dimensions: m.dimensions,
-		metric:     m.metric,
-		store:      m.store,
-	}
+	table := &VectorTable{module: m}
 
 	// Parse module arguments if provided
 	if len(args) > 3 {
@@ -75,6 +192,16 @@ func (m *VectorModule) Connect(ctx vtab.Context, args []string) (vtab.Table, err
 			value := strings.Trim(strings.TrimSpace(parts[1]), "'\"")
 
 			switch key {
+			case "connector":
+				id, _ := strconv.Atoi(value)
+				vectorConnectorsMu.Lock()
+				if c, ok := vectorConnectors[id]; ok {
+					table.store = c.store
+					if table.dbId == "" {
+						table.dbId = c.dbId
+					}
+				}
+				vectorConnectorsMu.Unlock()
 			case "dim", "dimensions":
 				if dim, err := strconv.Atoi(value); err == nil {
 					table.dimensions = dim
@@ -83,14 +210,21 @@ func (m *VectorModule) Connect(ctx vtab.Context, args []string) (vtab.Table, err
 				table.metric = value
 			case "db", "database":
 				table.dbId = value
+			case "table":
+				if !isValidTableName(value) {
+					return nil, fmt.Errorf("invalid table name: %s", value)
+				}
+				table.tableName = value
 			}
 		}
 	}
 
+	if table.store == nil {
+		return nil, fmt.Errorf("vec_search: no document store registered")
+	}
+
 	// Declare the table schema
-	schema := fmt.Sprintf(
-		"CREATE TABLE x(doc_id TEXT, distance FLOAT, rank INT, db_id HIDDEN TEXT, query_vector HIDDEN BLOB)",
-	)
+	schema := "CREATE TABLE x(doc_id TEXT, distance FLOAT, rank INT, db_id HIDDEN TEXT, query_vector HIDDEN BLOB, table_name HIDDEN TEXT, k HIDDEN INT)"
 
 	if err := ctx.Declare(schema); err != nil {
 		return nil, err
@@ -100,36 +234,46 @@ func (m *VectorModule) Connect(ctx vtab.Context, args []string) (vtab.Table, err
 }
 
 // BestIndex helps SQLite's query planner choose the best way to scan this table
+// Equality constraints on the hidden columns are passed to Filter in the
+// order recorded in IdxStr
 func (t *VectorTable) BestIndex(info *vtab.IndexInfo) error {
 	// We need a query_vector constraint to perform vector search
 	hasQueryVector := false
+	hasTable := t.tableName != ""
 	argIdx := 0
+	var order []string
 
 	for i := range info.Constraints {
 		constraint := &info.Constraints[i]
 
-		if !constraint.Usable {
+		if !constraint.Usable || constraint.Op != vtab.OpEQ {
 			continue
 		}
 
-		// Look for query_vector = ? constraint (column 4)
-		if constraint.Column == 4 && constraint.Op == vtab.OpEQ {
-			constraint.ArgIndex = argIdx
-			constraint.Omit = true
+		var name string
+		switch constraint.Column {
+		case vecColQueryVector:
+			name = "query_vector"
 			hasQueryVector = true
-			argIdx++
+		case vecColDBID:
+			name = "db_id"
+		case vecColTableName:
+			name = "table_name"
+			hasTable = true
+		case vecColK:
+			name = "k"
+		default:
+			continue
 		}
 
-		// Look for db_id = ? constraint (column 3)
-		if constraint.Column == 3 && constraint.Op == vtab.OpEQ {
-			constraint.ArgIndex = argIdx
-			constraint.Omit = true
-			argIdx++
-		}
+		constraint.ArgIndex = argIdx
+		constraint.Omit = true
+		order = append(order, name)
+		argIdx++
 	}
 
-	if !hasQueryVector {
-		// Very high cost if no query vector provided
+	if !hasQueryVector || !hasTable {
+		// Very high cost if no query vector or table provided
 		info.EstimatedCost = 1e10
 		info.EstimatedRows = 0
 		return nil
@@ -137,8 +281,9 @@ func (t *VectorTable) BestIndex(info *vtab.IndexInfo) error {
 
 	// Low cost for vector search
 	info.EstimatedCost = 100
-	info.EstimatedRows = 10
+	info.EstimatedRows = defaultVectorTableLimit
 	info.IdxNum = 1 // Indicate we have a valid index
+	info.IdxStr = strings.Join(order, ",")
 
 	return nil
 }
@@ -163,45 +308,82 @@ func (t *VectorTable) Destroy() error {
 
 // Filter initializes the cursor with search parameters
 func (c *VectorCursor) Filter(idxNum int, idxStr string, vals []vtab.Value) error {
-	if len(vals) == 0 {
-		return fmt.Errorf("query vector required")
+	if idxNum != 1 {
+		return fmt.Errorf("vec_search requires query_vector and table_name constraints")
 	}
 
-	// Extract query vector from first argument
-	queryVectorBytes, ok := vals[0].([]byte)
-	if !ok {
-		return fmt.Errorf("query vector must be a blob")
-	}
+	c.dbId = c.table.dbId
+	c.tableName = c.table.tableName
+	c.metric = c.table.metric
+	c.limit = defaultVectorTableLimit
+	c.queryVector = nil
 
-	c.queryVector = deserializeVector(queryVectorBytes)
+	for i, name := range strings.Split(idxStr, ",") {
+		if i >= len(vals) {
+			break
+		}
 
-	// Extract db_id if provided
-	dbId := c.table.dbId
-	if len(vals) > 1 {
-		if id, ok := vals[1].(string); ok {
-			dbId = id
+		switch name {
+		case "query_vector":
+			// Extract query vector
+			queryVectorBytes, ok := vals[i].([]byte)
+			if !ok {
+				return fmt.Errorf("query vector must be a blob")
+			}
+			c.queryVector = deserializeVector(queryVectorBytes)
+		case "db_id":
+			if id, ok := vals[i].(string); ok {
+				c.dbId = id
+			}
+		case "table_name":
+			name, ok := vals[i].(string)
+			if !ok || !isValidTableName(name) {
+				return fmt.Errorf("invalid table name: %v", vals[i])
+			}
+			c.tableName = name
+		case "k":
+			if k, ok := vals[i].(int64); ok && k > 0 {
+				c.limit = int(k)
+			}
 		}
 	}
 
-	if dbId == "" {
+	if len(c.queryVector) == 0 {
+		return fmt.Errorf("query vector required")
+	}
+	if c.dbId == "" {
 		return fmt.Errorf("database id required")
 	}
+	if c.tableName == "" {
+		return fmt.Errorf("table name required")
+	}
 
-	// TODO: Virtual table needs to be updated to work with dynamic table names
-	// For now, this feature is disabled until the table name can be determined
-	return fmt.Errorf("vector virtual table not yet compatible with dynamic tables")
+	// The searched table's settings apply unless the module arguments override them
+	settings, err := c.table.store.GetTableSettings(c.dbId, c.tableName)
+	if err != nil {
+		return fmt.Errorf("vector search failed: %w", err)
+	}
+	if c.metric == "" {
+		c.metric = settings.Metric
+	}
+	dimensions := c.table.dimensions
+	if dimensions == 0 {
+		dimensions = settings.Dimensions
+	}
+	if dimensions > 0 && len(c.queryVector) != dimensions {
+		return fmt.Errorf("expected query vector dimension %d, got %d", dimensions, len(c.queryVector))
+	}
 
 	// Perform vector search
-	// Note: This needs a table name parameter which isn't available in the current vtab design
-	// results, err := c.table.store.SearchVector(dbId, tableName, c.queryVector, 100)
-	// if err != nil {
-	// 	return fmt.Errorf("vector search failed: %w", err)
-	// }
+	results, err := c.table.store.searchVectorSimilarity(c.dbId, c.tableName, c.queryVector, c.limit, c.metric, nil, VectorSearchOptions{})
+	if err != nil {
+		return fmt.Errorf("vector search failed: %w", err)
+	}
 
-	// c.results = results
-	// c.currentRow = 0
+	c.results = results
+	c.currentRow = 0
 
-	// return nil
+	return nil
 }
 
 // Column returns the value for the requested column
@@ -213,16 +395,20 @@ func (c *VectorCursor) Column(col int) (vtab.Value, error) {
 	result := c.results[c.currentRow]
 
 	switch col {
-	case 0: // doc_id
+	case vecColDocID:
 		return result.Document.ID, nil
-	case 1: // distance
-		return result.Score, nil
-	case 2: // rank
+	case vecColDistance:
+		return scoreToDistance(c.metric, result.Score), nil
+	case vecColRank:
 		return int64(result.Rank), nil
-	case 3: // db_id (HIDDEN)
-		return result.Document.DB, nil
-	case 4: // query_vector (HIDDEN)
+	case vecColDBID:
+		return c.dbId, nil
+	case vecColQueryVector:
 		return serializeVector(c.queryVector), nil
+	case vecColTableName:
+		return c.tableName, nil
+	case vecColK:
+		return int64(c.limit), nil
 	default:
 		return nil, fmt.Errorf("invalid column: %d", col)
 	}
@@ -250,6 +436,16 @@ func (c *VectorCursor) Close() error {
 	return nil
 }
 
+// scoreToDistance converts a similarity score to a distance (lower is closer)
+func scoreToDistance(metric string, score float64) float64 {
+	switch metric {
+	case "euclidean", "dot":
+		return -score
+	default:
+		return 1 - score
+	}
+}
+
 // Vector similarity functions
 
 func cosineSimilarity(a, b []float32) float64 {
@@ -298,18 +494,52 @@ func dotProduct(a, b []float32) float64 {
 	return product
 }
 
+// vectorScore computes the similarity of two vectors under a metric (higher is better)
+func vectorScore(metric string, a, b []float32) float64 {
+	switch metric {
+	case "cosine":
+		return cosineSimilarity(a, b)
+	case "euclidean":
+		return -euclideanDistance(a, b) // Negative so higher is better
+	case "dot":
+		return dotProduct(a, b)
+	default:
+		return cosineSimilarity(a, b)
+	}
+}
+
 // Update SearchVector in store to use actual vector similarity
-func (s *DocumentStore) searchVectorSimilarity(dbId, tableName string, queryVector []float32, limit int, metric string, filters map[string]interface{}) ([]SearchResult, error) {
-	db, err := s.getDB(dbId)
+// Uses the table's HNSW index unless an exact scan is requested or the index
+// cannot serve the query; otherwise every stored vector is compared
+func (s *DocumentStore) searchVectorSimilarity(dbId, tableName string, queryVector []float32, limit int, metric string, filters map[string]interface{}, opts VectorSearchOptions) ([]SearchResult, error) {
+	db, release, err := s.getDB(dbId)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	if !opts.Exact && limit > 0 {
+		idx, err := s.vectorIndex(dbId, tableName)
+		if err == nil && idx.metric == metric {
+			results, ok, err := s.searchVectorIndex(db, dbId, tableName, idx, queryVector, limit, metric, filters, opts.EfSearch)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return results, nil
+			}
+		}
+	}
 
 	// Build filter clause
-	filterClause, filterArgs := buildFilterClause(filters, "")
+	filterClause, filterArgs, err := buildFilterClause(filters, "")
+	if err != nil {
+		return nil, err
+	}
 
 	query := fmt.Sprintf(`
-		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded
+		SELECT id, content, metadata, tags, vector, created_at, updated_at, is_embedded,
+		       parent_id, chunk_index, chunk_count
 		FROM "%s"
 		WHERE is_embedded = 1 AND vector IS NOT NULL%s
 	`, tableName, filterClause)
@@ -327,9 +557,11 @@ func (s *DocumentStore) searchVectorSimilarity(dbId, tableName string, queryVect
 		var tagsStr string
 		var vectorBytes []byte
 		var isEmbedded int
+		var parentID sql.NullString
+		var chunkIndex, chunkCount int
 
 		err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &tagsStr, &vectorBytes,
-			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded)
+			&doc.CreatedAt, &doc.UpdatedAt, &isEmbedded, &parentID, &chunkIndex, &chunkCount)
 		if err != nil {
 			continue
 		}
@@ -337,6 +569,7 @@ func (s *DocumentStore) searchVectorSimilarity(dbId, tableName string, queryVect
 		doc.DB = dbId
 		doc.Table = tableName
 		doc.IsEmbedded = isEmbedded == 1
+		doc.setChunkInfo(parentID, chunkIndex, chunkCount)
 
 		if metadataJSON != "" {
 			_ = json.Unmarshal([]byte(metadataJSON), &doc.Metadata)
@@ -350,17 +583,7 @@ func (s *DocumentStore) searchVectorSimilarity(dbId, tableName string, queryVect
 			docVector := deserializeVector(vectorBytes)
 
 			// Calculate similarity based on metric
-			var score float64
-			switch metric {
-			case "cosine":
-				score = cosineSimilarity(queryVector, docVector)
-			case "euclidean":
-				score = -euclideanDistance(queryVector, docVector) // Negative so higher is better
-			case "dot":
-				score = dotProduct(queryVector, docVector)
-			default:
-				score = cosineSimilarity(queryVector, docVector)
-			}
+			score := vectorScore(metric, queryVector, docVector)
 
 			results = append(results, SearchResult{
 				Document: doc,
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVectorSearchVirtualTable(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	dbName := "test_db"
+	tableName := "notes"
+
+	docs := []*Document{
+		{ID: "doc1", Content: "about cats", Vector: []float32{1, 0, 0}},
+		{ID: "doc2", Content: "about dogs", Vector: []float32{0, 1, 0}},
+		{ID: "doc3", Content: "about kittens", Vector: []float32{0.9, 0.1, 0}},
+	}
+	for _, doc := range docs {
+		if err := store.StoreDocument(dbName, tableName, doc); err != nil {
+			t.Fatalf("Failed to store document %s: %v", doc.ID, err)
+		}
+	}
+
+	db, release, err := store.getDB(dbName)
+	if err != nil {
+		t.Fatalf("Failed to get database: %v", err)
+	}
+	defer release()
+
+	query := serializeVector([]float32{1, 0, 0})
+
+	t.Run("Join with table_name constraint", func(t *testing.T) {
+		rows, err := db.Query(`
+			SELECT v.doc_id, v.rank, d.content
+			FROM vec_search v JOIN notes d ON d.id = v.doc_id
+			WHERE v.table_name = ? AND v.query_vector = ? AND v.k = 2
+			ORDER BY v.rank
+		`, tableName, query)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer rows.Close()
+
+		var got []string
+		for rows.Next() {
+			var id, content string
+			var rank int
+			if err := rows.Scan(&id, &rank, &content); err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			got = append(got, id)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("Rows failed: %v", err)
+		}
+
+		want := []string{"doc1", "doc3"}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("position %d: got %s, want %s", i, got[i], want[i])
+			}
+		}
+	})
+
+	t.Run("Available on every connection", func(t *testing.T) {
+		// Hold several connections at once so the pool opens new ones
+		var conns []interface{ Close() error }
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for i := 0; i < 3; i++ {
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("Conn failed: %v", err)
+			}
+			conns = append(conns, conn)
+
+			var id string
+			var distance float64
+			err = conn.QueryRowContext(context.Background(),
+				`SELECT doc_id, distance FROM vec_search WHERE table_name = 'notes' AND query_vector = ? ORDER BY rank LIMIT 1`, query).Scan(&id, &distance)
+			if err != nil {
+				t.Fatalf("connection %d: query failed: %v", i, err)
+			}
+			if id != "doc1" || distance > 1e-6 {
+				t.Errorf("connection %d: got %s at distance %f, want doc1 at 0", i, id, distance)
+			}
+		}
+
+		var persisted int
+		db.QueryRow(`SELECT COUNT(*) FROM main.sqlite_master WHERE name = 'vec_search'`).Scan(&persisted)
+		if persisted != 0 {
+			t.Errorf("vec_search is stored in the database file")
+		}
+	})
+
+	t.Run("Missing table is rejected", func(t *testing.T) {
+		rows, err := db.Query(`SELECT doc_id FROM vec_search WHERE query_vector = ?`, query)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+			rows.Close()
+		}
+		if err == nil {
+			t.Errorf("expected error without table_name constraint")
+		}
+	})
+
+	t.Run("Table metric applies", func(t *testing.T) {
+		if err := store.StoreDocument(dbName, "points", &Document{ID: "p1", Content: "far", Vector: []float32{3, 4, 0}}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+		settings := &TableSettings{Metric: MetricEuclidean}
+		if err := settings.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if err := store.PutTableSettings(dbName, "points", settings); err != nil {
+			t.Fatalf("PutTableSettings failed: %v", err)
+		}
+
+		var distance float64
+		err := db.QueryRow(`SELECT distance FROM vec_search WHERE table_name = 'points' AND query_vector = ?`,
+			serializeVector([]float32{0, 0, 0})).Scan(&distance)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if distance != 5 {
+			t.Errorf("distance = %f, want the euclidean distance 5", distance)
+		}
+	})
+
+	t.Run("Hidden from table listing", func(t *testing.T) {
+		tables, err := store.ListTables(dbName)
+		if err != nil {
+			t.Fatalf("ListTables failed: %v", err)
+		}
+		for _, name := range tables {
+			if name == "vec_search" {
+				t.Errorf("vec_search listed as a document table")
+			}
+		}
+	})
+}
+
+func TestVectorSearchReopenedDatabases(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	// Alternating between two databases closes and reopens them every time
+	store.SetMaxOpenDatabases(1)
+	query := serializeVector([]float32{1, 0, 0})
+	for i := 0; i < 10; i++ {
+		dbName := []string{"a", "b"}[i%2]
+		if err := store.StoreDocument(dbName, "notes", &Document{ID: "doc1", Content: "x", Vector: []float32{1, 0, 0}}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		db, release, err := store.getDB(dbName)
+		if err != nil {
+			t.Fatalf("Failed to get database: %v", err)
+		}
+		var id string
+		err = db.QueryRow(`SELECT doc_id FROM vec_search WHERE table_name = 'notes' AND query_vector = ?`, query).Scan(&id)
+		release()
+		if err != nil || id != "doc1" {
+			t.Fatalf("reopen %d: got %q, %v", i, id, err)
+		}
+	}
+
+	vectorConnectorsMu.Lock()
+	defer vectorConnectorsMu.Unlock()
+	if len(vectorConnectors) > 2 {
+		t.Errorf("%d connectors registered for 2 databases", len(vectorConnectors))
+	}
+}
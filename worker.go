@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEmbeddingWorkers = 2
+	embeddingPollInterval   = 10 * time.Second // Catches jobs whose backoff expired
+	embeddingJobLease       = 5 * time.Minute  // A claimed job is retried after this if the worker never reports back
+)
+
+// EmbeddingWorkerPool embeds queued documents with several concurrent workers
+// Workers sleep until StoreDocument signals new jobs or the poll interval
+// passes, and take the databases with queued jobs in round-robin order so
+// one busy database cannot starve the others.
+type EmbeddingWorkerPool struct {
+	store     *DocumentStore
+	embedders Embedders
+	workers   int
+	batchSize int
+
+	mu     sync.Mutex // Guards cursor
+	cursor int        // Database to try first on the next claim
+	wg     sync.WaitGroup
+}
+
+// NewEmbeddingWorkerPool creates a pool with embedder as the default
+// embedder; zero values select the defaults
+func NewEmbeddingWorkerPool(store *DocumentStore, embedder Embedder, workers, batchSize int) *EmbeddingWorkerPool {
+	if workers <= 0 {
+		workers = defaultEmbeddingWorkers
+	}
+	if batchSize <= 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+
+	return &EmbeddingWorkerPool{
+		store:     store,
+		embedders: Embedders{DefaultEmbedderName: embedder},
+		workers:   workers,
+		batchSize: batchSize,
+	}
+}
+
+// SetEmbedders sets the embedders tables can select in their settings
+func (p *EmbeddingWorkerPool) SetEmbedders(embedders Embedders) {
+	p.embedders = embedders
+}
+
+// Start launches the workers. They stop once ctx is cancelled, after
+// finishing the batch they are working on within shutdownTimeout; use Wait
+// to block until then.
+func (p *EmbeddingWorkerPool) Start(ctx context.Context) {
+	log.Printf("Starting %d embedding workers (batch size %d)", p.workers, p.batchSize)
+
+	// Jobs left over from the last run can be in any database
+	if err := p.store.seedPendingEmbeddingJobs(); err != nil {
+		log.Printf("Error listing databases for embedding: %v", err)
+	}
+
+	for range p.workers {
+		p.wg.Add(1)
+		go p.run(ctx)
+	}
+}
+
+// Wait blocks until every worker has drained its in-flight batch and exited
+func (p *EmbeddingWorkerPool) Wait() {
+	p.wg.Wait()
+	log.Println("Embedding workers stopped")
+}
+
+func (p *EmbeddingWorkerPool) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(embeddingPollInterval)
+	defer timer.Stop()
+
+	for ctx.Err() == nil {
+		if p.ProcessBatch(ctx) {
+			// There may be more work, check again right away
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(embeddingPollInterval)
+
+		select {
+		case <-ctx.Done():
+		case <-p.store.EmbeddingJobsReady():
+		case <-timer.C:
+		}
+	}
+}
+
+// ProcessBatch claims and embeds one batch of due jobs
+// Returns false when no database had due jobs. Once ctx is cancelled the
+// batch may run on for shutdownTimeout before its requests are cancelled.
+func (p *EmbeddingWorkerPool) ProcessBatch(ctx context.Context) bool {
+	dbName, tableName, docs := p.claim()
+	if len(docs) == 0 {
+		return false
+	}
+
+	// A full batch suggests more is waiting, let another worker start on it
+	if len(docs) == p.batchSize {
+		p.store.notifyEmbeddingJobs(dbName)
+	}
+
+	batchCtx, cancel := drainContext(ctx, shutdownTimeout)
+	defer cancel()
+
+	vectors := make([][]float32, len(docs))
+	errs := make([]error, len(docs))
+	embedder, err := p.tableEmbedder(dbName, tableName)
+	if err == nil {
+		vectors, errs = embedDocumentBatch(batchCtx, embedder, docs, dbName, tableName)
+	} else {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+
+	failed := 0
+	for i, doc := range docs {
+		err := errs[i]
+		if err == nil {
+			// Store the vector, which completes the job unless the content
+			// changed since the claim and the job was queued again
+			_, err = p.store.CompleteEmbeddingJob(dbName, tableName, doc.ID, doc.Content, vectors[i])
+		}
+		if err != nil && batchCtx.Err() != nil {
+			// Not the document's fault, its lease expires and it is claimed again
+			log.Printf("Embedding document %s in table %s.%s was cancelled by shutdown", doc.ID, dbName, tableName)
+			failed++
+		} else if err != nil {
+			log.Printf("Failed to embed document %s in table %s.%s: %v", doc.ID, dbName, tableName, err)
+			if err := p.store.FailEmbeddingJob(dbName, tableName, doc.ID, err); err != nil {
+				log.Printf("Failed to record embedding failure for %s in table %s.%s: %v", doc.ID, dbName, tableName, err)
+			}
+			failed++
+		}
+	}
+
+	log.Printf("Background embedding: processed %d documents from '%s.%s', %d failed", len(docs)-failed, dbName, tableName, failed)
+	return true
+}
+
+// tableEmbedder returns the embedder selected by a table's settings
+func (p *EmbeddingWorkerPool) tableEmbedder(dbName, tableName string) (Embedder, error) {
+	settings, err := p.store.GetTableSettings(dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return p.embedders.Get(settings.Embedder)
+}
+
+// claim leases the next batch of due jobs, visiting the databases with
+// queued jobs round-robin. Databases without pending jobs are dropped from
+// the list; each lease is its own immediate transaction, so concurrent
+// claims never hand out the same job.
+func (p *EmbeddingWorkerPool) claim() (string, string, []*Document) {
+	databases := p.store.pendingEmbeddingJobDatabases()
+	if len(databases) == 0 {
+		return "", "", nil
+	}
+
+	p.mu.Lock()
+	start := p.cursor
+	p.cursor++
+	p.mu.Unlock()
+
+	for i := range databases {
+		dbName := databases[(start+i)%len(databases)]
+
+		tableName, docs, err := p.store.NextEmbeddingBatch(dbName, p.batchSize, embeddingJobLease)
+		if err != nil {
+			log.Printf("Error getting embedding jobs from database %s: %v", dbName, err)
+			continue
+		}
+		if len(docs) > 0 {
+			return dbName, tableName, docs
+		}
+
+		// Clear the flag before checking, so a job queued meanwhile sets it again
+		p.store.setPendingEmbeddingJobs(dbName, false)
+		pending, err := p.store.hasEmbeddingJobs(dbName)
+		if err != nil {
+			log.Printf("Error getting embedding jobs from database %s: %v", dbName, err)
+		}
+		if err != nil || pending {
+			// Leased and backing off jobs are picked up by the poll
+			p.store.setPendingEmbeddingJobs(dbName, true)
+		}
+	}
+
+	return "", "", nil
+}
+
+// drainContext returns a context that outlives the cancellation of ctx by
+// timeout, so work in progress can finish during shutdown
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// embedDocumentBatch embeds documents with one EmbedBatch call. If the batch
+// fails, each document is retried on its own so one bad document does not
+// hold back the rest; errs holds the error of each failed document.
+func embedDocumentBatch(ctx context.Context, embedder Embedder, docs []*Document, dbName, tableName string) ([][]float32, []error) {
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Content
+	}
+
+	errs := make([]error, len(docs))
+
+	batchCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	vectors, err := embedder.EmbedBatch(batchCtx, texts)
+	cancel()
+	if err == nil && len(vectors) == len(docs) {
+		return vectors, errs
+	}
+	if err == nil {
+		err = fmt.Errorf("expected %d embeddings, got %d", len(docs), len(vectors))
+	}
+	if len(docs) == 1 || ctx.Err() != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([][]float32, len(docs)), errs
+	}
+	log.Printf("Batch embedding of %d documents in table %s.%s failed, retrying one by one: %v", len(docs), dbName, tableName, err)
+
+	vectors = make([][]float32, len(docs))
+	for i, doc := range docs {
+		if ctx.Err() != nil {
+			errs[i] = ctx.Err()
+			continue
+		}
+		// Create context with timeout for each document
+		docCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		vectors[i], errs[i] = embedder.Embed(docCtx, doc.Content)
+		cancel() // Clean up context immediately
+	}
+
+	return vectors, errs
+}
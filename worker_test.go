@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingEmbedder signals started on each EmbedBatch call and waits for
+// release before answering, to observe workers mid-batch
+type blockingEmbedder struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (e *blockingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	e.started <- struct{}{}
+	<-e.release
+	vectors := make([][]float32, len(texts))
+	for i := range texts {
+		vectors[i] = []float32{1, 0, 0}
+	}
+	return vectors, nil
+}
+
+func (e *blockingEmbedder) Dimensions() int {
+	return 3
+}
+
+func waitForEmbedded(t *testing.T, store *DocumentStore, dbName, tableName, docID string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if doc, err := store.GetDocument(dbName, tableName, docID); err == nil && doc.IsEmbedded {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("document %s.%s/%s was not embedded in time", dbName, tableName, docID)
+}
+
+func TestEmbeddingWorkerPool(t *testing.T) {
+	store, tmpDir := setupTestStore(t)
+	defer cleanupTestStore(t, store, tmpDir)
+
+	t.Run("Databases take turns", func(t *testing.T) {
+		// db_a has a large backlog, db_b a small one
+		for i := 0; i < 6; i++ {
+			doc := &Document{ID: fmt.Sprintf("a%d", i), Content: fmt.Sprintf("db_a %d", i)}
+			if err := store.StoreDocument("db_a", "notes", doc); err != nil {
+				t.Fatalf("StoreDocument failed: %v", err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			doc := &Document{ID: fmt.Sprintf("b%d", i), Content: fmt.Sprintf("db_b %d", i)}
+			if err := store.StoreDocument("db_b", "notes", doc); err != nil {
+				t.Fatalf("StoreDocument failed: %v", err)
+			}
+		}
+
+		embedder := &batchEmbedder{}
+		pool := NewEmbeddingWorkerPool(store, embedder, 1, 2)
+		for pool.ProcessBatch(context.Background()) {
+		}
+
+		var order []string
+		for _, batch := range embedder.batches {
+			order = append(order, strings.Fields(batch[0])[0])
+		}
+		if got := strings.Join(order, ","); got != "db_a,db_b,db_a,db_a" {
+			t.Errorf("batch order = %s, want db_b served before db_a's backlog is done", got)
+		}
+	})
+
+	t.Run("Only databases with queued jobs are visited", func(t *testing.T) {
+		if pending := store.pendingEmbeddingJobDatabases(); len(pending) != 0 {
+			t.Errorf("drained databases are still visited: %v", pending)
+		}
+
+		// A restarted server looks for jobs left in every database
+		if err := store.seedPendingEmbeddingJobs(); err != nil {
+			t.Fatalf("seedPendingEmbeddingJobs failed: %v", err)
+		}
+		if pending := store.pendingEmbeddingJobDatabases(); strings.Join(pending, ",") != "db_a,db_b" {
+			t.Errorf("seeded databases = %v", pending)
+		}
+		pool := NewEmbeddingWorkerPool(store, &batchEmbedder{}, 1, 2)
+		if pool.ProcessBatch(context.Background()) {
+			t.Error("no jobs should be due")
+		}
+		if pending := store.pendingEmbeddingJobDatabases(); len(pending) != 0 {
+			t.Errorf("databases without jobs are still visited: %v", pending)
+		}
+	})
+
+	t.Run("Claimed jobs are not handed out twice", func(t *testing.T) {
+		if err := store.StoreDocument("db_a", "notes", &Document{ID: "claimed", Content: "claimed"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		_, first, err := store.NextEmbeddingBatch("db_a", 10, time.Minute)
+		if err != nil || len(first) != 1 {
+			t.Fatalf("first claim = %v (%v), want one document", first, err)
+		}
+		_, second, err := store.NextEmbeddingBatch("db_a", 10, time.Minute)
+		if err != nil || len(second) != 0 {
+			t.Errorf("second claim = %v (%v), want none", second, err)
+		}
+
+		if err := store.UpdateDocumentVector("db_a", "notes", "claimed", []float32{1, 0, 0}); err != nil {
+			t.Fatalf("UpdateDocumentVector failed: %v", err)
+		}
+	})
+
+	t.Run("New documents wake the workers", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		pool := NewEmbeddingWorkerPool(store, &batchEmbedder{}, 1, 10)
+		pool.Start(ctx)
+		defer pool.Wait()
+		defer cancel()
+
+		// Let the worker go idle before storing, so only the wakeup can
+		// get the document embedded before the poll interval
+		time.Sleep(50 * time.Millisecond)
+		if err := store.StoreDocument("db_b", "notes", &Document{ID: "woken", Content: "woken"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		waitForEmbedded(t, store, "db_b", "notes", "woken")
+	})
+
+	t.Run("Shutdown drains in-flight batches", func(t *testing.T) {
+		embedder := &blockingEmbedder{started: make(chan struct{}), release: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		pool := NewEmbeddingWorkerPool(store, embedder, 2, 10)
+		pool.Start(ctx)
+
+		if err := store.StoreDocument("db_a", "notes", &Document{ID: "draining", Content: "draining"}); err != nil {
+			t.Fatalf("StoreDocument failed: %v", err)
+		}
+
+		<-embedder.started
+		cancel()
+		close(embedder.release)
+
+		done := make(chan struct{})
+		go func() {
+			pool.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("workers did not stop after cancellation")
+		}
+
+		doc, err := store.GetDocument("db_a", "notes", "draining")
+		if err != nil || !doc.IsEmbedded {
+			t.Errorf("in-flight document should be embedded before shutdown: %+v %v", doc, err)
+		}
+	})
+}
+
+func TestDrainContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drainCtx, stop := drainContext(ctx, 50*time.Millisecond)
+	defer stop()
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if drainCtx.Err() != nil {
+		t.Fatal("work should go on right after cancellation")
+	}
+
+	select {
+	case <-drainCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("work was not cancelled after the drain timeout")
+	}
+}